@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/repository"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+//apiKeyCommand adds the create and revoke subcommands of apikey.
+//They run in the system scope, like the admin routes of an organisation without a key yet.
+func apiKeyCommand(connect func() *repository.Repository) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Command("create", "creates a key and prints its token, it can not be read again", func(cmd *cli.Cmd) {
+			organisationID := cmd.String(cli.StringOpt{
+				Name: "organisation",
+				Desc: "id of the organisation of the key",
+			})
+			name := cmd.String(cli.StringOpt{
+				Name: "name",
+				Desc: "name of the key",
+			})
+			scopes := cmd.String(cli.StringOpt{
+				Name:  "scopes",
+				Desc:  "roles of the key separated by commas - eg. viewer,maker,approver,admin",
+				Value: auth.RoleAdmin,
+			})
+			cmd.Action = func() {
+				if *organisationID == "" || *name == "" {
+					log.Panic("the organisation and the name of the key are required")
+				}
+				repo := connect()
+				defer repo.Database.Close()
+
+				k, token, err := auth.NewAPIKey(*organisationID, *name, strings.Split(*scopes, ","), time.Now().UTC())
+				if err != nil {
+					log.WithError(err).Panic("error creating the api key")
+				}
+				if err = repo.CreateKey(repository.WithSystemScope(context.Background()), k); err != nil {
+					log.WithError(err).Panic("error storing the api key")
+				}
+				log.Infof("created api key %s of the organisation %s with the scopes %s", k.ID, k.OrganisationID, strings.Join(k.Scopes, ","))
+				fmt.Println(token)
+			}
+		})
+
+		cmd.Command("revoke", "revokes a key, the requests with its token are rejected from then on", func(cmd *cli.Cmd) {
+			id := cmd.String(cli.StringOpt{
+				Name: "id",
+				Desc: "id of the key",
+			})
+			cmd.Action = func() {
+				repo := connect()
+				defer repo.Database.Close()
+
+				ctx := repository.WithSystemScope(context.Background())
+				k, err := repo.GetKey(ctx, *id)
+				if err != nil {
+					log.WithError(err).Panic("error reading the api key")
+				}
+				if k.Revoked() {
+					log.Infof("api key %s was already revoked", k.ID)
+					return
+				}
+				now := time.Now().UTC()
+				k.RevokedAt = &now
+				if err = repo.UpdateKey(ctx, k); err != nil {
+					log.WithError(err).Panic("error revoking the api key")
+				}
+				log.Infof("revoked api key %s", k.ID)
+			}
+		})
+	}
+}
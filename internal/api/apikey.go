@@ -0,0 +1,162 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"time"
+)
+
+//apiKeyRequest is the body of a request that creates an api key
+type apiKeyRequest struct {
+	Name string `json:"name"`
+	//OrganisationID can be omitted when the caller has a single organisation
+	OrganisationID string   `json:"organisation_id"`
+	Scopes         []string `json:"scopes"`
+}
+
+//APIKeyToken is an api key with its token. The token is only sent when the key is created or rotated.
+type APIKeyToken struct {
+	model.APIKey
+	Token string `json:"token"`
+}
+
+//CreateAPIKey creates an api key of an organisation of the caller and returns its token
+func CreateAPIKey(keys repository.KeyStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req apiKeyRequest
+		if err := decodeJSON(r, &req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		if p := auth.FromContext(r.Context()); req.OrganisationID == "" && p != nil && len(p.Organisations) == 1 {
+			req.OrganisationID = p.Organisations[0]
+		}
+		if err := validateAPIKey(req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+
+		k, token, err := auth.NewAPIKey(req.OrganisationID, req.Name, req.Scopes, time.Now().UTC())
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		err = keys.CreateKey(r.Context(), k)
+		if err == repository.ErrOutOfScope {
+			SendErrorResponse(w, r, CodeOrganisationDenied, errors.Errorf("organisation_id:%s is not one of the caller", k.OrganisationID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		SendResponse(w, r, http.StatusCreated, APIKeyToken{APIKey: *k, Token: token})
+	})
+}
+
+//validateAPIKey returns a *ValidationError with every field of the request that is not valid
+func validateAPIKey(req apiKeyRequest) error {
+	var fields []FieldError
+	if req.Name == "" {
+		fields = append(fields, FieldError{Field: "name", Rule: "required", Message: "name is required"})
+	}
+	if req.OrganisationID == "" {
+		fields = append(fields, FieldError{Field: "organisation_id", Rule: "required", Message: "organisation_id is required when the caller has several organisations"})
+	}
+	if len(req.Scopes) == 0 {
+		fields = append(fields, FieldError{Field: "scopes", Rule: "required", Message: "scopes is required"})
+	}
+	for _, s := range req.Scopes {
+		if !auth.ValidScope(s) {
+			fields = append(fields, FieldError{Field: "scopes", Rule: "scope", Message: "scope " + s + " is not viewer, maker, approver or admin"})
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+//ListAPIKeys returns the api keys of the organisations of the caller, without their tokens
+func ListAPIKeys(keys repository.KeyStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, err := keys.ListKeys(r.Context())
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if list == nil {
+			list = []model.APIKey{}
+		}
+		SendResponse(w, r, http.StatusOK, list)
+	})
+}
+
+//RotateAPIKey replaces the secret of an api key and returns its new token. The previous token stops working.
+//Returns 409 if the key was revoked.
+func RotateAPIKey(keys repository.KeyStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, ok := getAPIKey(keys, w, r)
+		if !ok {
+			return
+		}
+		if k.Revoked() {
+			SendErrorResponse(w, r, CodeAPIKeyRevoked, errors.Errorf("keyID:%s was revoked", k.ID))
+			return
+		}
+		token, err := auth.RotateAPIKey(k, time.Now().UTC())
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if err = keys.UpdateKey(r.Context(), k); err != nil {
+			sendKeyError(w, r, k.ID, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, APIKeyToken{APIKey: *k, Token: token})
+	})
+}
+
+//RevokeAPIKey revokes an api key, the requests with its token are rejected from then on.
+//Revoking a key already revoked does nothing.
+func RevokeAPIKey(keys repository.KeyStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, ok := getAPIKey(keys, w, r)
+		if !ok {
+			return
+		}
+		if !k.Revoked() {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+			if err := keys.UpdateKey(r.Context(), k); err != nil {
+				sendKeyError(w, r, k.ID, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+//getAPIKey returns the key of the keyID of the url, or sends the error and returns false
+func getAPIKey(keys repository.KeyStore, w http.ResponseWriter, r *http.Request) (*model.APIKey, bool) {
+	keyID := mux.Vars(r)["keyID"]
+	k, err := keys.GetKey(r.Context(), keyID)
+	if err != nil {
+		sendKeyError(w, r, keyID, err)
+		return nil, false
+	}
+	return k, true
+}
+
+//sendKeyError sends the response for an error returned by a repository.KeyStore
+func sendKeyError(w http.ResponseWriter, r *http.Request, keyID string, err error) {
+	if err == repository.ErrKeyNotFound {
+		SendErrorResponse(w, r, CodeAPIKeyNotFound, errors.Errorf("keyID:%s not found", keyID))
+		return
+	}
+	SendErrorResponse(w, r, CodeInternalError, err)
+}
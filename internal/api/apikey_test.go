@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//keyRouter returns a router that authenticates with api keys and the token of an admin key of testOrganisation
+func keyRouter(t *testing.T) (http.Handler, string) {
+	memory := repository.NewMemory()
+	admin, token, err := auth.NewAPIKey(testOrganisation, "admin", []string{auth.RoleAdmin}, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, memory.CreateKey(scoped(), admin))
+	return NewRouter("/v1", memory, Options{Authenticator: auth.APIKeyAuthenticator{Keys: memory}, Keys: memory}), token
+}
+
+func keyRequest(h http.Handler, method, url, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Set(auth.APIKeyHeader, token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+//createdKey returns the key of the body of a create or rotate response
+func createdKey(t *testing.T, rr *httptest.ResponseRecorder) APIKeyToken {
+	var body struct {
+		Data APIKeyToken `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	return body.Data
+}
+
+func TestAPIKeys(t *testing.T) {
+	router, admin := keyRouter(t)
+
+	rr := keyRequest(router, "POST", "/v1/apikeys", admin, `{"name": "ci", "scopes": ["read", "write"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	key := createdKey(t, rr)
+	assert.Equal(t, testOrganisation, key.OrganisationID)
+	assert.NotEmpty(t, key.Token)
+	assert.NotContains(t, rr.Body.String(), `"hash"`)
+
+	//the key can manage the payments but not the keys
+	rr = keyRequest(router, "POST", basePath, key.Token, string(createRequest("1")))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	rr = keyRequest(router, "GET", basePath+"/1", key.Token, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = keyRequest(router, "GET", "/v1/apikeys", key.Token, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+
+	//the admin key can not read the payments
+	rr = keyRequest(router, "GET", basePath+"/1", admin, "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = keyRequest(router, "GET", "/v1/apikeys", admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list struct {
+		Data []model.APIKey `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Equal(t, 2, len(list.Data))
+	assert.NotContains(t, rr.Body.String(), `"token"`)
+
+	//the previous token stops working when the key is rotated
+	rr = keyRequest(router, "POST", "/v1/apikeys/"+key.ID+"/rotate", admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rotated := createdKey(t, rr)
+	assert.NotEqual(t, key.Token, rotated.Token)
+	assert.Equal(t, http.StatusUnauthorized, keyRequest(router, "GET", basePath+"/1", key.Token, "").Code)
+	assert.Equal(t, http.StatusOK, keyRequest(router, "GET", basePath+"/1", rotated.Token, "").Code)
+
+	//a revoked key stops working immediately
+	assert.Equal(t, http.StatusNoContent, keyRequest(router, "DELETE", "/v1/apikeys/"+key.ID, admin, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, keyRequest(router, "GET", basePath+"/1", rotated.Token, "").Code)
+	assert.Equal(t, http.StatusNoContent, keyRequest(router, "DELETE", "/v1/apikeys/"+key.ID, admin, "").Code)
+	rr = keyRequest(router, "POST", "/v1/apikeys/"+key.ID+"/rotate", admin, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"apikey_revoked"`)
+
+	assert.Equal(t, http.StatusNotFound, keyRequest(router, "DELETE", "/v1/apikeys/unknown", admin, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, keyRequest(router, "GET", "/v1/apikeys", "", "").Code)
+}
+
+func TestCreateAPIKey_Invalid(t *testing.T) {
+	router, admin := keyRouter(t)
+
+	rr := keyRequest(router, "POST", "/v1/apikeys", admin, `{"scopes": ["read", "delete"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	for _, field := range []string{`"field":"name","rule":"required"`, `"field":"scopes","rule":"scope"`} {
+		assert.Contains(t, rr.Body.String(), field)
+	}
+
+	rr = keyRequest(router, "POST", "/v1/apikeys", admin, `{"name": "ci", "organisation_id": "other", "scopes": ["read"]}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"organisation_denied"`)
+}
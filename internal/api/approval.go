@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"time"
+)
+
+//RejectionRequest is the body of a rejection request
+type RejectionRequest struct {
+	Reason string `json:"reason"`
+}
+
+//ApprovePayment records the approval of the draft by the caller, submits it and returns the updated payment.
+//Returns 403 if the caller made the payment or has no subject, and 409 if the payment is not a draft.
+//Must be used inside WithPaymentCtx.
+func ApprovePayment(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payment := paymentFromContext(r.Context())
+		if err := payment.Approve(subject(r), time.Now().UTC()); err != nil {
+			sendApprovalError(w, r, err)
+			return
+		}
+		updateReviewed(w, r, repo, payment)
+	})
+}
+
+//RejectPayment records the rejection of the draft by the caller with the reason of the request body and returns the updated payment.
+//The payment stays a draft, so its maker can change it before it is approved.
+//Returns 403 if the caller made the payment or has no subject, and 409 if the payment is not a draft.
+//Must be used inside WithPaymentCtx.
+func RejectPayment(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req RejectionRequest
+		if err := decodeJSON(r, &req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		if req.Reason == "" {
+			SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{{Field: "reason", Rule: "required", Message: "reason is required"}}})
+			return
+		}
+
+		payment := paymentFromContext(r.Context())
+		if err := payment.Reject(subject(r), req.Reason, time.Now().UTC()); err != nil {
+			sendApprovalError(w, r, err)
+			return
+		}
+		updateReviewed(w, r, repo, payment)
+	})
+}
+
+//updateReviewed stores the payment approved or rejected and sends it
+func updateReviewed(w http.ResponseWriter, r *http.Request, repo repository.PaymentTransaction, payment *model.Payment) {
+	if err := repo.Update(r.Context(), payment); err != nil {
+		sendUpdateError(w, r, mux.Vars(r)["paymentID"], err)
+		return
+	}
+	if etag, err := ETag(payment); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	SendResponse(w, r, http.StatusOK, payment)
+}
+
+//sendApprovalError sends the response for an error returned by model.Payment Approve or Reject
+func sendApprovalError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case model.ErrSelfApproval:
+		SendErrorResponse(w, r, CodeSelfApproval, errors.Errorf("paymentID:%s can not be reviewed by its maker or a caller without subject", mux.Vars(r)["paymentID"]))
+	case model.ErrNotPendingApproval:
+		SendErrorResponse(w, r, CodeInvalidTransition, err)
+	default:
+		SendErrorResponse(w, r, CodeInternalError, err)
+	}
+}
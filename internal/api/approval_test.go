@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//asCaller serves the request as the subject of testOrganisation with the roles
+func asCaller(router http.Handler, subject, roles, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	req.Header.Set(auth.SubjectHeader, subject)
+	req.Header.Set(auth.RolesHeader, roles)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestApproval(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+
+	rr := asCaller(router, "alice", "maker,approver", "POST", basePath, string(createRequest(paymentID)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	//the maker can not release its own payment
+	rr = asCaller(router, "alice", "maker", "POST", url+"/status", `{"status": "submitted"}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"approval_required"`)
+	rr = asCaller(router, "alice", "maker,approver", "POST", url+"/approve", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"self_approval"`)
+	rr = asCaller(router, "", "approver", "POST", url+"/approve", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = asCaller(router, "bob", "approver", "POST", url+"/reject", `{}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = asCaller(router, "bob", "approver", "POST", url+"/reject", `{"reason": "wrong amount"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"draft"`)
+
+	//the rejected draft can still be changed by its maker
+	updated := strings.Replace(string(createRequest(paymentID)), `"version": 0`, `"version": 1`, 1)
+	rr = asCaller(router, "alice", "maker", "PUT", url, updated)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	rr = asCaller(router, "carol", "approver", "POST", url+"/approve", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		Data model.Payment `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, model.StatusSubmitted, body.Data.Status)
+	assert.Equal(t, "alice", body.Data.CreatedBy)
+	if assert.Equal(t, 2, len(body.Data.Approvals)) {
+		assert.Equal(t, model.Approval{Decision: model.DecisionRejected, By: "bob", At: body.Data.Approvals[0].At, Reason: "wrong amount"}, body.Data.Approvals[0])
+		assert.Equal(t, model.DecisionApproved, body.Data.Approvals[1].Decision)
+		assert.Equal(t, "carol", body.Data.Approvals[1].By)
+		assert.False(t, body.Data.Approvals[1].At.IsZero())
+	}
+
+	//the decisions are stored with the payment
+	rr = asCaller(router, "dave", "viewer", "GET", url, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"created_by":"alice"`)
+	assert.Contains(t, rr.Body.String(), `"by":"carol"`)
+
+	rr = asCaller(router, "bob", "approver", "POST", url+"/approve", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_transition"`)
+}
+
+func TestApproval_UpdatedByMaker(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	//a maker that changed the draft of a colleague can not approve it either
+	assert.Equal(t, http.StatusNoContent, asCaller(router, "bob", "maker,approver", "PUT", url, string(createRequest(paymentID))).Code)
+	updated := strings.Replace(string(createRequest(paymentID)), `"version": 0`, `"version": 1`, 1)
+	assert.Equal(t, http.StatusNoContent, asCaller(router, "carol", "maker,approver", "PUT", url, updated).Code)
+	rr := asCaller(router, "alice", "maker", "GET", url, "")
+	assert.Contains(t, rr.Body.String(), `"created_by":"alice","updated_by":["bob","carol"]`)
+	for _, maker := range []string{"bob", "carol"} {
+		rr = asCaller(router, maker, "maker,approver", "POST", url+"/approve", "")
+		assert.Equal(t, http.StatusForbidden, rr.Code, maker)
+		assert.Contains(t, rr.Body.String(), `"code":"self_approval"`)
+	}
+
+	assert.Equal(t, http.StatusOK, asCaller(router, "dave", "approver", "POST", url+"/approve", "").Code)
+	rr = asCaller(router, "bob", "maker,approver", "POST", url+"/status", `{"status": "settled"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"self_approval"`)
+}
+
+func TestTransition_Reviewed(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker,approver", "POST", basePath, string(createRequest(paymentID))).Code)
+	assert.Equal(t, http.StatusOK, asCaller(router, "bob", "approver", "POST", url+"/approve", "").Code)
+
+	//the maker can not settle or reject its own submitted payment, even as an approver
+	for _, status := range []string{"settled", "rejected"} {
+		rr := asCaller(router, "alice", "maker", "POST", url+"/status", `{"status": "`+status+`"}`)
+		assert.Equal(t, http.StatusForbidden, rr.Code, status)
+		assert.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+		rr = asCaller(router, "alice", "maker,approver", "POST", url+"/status", `{"status": "`+status+`"}`)
+		assert.Equal(t, http.StatusForbidden, rr.Code, status)
+		assert.Contains(t, rr.Body.String(), `"code":"self_approval"`)
+	}
+	rr := asCaller(router, "", "approver", "POST", url+"/status", `{"status": "settled"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	//an approver can not cancel it, its maker can
+	rr = asCaller(router, "bob", "approver", "POST", url+"/status", `{"status": "cancelled"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+
+	rr = asCaller(router, "carol", "admin", "POST", url+"/status", `{"status": "settled"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"settled"`)
+}
+
+func TestRoles(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	tests := []struct {
+		roles, method, url, body string
+		allowed                  bool
+	}{
+		{"viewer", "GET", url, "", true},
+		{"approver", "GET", "/v1/payments", "", true},
+		{"admin", "GET", url, "", false},
+		{"viewer", "POST", basePath, string(createRequest(uuid.NewRandom().String())), false},
+		{"approver", "PUT", url, string(createRequest(paymentID)), false},
+		{"viewer", "POST", url + "/status", `{"status": "cancelled"}`, false},
+		{"maker", "POST", url + "/approve", "", false},
+		{"viewer", "POST", url + "/reject", `{"reason": "no"}`, false},
+		{"approver", "DELETE", url, "", false},
+	}
+	for _, tt := range tests {
+		rr := asCaller(router, "bob", tt.roles, tt.method, tt.url, tt.body)
+		if tt.allowed {
+			assert.Equal(t, http.StatusOK, rr.Code, tt.roles+" "+tt.method+" "+tt.url)
+			continue
+		}
+		assert.Equal(t, http.StatusForbidden, rr.Code, tt.roles+" "+tt.method+" "+tt.url)
+		assert.Contains(t, rr.Body.String(), `"code":"insufficient_scope"`)
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"strings"
+)
+
+//Authenticate adds the principal of the request to its context and scopes the queries of next to its organisations.
+//The changes of the payments are audited as made by its subject in the request.
+//Returns 401 if the authenticator rejects the request, or if it is nil so the access is denied by default.
+func Authenticate(a auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a == nil {
+			SendErrorResponse(w, r, CodeUnauthenticated, auth.ErrUnauthenticated)
+			return
+		}
+		p, err := a.Authenticate(r)
+		if err == auth.ErrUnauthenticated {
+			SendErrorResponse(w, r, CodeUnauthenticated, err)
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		ctx := auth.WithPrincipal(r.Context(), p)
+		ctx = repository.WithScope(ctx, repository.Scope{Organisations: p.Organisations})
+		ctx = repository.WithActor(ctx, repository.Actor{Subject: p.Subject, RequestID: requestID(r)})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//RequireRole returns 403 if the principal added by Authenticate does not have one of the roles
+func RequireRole(roles []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasRole(r, roles) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(roles) == 1 {
+			SendErrorResponse(w, r, CodeInsufficientScope, errors.Errorf("the %s role is required", roles[0]))
+			return
+		}
+		SendErrorResponse(w, r, CodeInsufficientScope, errors.Errorf("one of the roles %s is required", strings.Join(roles, ", ")))
+	})
+}
+
+//hasRole returns true if the principal added by Authenticate has one of the roles
+func hasRole(r *http.Request, roles []string) bool {
+	p := auth.FromContext(r.Context())
+	if p == nil {
+		return false
+	}
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+//subject returns the subject of the principal added by Authenticate, empty if it has none
+func subject(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Subject
+	}
+	return ""
+}
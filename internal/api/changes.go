@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	maxChangesLimit = 1000
+	//maxChangesWait is the longest wait of a long-polling request, in seconds
+	maxChangesWait = 60
+	//changesWriteMargin is the time to read the feed and write the page after the wait of a long-polling request
+	changesWriteMargin = 5 * time.Second
+)
+
+//changesPollInterval is the time between two reads of the feed while a long-polling request waits for a change
+var changesPollInterval = time.Second
+
+//ChangePage is a page of the change feed. Cursor is the since param of the next page, the same one when the page is empty.
+type ChangePage struct {
+	Changes []repository.Event `json:"changes"`
+	Cursor  string             `json:"cursor"`
+	//HasMore is true if there are changes after the page that can be read without waiting
+	HasMore bool `json:"has_more"`
+}
+
+//changesToken is the content of the opaque since param.
+//The position of an event is its id for the events written before the positions, so the older cursors are still valid.
+type changesToken struct {
+	Position int64 `json:"e"`
+}
+
+//encodeChangesCursor returns the since param of the changes after the position
+func encodeChangesCursor(position int64) string {
+	token, _ := json.Marshal(changesToken{Position: position})
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+//changesQuery is the query of the params of /payments/changes
+type changesQuery struct {
+	After int64
+	Limit int
+	Wait  time.Duration
+}
+
+//parseChangesQuery returns the query of the params, or a *ValidationError with every param that is not valid.
+//An empty since starts at the first change.
+func parseChangesQuery(params url.Values) (changesQuery, error) {
+	var fields []FieldError
+	q := changesQuery{Limit: defaultListLimit}
+
+	if since := params.Get("since"); since != "" {
+		var token changesToken
+		raw, err := base64.RawURLEncoding.DecodeString(since)
+		if err == nil {
+			err = json.Unmarshal(raw, &token)
+		}
+		if err != nil || token.Position < 0 {
+			fields = append(fields, FieldError{Field: "since", Rule: "cursor", Message: "the cursor is not one returned by the change feed"})
+		}
+		q.After = token.Position
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxChangesLimit {
+			fields = append(fields, FieldError{Field: "limit", Rule: "range", Message: fmt.Sprintf("must be between 1 and %d", maxChangesLimit)})
+		}
+		q.Limit = limit
+	}
+	if v := params.Get("wait"); v != "" {
+		wait, err := strconv.Atoi(v)
+		if err != nil || wait < 0 || wait > maxChangesWait {
+			fields = append(fields, FieldError{Field: "wait", Rule: "range", Message: fmt.Sprintf("must be a number of seconds between 0 and %d", maxChangesWait)})
+		}
+		q.Wait = time.Duration(wait) * time.Second
+	}
+
+	if len(fields) > 0 {
+		return q, &ValidationError{Fields: fields}
+	}
+	return q, nil
+}
+
+//GetChanges returns the changes of the payments of the organisations of the caller after the since cursor, in the order they were committed.
+//With a wait param the request long-polls: when there are no changes yet it waits up to wait seconds for one,
+//or until the deadline of the route, then returns an empty page with the same cursor.
+//The deadline of the route allows the longest wait unless it is set shorter in Options.RouteTimeouts.
+//The write deadline of the connection is extended for the wait, when it can not be the wait ends
+//before the writeTimeout of the server, 0 if it has none.
+func GetChanges(feed repository.ChangeFeed, writeTimeout time.Duration) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseChangesQuery(r.URL.Query())
+		if err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+
+		ctx := r.Context()
+		start := time.Now()
+		until := start.Add(q.Wait)
+		//the last read must end before the deadline of the request, or it would fail instead of returning the empty page
+		if deadline, ok := ctx.Deadline(); ok && deadline.Add(-changesPollInterval).Before(until) {
+			until = deadline.Add(-changesPollInterval)
+		}
+		if q.Wait > 0 {
+			//the WriteTimeout of the server is shorter than the longest wait, the page is written after the wait
+			if err := http.NewResponseController(w).SetWriteDeadline(until.Add(changesWriteMargin)); err != nil {
+				logrus.WithError(err).WithField("request_id", requestID(r)).Warn("the write deadline of the long-polling request can not be extended")
+				if limit := start.Add(writeTimeout - changesWriteMargin); writeTimeout > 0 && limit.Before(until) {
+					until = limit
+				}
+			}
+		}
+		var changes []repository.Event
+		for {
+			//one more change to know if there are more after the page
+			changes, err = feed.Changes(ctx, q.After, q.Limit+1)
+			if err != nil {
+				SendErrorResponse(w, r, CodeInternalError, err)
+				return
+			}
+			if len(changes) > 0 || !time.Now().Add(changesPollInterval).Before(until) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				SendErrorResponse(w, r, CodeInternalError, ctx.Err())
+				return
+			case <-time.After(changesPollInterval):
+			}
+		}
+
+		page := ChangePage{Changes: changes, HasMore: len(changes) > q.Limit}
+		if page.HasMore {
+			page.Changes = changes[:q.Limit]
+		}
+		if page.Changes == nil {
+			page.Changes = []repository.Event{}
+		}
+		after := q.After
+		if len(page.Changes) > 0 {
+			after = page.Changes[len(page.Changes)-1].Position
+		}
+		page.Cursor = encodeChangesCursor(after)
+		SendResponseWithLinks(w, r, http.StatusOK, page, &Links{Next: pageURL(r, "since", page.Cursor)})
+	})
+}
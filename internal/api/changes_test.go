@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const changesPath = "/v1/payments/changes"
+
+//changes returns the page of the change feed of the response
+func changes(t *testing.T, rr *httptest.ResponseRecorder) ChangePage {
+	var body struct {
+		Data ChangePage `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	return body.Data
+}
+
+func TestGetChanges(t *testing.T) {
+	memory := repository.NewMemory()
+	router := NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory})
+	for _, id := range []string{"1", "2"} {
+		rr := executeRequest(*router, httptest.NewRequest("POST", basePath, bytes.NewBuffer(createRequest(id))))
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+	//a payment of another organisation is not in the feed
+	other := repository.WithScope(scoped(), repository.Scope{Organisations: []string{"other"}})
+	assert.Nil(t, memory.Create(other, &model.Payment{ID: "3", OrganisationID: "other"}))
+
+	rr := executeRequest(*router, httptest.NewRequest("GET", changesPath+"?limit=1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page := changes(t, rr)
+	if assert.Equal(t, 1, len(page.Changes)) {
+		assert.Equal(t, "1", page.Changes[0].PaymentID)
+		assert.Equal(t, repository.EventCreated, page.Changes[0].Type)
+	}
+	assert.True(t, page.HasMore)
+	assert.Contains(t, rr.Body.String(), "since="+page.Cursor)
+
+	//an edit of a payment listed before is after the cursor
+	rr = executeRequest(*router, httptest.NewRequest("DELETE", basePath+"/1", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = executeRequest(*router, httptest.NewRequest("GET", changesPath+"?since="+page.Cursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page = changes(t, rr)
+	if assert.Equal(t, 2, len(page.Changes)) {
+		assert.Equal(t, "2", page.Changes[0].PaymentID)
+		assert.Equal(t, "1", page.Changes[1].PaymentID)
+		assert.Equal(t, repository.EventDeleted, page.Changes[1].Type)
+	}
+	assert.False(t, page.HasMore)
+
+	//an empty page keeps the cursor
+	cursor := page.Cursor
+	rr = executeRequest(*router, httptest.NewRequest("GET", changesPath+"?since="+cursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page = changes(t, rr)
+	assert.Empty(t, page.Changes)
+	assert.Equal(t, cursor, page.Cursor)
+}
+
+func TestGetChanges_LongPolling(t *testing.T) {
+	defer func(interval time.Duration) { changesPollInterval = interval }(changesPollInterval)
+	changesPollInterval = 10 * time.Millisecond
+	memory := repository.NewMemory()
+	router := NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory})
+
+	//the request waits for the change
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.Nil(t, memory.Create(scoped(), &model.Payment{ID: "1", OrganisationID: testOrganisation}))
+	}()
+	rr := executeRequest(*router, httptest.NewRequest("GET", changesPath+"?wait=5", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page := changes(t, rr)
+	assert.Equal(t, 1, len(page.Changes))
+
+	//the default deadline of the requests does not cut the wait
+	router = NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory, DefaultTimeout: 100 * time.Millisecond})
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		assert.Nil(t, memory.Create(scoped(), &model.Payment{ID: "2", OrganisationID: testOrganisation}))
+	}()
+	rr = executeRequest(*router, httptest.NewRequest("GET", changesPath+"?wait=5&since="+page.Cursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	page = changes(t, rr)
+	assert.Equal(t, 1, len(page.Changes))
+
+	//without a change the request returns an empty page before the deadline of its route
+	router = NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory,
+		RouteTimeouts: map[string]time.Duration{RouteChanges: 100 * time.Millisecond}})
+	start := time.Now()
+	rr = executeRequest(*router, httptest.NewRequest("GET", changesPath+"?wait=5&since="+page.Cursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, changes(t, rr).Changes)
+	assert.True(t, time.Since(start) < time.Second)
+
+	//the recorder can not extend its write deadline, the wait ends before the write timeout of the server
+	router = NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory, WriteTimeout: changesWriteMargin + 100*time.Millisecond})
+	start = time.Now()
+	rr = executeRequest(*router, httptest.NewRequest("GET", changesPath+"?wait=5&since="+page.Cursor, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, changes(t, rr).Changes)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestGetChanges_Invalid(t *testing.T) {
+	memory := repository.NewMemory()
+	router := NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Changes: memory})
+
+	rr := executeRequest(*router, httptest.NewRequest("GET", changesPath+"?since=abc&limit=0&wait=61", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	for _, field := range []string{"since", "limit", "wait"} {
+		assert.Contains(t, rr.Body.String(), fmt.Sprintf(`"field":"%s"`, field))
+	}
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"github.com/plusspeed/payments-api/internal/model"
+	"net/http"
+)
+
+//GetCurrencies returns the ISO 4217 currencies accepted by the service with their minor units.
+func GetCurrencies() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//the table is embedded in the binary and only changes with a new release
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		SendResponse(w, r, http.StatusOK, model.Currencies())
+	})
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"strings"
+)
+
+//ETag returns a strong entity tag derived from the version and the content of the payment.
+func ETag(p *model.Payment) (string, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%s"`, p.Version, hex.EncodeToString(sum[:8])), nil
+}
+
+//CheckIfMatch compares the If-Match header with the ETag of the payment in the request context.
+//Returns 412 if it does not match and, when required is true, 428 if the header is missing.
+//Must be used inside WithPaymentCtx.
+func CheckIfMatch(required bool, next func(repository.PaymentTransaction) http.HandlerFunc) func(repository.PaymentTransaction) http.HandlerFunc {
+	return func(repo repository.PaymentTransaction) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ifMatch := r.Header.Get("If-Match")
+			if ifMatch == "" {
+				if required {
+					SendErrorResponse(w, r, CodeIfMatchRequired, errors.New("If-Match header is required"))
+					return
+				}
+				next(repo).ServeHTTP(w, r)
+				return
+			}
+
+			etag, err := ETag(paymentFromContext(r.Context()))
+			if err != nil {
+				SendErrorResponse(w, r, CodeInternalError, err)
+				return
+			}
+			if !etagMatches(ifMatch, etag, false) {
+				SendErrorResponse(w, r, CodePreconditionFailed, errors.Errorf("If-Match %s does not match the current ETag %s", ifMatch, etag))
+				return
+			}
+			next(repo).ServeHTTP(w, r)
+		})
+	}
+}
+
+//etagMatches reports if etag is in the comma separated list of a If-Match or If-None-Match header.
+//If-None-Match uses the weak comparison, If-Match the strong one.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
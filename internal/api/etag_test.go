@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestETag(t *testing.T) {
+	p := &model.Payment{ID: "1", Version: 1}
+	etag1, err := ETag(p)
+	assert.Nil(t, err)
+
+	p.Version = 2
+	etag2, err := ETag(p)
+	assert.Nil(t, err)
+	assert.NotEqual(t, etag1, etag2, "the etag should change with the version")
+
+	p.OrganisationID = "2"
+	etag3, err := ETag(p)
+	assert.Nil(t, err)
+	assert.NotEqual(t, etag2, etag3, "the etag should change with the content")
+}
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"1-abc"`
+
+	assert.True(t, etagMatches(`"1-abc"`, etag, false))
+	assert.True(t, etagMatches(`"0-def", "1-abc"`, etag, false))
+	assert.True(t, etagMatches(`*`, etag, false))
+	assert.False(t, etagMatches(`"0-def"`, etag, false))
+
+	// weak etags only match with the weak comparison used by If-None-Match
+	assert.False(t, etagMatches(`W/"1-abc"`, etag, false))
+	assert.True(t, etagMatches(`W/"1-abc"`, etag, true))
+}
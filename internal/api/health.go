@@ -8,11 +8,11 @@ import (
 
 //HealthCheckHandler returns 200 if the app is healthy and connected to the db.
 //returns 500 if can't connect to the db.
-func HealthCheckHandler(repo repository.Repository) http.HandlerFunc {
+func HealthCheckHandler(repo repository.PaymentTransaction) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		if _, err := repo.Database.ExecOne("select 'It is running'"); err != nil {
+		if err := repo.Ping(r.Context()); err != nil {
 			io.WriteString(w, `{"alive": false}`)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
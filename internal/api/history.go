@@ -0,0 +1,26 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+)
+
+//GetHistory returns the audit entries of the payment in order, with the payment before and after each change.
+//The history of a deleted payment is still returned.
+func GetHistory(audit repository.AuditLog) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paymentID := mux.Vars(r)["paymentID"]
+		entries, err := audit.History(r.Context(), paymentID)
+		if err == repository.ErrNotFound {
+			SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s has no history", paymentID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, entries)
+	})
+}
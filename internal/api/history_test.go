@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetHistory(t *testing.T) {
+	memory := repository.NewMemory()
+	router := NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Audit: memory})
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+
+	rr := asCaller(router, "alice", "viewer", "GET", url+"/history", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req := httptest.NewRequest("POST", basePath, strings.NewReader(string(createRequest(paymentID))))
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	req.Header.Set(auth.SubjectHeader, "alice")
+	req.Header.Set(RequestIDHeader, "req-1")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "req-1", rr.Header().Get(RequestIDHeader))
+
+	rr = asCaller(router, "alice", "maker", "POST", url+"/status", `{"status": "cancelled"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	statusRequestID := rr.Header().Get(RequestIDHeader)
+	assert.NotEmpty(t, statusRequestID, "a request without id should get one")
+	rr = asCaller(router, "alice", "maker", "DELETE", url, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	//the history is kept after the delete
+	rr = asCaller(router, "bob", "viewer", "GET", url+"/history", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		Data []repository.AuditEntry `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	if assert.Equal(t, 3, len(body.Data)) {
+		assert.Equal(t, repository.AuditCreate, body.Data[0].Action)
+		assert.Equal(t, "req-1", body.Data[0].RequestID)
+		assert.Equal(t, "alice", body.Data[0].Actor)
+		assert.Nil(t, body.Data[0].Before)
+		assert.Equal(t, paymentID, body.Data[0].After.ID)
+		assert.Equal(t, repository.AuditStatus, body.Data[1].Action)
+		assert.Equal(t, statusRequestID, body.Data[1].RequestID)
+		assert.Equal(t, repository.AuditDelete, body.Data[2].Action)
+		assert.Equal(t, "alice", body.Data[2].After.DeletedBy)
+	}
+
+	rr = asCaller(router, "bob", "admin", "GET", url+"/history", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, validRequestID("4ee3a8d8-ca7b-4290-a52c-dd5b6165ec43"))
+	assert.False(t, validRequestID(""))
+	assert.False(t, validRequestID("a b"))
+	assert.False(t, validRequestID("a\nb"))
+	assert.False(t, validRequestID(strings.Repeat("a", maxRequestID+1)))
+}
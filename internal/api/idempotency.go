@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//IdempotencyHeader contains the key a client sends to retry a POST request safely
+const IdempotencyHeader = "Idempotency-Key"
+
+//DefaultIdempotencyTTL is how long the responses are replayed when Options.IdempotencyTTL is 0
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+//unreplayedHeaders are the headers of a response that belong to its request, they are not stored nor replayed.
+//A retry keeps its own X-Request-ID, set by WithRequestID, and the Date of its response.
+var unreplayedHeaders = []string{RequestIDHeader, "Date"}
+
+//idempotencyPendingTTL is how long the key of a request without deadline is claimed, when its instance stops before it finishes
+const idempotencyPendingTTL = time.Minute
+
+//idempotencyPoll is how often a retry checks if the request of its key finished
+const idempotencyPoll = 50 * time.Millisecond
+
+//maxIdempotencyKey is the maximum length of an idempotency key, e.g. a UUID or a hash
+const maxIdempotencyKey = 255
+
+//Idempotent runs the first request with an Idempotency-Key header and stores its response, replayed with the same status,
+//headers, except X-Request-ID and Date, and body to the requests of the same caller with the same key until the ttl expires.
+//Returns 422 if the key was used for a different request. The requests with the same key are serialised by a pending marker
+//of the key, so a retry sent while the first request runs waits for its response without holding a connection.
+//The 5xx responses are not stored, the retries run the request again.
+//The requests without the header, or when store is nil, are not changed. Must be used inside Authenticate.
+func Idempotent(store repository.IdempotencyStore, ttl time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if store == nil || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{{
+				Field:   IdempotencyHeader,
+				Rule:    "max",
+				Message: fmt.Sprintf("the %s header must not be longer than %d characters", IdempotencyHeader, maxIdempotencyKey),
+			}}})
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+		id := idempotencyKey(r, key)
+
+		now := time.Now().UTC()
+		claim := &repository.IdempotentResponse{Key: id, RequestHash: hash, CreatedAt: now, ExpiresAt: now.Add(idempotencyPendingTTL)}
+		if deadline, ok := r.Context().Deadline(); ok {
+			claim.ExpiresAt = deadline
+		}
+		stored, err := claimIdempotencyKey(r.Context(), store, claim)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if stored != nil {
+			if !bytes.Equal(stored.RequestHash, hash) {
+				SendErrorResponse(w, r, CodeIdempotencyKeyReused, errors.Errorf("the %s %s was used for a different request", IdempotencyHeader, key))
+				return
+			}
+			replay(w, stored)
+			return
+		}
+		saved := false
+		defer func() {
+			if !saved {
+				//the marker is deleted even when the deadline of the request expired
+				if err := store.ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), id); err != nil {
+					logrus.WithError(err).WithField("route", r.URL.Path).Warn("error releasing the idempotency key")
+				}
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		now = time.Now().UTC()
+		if ttl <= 0 {
+			ttl = DefaultIdempotencyTTL
+		}
+		err = store.SaveIdempotent(r.Context(), &repository.IdempotentResponse{
+			Key:         id,
+			RequestHash: hash,
+			Status:      rec.status,
+			Header:      replayedHeader(w.Header()),
+			Body:        rec.body.Bytes(),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		})
+		if err != nil {
+			//the response was sent, a retry runs the request again
+			logrus.WithError(err).WithField("route", r.URL.Path).Warn("error storing the idempotent response")
+			return
+		}
+		saved = true
+	})
+}
+
+//claimIdempotencyKey claims the key of the request, or returns the response stored for it.
+//While the request of the key runs it polls the store every idempotencyPoll, so a retry waits for its response.
+//A running request with another hash is returned at once.
+func claimIdempotencyKey(ctx context.Context, store repository.IdempotencyStore, claim *repository.IdempotentResponse) (*repository.IdempotentResponse, error) {
+	for {
+		stored, err := store.ClaimIdempotencyKey(ctx, claim)
+		if err != nil || stored == nil || !stored.Pending() || !bytes.Equal(stored.RequestHash, claim.RequestHash) {
+			return stored, err
+		}
+		select {
+		case <-time.After(idempotencyPoll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+//idempotencyKey returns the key of the caller, so the callers can not replay the responses of the others
+func idempotencyKey(r *http.Request, key string) string {
+	var subject, organisations string
+	if p := auth.FromContext(r.Context()); p != nil {
+		subject, organisations = p.Subject, strings.Join(p.Organisations, ",")
+	}
+	return fmt.Sprintf("%s|%s|%s", organisations, subject, key)
+}
+
+//requestHash returns the hash of the method, the url and the body of the request
+func requestHash(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+//replayedHeader returns a copy of the header of a response without the unreplayedHeaders
+func replayedHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range unreplayedHeaders {
+		header.Del(name)
+	}
+	return header
+}
+
+//replay sends the stored response, with the headers of the current request that are not replayed
+func replay(w http.ResponseWriter, stored *repository.IdempotentResponse) {
+	//the responses stored before the unreplayedHeaders were dropped can still have them
+	for name, values := range replayedHeader(stored.Header) {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+//responseRecorder sends the response and keeps its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//idempotentRequest serves the request of testOrganisation with the idempotency key
+func idempotentRequest(h http.Handler, method, url, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	req.Header.Set(IdempotencyHeader, key)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestIdempotency(t *testing.T) {
+	memory := repository.NewMemory()
+	opts := testOptions
+	opts.Idempotency = memory
+	router := NewRouter("/v1", memory, opts)
+	paymentID := uuid.NewRandom().String()
+
+	rr := idempotentRequest(router, "POST", basePath, "k1", string(createRequest(paymentID)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	//the retry gets the response of the first request without creating the payment again
+	assert.Nil(t, memory.Delete(scoped(), paymentID, repository.AnyVersion))
+	_, err := memory.Purge(scoped(), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	rr = idempotentRequest(router, "POST", basePath, "k1", string(createRequest(paymentID)))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	_, err = memory.Get(scoped(), paymentID)
+	assert.Equal(t, repository.ErrNotFound, err)
+
+	//a key used for a different request
+	rr = idempotentRequest(router, "POST", basePath, "k1", string(createRequest(uuid.NewRandom().String())))
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"idempotency_key_reused"`)
+
+	//the response is replayed with the same status, headers and body, the retry keeps its own request id
+	assert.Equal(t, http.StatusCreated, idempotentRequest(router, "POST", basePath, "k2", string(createRequest(paymentID))).Code)
+	first := idempotentRequest(router, "POST", basePath+"/"+paymentID+"/status", "k3", `{"status": "cancelled"}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	retry := idempotentRequest(router, "POST", basePath+"/"+paymentID+"/status", "k3", `{"status": "cancelled"}`)
+	assert.Equal(t, first.Code, retry.Code)
+	assert.NotEqual(t, first.Header().Get(RequestIDHeader), retry.Header().Get(RequestIDHeader))
+	first.Header().Del(RequestIDHeader)
+	retry.Header().Del(RequestIDHeader)
+	assert.Equal(t, first.Header(), retry.Header())
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	//the errors are replayed too, without the key the request runs again
+	conflict := idempotentRequest(router, "POST", basePath+"/"+paymentID+"/status", "k4", `{"status": "cancelled"}`)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+	assert.Equal(t, conflict.Body.String(), idempotentRequest(router, "POST", basePath+"/"+paymentID+"/status", "k4", `{"status": "cancelled"}`).Body.String())
+	assert.Equal(t, http.StatusConflict, idempotentRequest(router, "POST", basePath+"/"+paymentID+"/status", "", `{"status": "cancelled"}`).Code)
+
+	rr = idempotentRequest(router, "POST", basePath, strings.Repeat("k", 256), string(createRequest(paymentID)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestIdempotency_RequestHeaders(t *testing.T) {
+	memory := repository.NewMemory()
+	req := httptest.NewRequest("POST", "/", nil)
+	stored := &repository.IdempotentResponse{
+		Key:         idempotencyKey(req, "k1"),
+		RequestHash: requestHash(req, nil),
+		Status:      http.StatusOK,
+		Header:      http.Header{"Etag": {`"1"`}, RequestIDHeader: {"first"}, "Date": {"Thu, 18 Jan 2018 10:00:00 GMT"}},
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	assert.Nil(t, memory.SaveIdempotent(scoped(), stored))
+
+	//a response stored with the headers of its request is replayed with the ones of the retry
+	h := WithRequestID(Idempotent(memory, 0, http.NotFoundHandler()))
+	req.Header.Set(IdempotencyHeader, "k1")
+	req.Header.Set(RequestIDHeader, "retry")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"1"`, rr.Header().Get("Etag"))
+	assert.Equal(t, "retry", rr.Header().Get(RequestIDHeader))
+	assert.Empty(t, rr.Header().Get("Date"))
+}
+
+func TestIdempotency_Callers(t *testing.T) {
+	var calls int32
+	h := Authenticate(auth.HeaderAuthenticator{}, Idempotent(repository.NewMemory(), time.Hour, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(auth.FromContext(r.Context()).Organisations[0]))
+	})))
+
+	assert.Equal(t, testOrganisation, idempotentRequest(h, "POST", "/", "k1", "{}").Body.String())
+	req := httptest.NewRequest("POST", "/", bytes.NewBufferString("{}"))
+	req.Header.Set(auth.OrganisationHeader, "other")
+	req.Header.Set(IdempotencyHeader, "k1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, "other", rr.Body.String(), "the responses of the other callers should not be replayed")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_Concurrent(t *testing.T) {
+	var calls int32
+	h := Authenticate(auth.HeaderAuthenticator{}, Idempotent(repository.NewMemory(), time.Hour, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte{byte('0' + n)})
+	})))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := idempotentRequest(h, "POST", "/", "k1", "{}")
+			assert.Equal(t, http.StatusCreated, rr.Code)
+			bodies[i] = rr.Body.String()
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "the concurrent retries should wait for the first response")
+	for _, body := range bodies {
+		assert.Equal(t, "1", body)
+	}
+}
+
+func TestIdempotency_NotStored(t *testing.T) {
+	var calls int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	//the 5xx responses are not stored
+	h := Authenticate(auth.HeaderAuthenticator{}, Idempotent(repository.NewMemory(), time.Hour, handler))
+	assert.Equal(t, http.StatusServiceUnavailable, idempotentRequest(h, "POST", "/", "k1", "{}").Code)
+	assert.Equal(t, http.StatusCreated, idempotentRequest(h, "POST", "/", "k1", "{}").Code)
+	assert.Equal(t, http.StatusCreated, idempotentRequest(h, "POST", "/", "k1", "{}").Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	//the responses expire after the ttl
+	h = Authenticate(auth.HeaderAuthenticator{}, Idempotent(repository.NewMemory(), time.Millisecond, handler))
+	idempotentRequest(h, "POST", "/", "k1", "{}")
+	time.Sleep(5 * time.Millisecond)
+	idempotentRequest(h, "POST", "/", "k1", "{}")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 100000
+)
+
+//cursorToken is the content of the opaque cursor param
+type cursorToken struct {
+	ID       string `json:"id"`
+	Key      string `json:"k,omitempty"`
+	Backward bool   `json:"b,omitempty"`
+	//Sort is the sort param of the page, a cursor can not be used with a different sort
+	Sort string `json:"s"`
+}
+
+//encodeCursor returns the opaque token of the cursor of a page sorted by sort
+func encodeCursor(c repository.Cursor, sort string) string {
+	token, _ := json.Marshal(cursorToken{ID: c.ID, Key: c.Key, Backward: c.Backward, Sort: sort})
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+//decodeCursor returns the cursor of a token returned by encodeCursor for the same sort. An empty token is the first page.
+func decodeCursor(s, sort string) (repository.Cursor, *FieldError) {
+	if s == "" {
+		return repository.Cursor{}, nil
+	}
+	var token cursorToken
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(raw, &token)
+	}
+	if err != nil || token.ID == "" {
+		return repository.Cursor{}, &FieldError{Field: "cursor", Rule: "cursor", Message: "the cursor is not one returned in the links of a page"}
+	}
+	if token.Sort != sort {
+		return repository.Cursor{}, &FieldError{Field: "cursor", Rule: "cursor", Message: fmt.Sprintf("the cursor is of a page sorted by %s", token.Sort)}
+	}
+	return repository.Cursor{ID: token.ID, Key: token.Key, Backward: token.Backward}, nil
+}
+
+//parseListQuery returns the query of the params of /payments, or a *ValidationError with every param that is not valid
+func parseListQuery(params url.Values) (repository.ListQuery, error) {
+	var fields []FieldError
+	q := repository.ListQuery{
+		Limit: defaultListLimit,
+		Filter: repository.Filter{
+			OrganisationID:     params.Get("organisation_id"),
+			Currency:           params.Get("currency"),
+			Scheme:             params.Get("scheme"),
+			PaymentType:        params.Get("payment_type"),
+			ProcessingDateFrom: params.Get("processing_date_from"),
+			ProcessingDateTo:   params.Get("processing_date_to"),
+			DebtorAccount:      params.Get("debtor_account"),
+			BeneficiaryAccount: params.Get("beneficiary_account"),
+			ReferencePrefix:    params.Get("reference_prefix"),
+		},
+	}
+
+	//an invalid limit or offset falls back to the default, as it always did
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 {
+		q.Limit = limit
+	}
+	if q.Limit > maxListLimit {
+		q.Limit = maxListLimit
+	}
+
+	for _, date := range []string{"processing_date_from", "processing_date_to"} {
+		if v := params.Get(date); v != "" {
+			if _, err := time.Parse("2006-01-02", v); err != nil {
+				fields = append(fields, FieldError{Field: date, Rule: "date", Message: "must be a date like 2017-01-18"})
+			}
+		}
+	}
+	for _, amount := range []struct {
+		param string
+		value *model.Decimal
+	}{{"amount_min", &q.Filter.AmountMin}, {"amount_max", &q.Filter.AmountMax}} {
+		if v := params.Get(amount.param); v != "" {
+			d, err := model.ParseDecimal(v)
+			if err != nil {
+				fields = append(fields, FieldError{Field: amount.param, Rule: "decimal", Message: err.Error()})
+			}
+			*amount.value = d
+		}
+	}
+
+	sort := params.Get("sort")
+	if sort == "" {
+		q.Sort = repository.DefaultSort
+	} else {
+		q.Sort = repository.Sort{Field: repository.SortField(strings.TrimPrefix(sort, "-")), Desc: strings.HasPrefix(sort, "-")}
+		if !q.Sort.Valid() {
+			fields = append(fields, FieldError{Field: "sort", Rule: "sort", Message: fmt.Sprintf(
+				"must be one of %s, with a - prefix for the descending order", strings.Join(sortFields, ", "))})
+		}
+	}
+
+	if offset := params.Get("offset"); offset != "" {
+		q.Offset, _ = strconv.Atoi(offset)
+		if q.Offset < 0 {
+			q.Offset = 0
+		}
+	} else {
+		cursor, fieldErr := decodeCursor(params.Get("cursor"), sortParam(q.Sort))
+		if fieldErr != nil {
+			fields = append(fields, *fieldErr)
+		}
+		q.Cursor = &cursor
+	}
+
+	includeDeleted, fieldErr := parseIncludeDeleted(params)
+	if fieldErr != nil {
+		fields = append(fields, *fieldErr)
+	}
+	q.Filter.IncludeDeleted = includeDeleted
+
+	if len(fields) > 0 {
+		return q, &ValidationError{Fields: fields}
+	}
+	return q, nil
+}
+
+//parseIncludeDeleted parses the include_deleted param, false if it is not sent
+func parseIncludeDeleted(params url.Values) (bool, *FieldError) {
+	v := params.Get("include_deleted")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, &FieldError{Field: "include_deleted", Rule: "boolean", Message: "must be true or false"}
+	}
+	return include, nil
+}
+
+//sortFields are the values of the sort param
+var sortFields = []string{
+	string(repository.SortID), string(repository.SortProcessingDate), string(repository.SortAmount),
+	string(repository.SortCurrency), string(repository.SortReference),
+}
+
+//sortParam returns the sort param of the sort
+func sortParam(s repository.Sort) string {
+	if s.Desc {
+		return "-" + string(s.Field)
+	}
+	return string(s.Field)
+}
+
+//page returns the payments of the page of the query and its links.
+//In the cursor pagination list has up to one more payment than the limit, see fetchQuery.
+func page(r *http.Request, q repository.ListQuery, list []model.Payment) ([]model.Payment, *Links) {
+	links := &Links{}
+	if q.Cursor == nil {
+		if len(list) == q.Limit {
+			links.Next = pageURL(r, "offset", strconv.Itoa(q.Offset+q.Limit))
+		}
+		if q.Offset > 0 {
+			prev := q.Offset - q.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links.Prev = pageURL(r, "offset", strconv.Itoa(prev))
+		}
+		return list, links
+	}
+
+	more := len(list) > q.Limit
+	if more && q.Cursor.Backward {
+		list = list[1:]
+	} else if more {
+		list = list[:q.Limit]
+	}
+	hasNext, hasPrev := more, q.Cursor.ID != ""
+	if q.Cursor.Backward {
+		hasNext, hasPrev = true, more
+	}
+
+	sort := sortParam(q.Sort)
+	if hasNext {
+		last := &list[len(list)-1]
+		links.Next = pageURL(r, "cursor", encodeCursor(repository.Cursor{ID: last.ID, Key: q.Sort.Key(last)}, sort))
+	}
+	if hasPrev {
+		first := &list[0]
+		links.Prev = pageURL(r, "cursor", encodeCursor(repository.Cursor{ID: first.ID, Key: q.Sort.Key(first), Backward: true}, sort))
+	}
+	return list, links
+}
+
+//fetchQuery returns the query sent to the repository.
+//In the cursor pagination it asks for one more payment to know if there is a page after this one in the direction of the cursor.
+func fetchQuery(q repository.ListQuery) repository.ListQuery {
+	if q.Cursor != nil {
+		q.Limit++
+	}
+	return q
+}
+
+//pageURL returns the url of the request with the param set to value, in the format of Links.Self
+func pageURL(r *http.Request, param, value string) string {
+	query := url.Values{}
+	for k, v := range r.URL.Query() {
+		query[k] = v
+	}
+	query.Set(param, value)
+	return fmt.Sprintf("%s%s?%s", r.Host, r.URL.Path, query.Encode())
+}
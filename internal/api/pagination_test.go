@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// listPage is the body of a list response
+type listPage struct {
+	Data  []model.Payment `json:"data"`
+	Links Links           `json:"links"`
+}
+
+func getPage(t *testing.T, h http.Handler, url string) (int, listPage) {
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	var p listPage
+	if rr.Code == http.StatusOK {
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	}
+	return rr.Code, p
+}
+
+func pageIDs(p listPage) string {
+	var ids []string
+	for _, payment := range p.Data {
+		ids = append(ids, payment.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// linkPath removes the host of a link
+func linkPath(link string) string {
+	return link[strings.Index(link, "/"):]
+}
+
+func TestGetAllPayments_Cursor(t *testing.T) {
+	repo := repository.NewMemory()
+	for i := 1; i <= 5; i++ {
+		assert.Nil(t, repo.Create(scoped(), &model.Payment{ID: fmt.Sprint(i), OrganisationID: testOrganisation}))
+	}
+	router := NewRouter("/v1", repo, testOptions)
+
+	code, p := getPage(t, router, "/v1/payments?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "5,4", pageIDs(p))
+	assert.Empty(t, p.Links.Prev)
+
+	code, p = getPage(t, router, linkPath(p.Links.Next))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "3,2", pageIDs(p))
+
+	//a payment inserted in a page already read does not move the next pages
+	assert.Nil(t, repo.Create(scoped(), &model.Payment{ID: "35", OrganisationID: testOrganisation}))
+
+	code, last := getPage(t, router, linkPath(p.Links.Next))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", pageIDs(last))
+	assert.Empty(t, last.Links.Next)
+
+	code, p = getPage(t, router, linkPath(last.Links.Prev))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "3,2", pageIDs(p))
+
+	code, p = getPage(t, router, linkPath(p.Links.Prev))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "4,35", pageIDs(p))
+
+	code, p = getPage(t, router, linkPath(p.Links.Prev))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "5", pageIDs(p))
+	assert.Empty(t, p.Links.Prev)
+	assert.NotEmpty(t, p.Links.Next)
+}
+
+func TestGetAllPayments_Offset(t *testing.T) {
+	repo := repository.NewMemory()
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, repo.Create(scoped(), &model.Payment{ID: fmt.Sprint(i), OrganisationID: testOrganisation}))
+	}
+	router := NewRouter("/v1", repo, testOptions)
+
+	code, p := getPage(t, router, "/v1/payments?limit=2&offset=0")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "3,2", pageIDs(p))
+	assert.True(t, strings.HasSuffix(p.Links.Next, "/v1/payments?limit=2&offset=2"))
+	assert.Empty(t, p.Links.Prev)
+
+	code, p = getPage(t, router, linkPath(p.Links.Next))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "1", pageIDs(p))
+	assert.Empty(t, p.Links.Next)
+	assert.True(t, strings.HasSuffix(p.Links.Prev, "/v1/payments?limit=2&offset=0"))
+
+	code, _ = getPage(t, router, "/v1/payments?limit=2&offset=3")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGetAllPayments_Limit(t *testing.T) {
+	repo := repository.NewMemory()
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, repo.Create(scoped(), &model.Payment{ID: fmt.Sprint(i), OrganisationID: testOrganisation}))
+	}
+	router := NewRouter("/v1", repo, testOptions)
+
+	for _, url := range []string{"/v1/payments", "/v1/payments?limit=-1", "/v1/payments?limit=a", "/v1/payments?limit=1000000"} {
+		code, p := getPage(t, router, url)
+		assert.Equal(t, http.StatusOK, code, url)
+		assert.Equal(t, "3,2,1", pageIDs(p), url)
+	}
+	_, p := getPage(t, router, "/v1/payments?limit=1")
+	assert.Equal(t, "3", pageIDs(p))
+}
+
+func TestGetAllPayments_InvalidCursor(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	for _, cursor := range []string{"not-base64!", encodeCursorRaw("{}"), encodeCursorRaw("[")} {
+		code, _ := getPage(t, router, "/v1/payments?cursor="+cursor)
+		assert.Equal(t, http.StatusBadRequest, code, cursor)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, c := range []repository.Cursor{{ID: "a", Key: "a"}, {ID: "b", Key: "2017-01-18", Backward: true}} {
+		decoded, fieldErr := decodeCursor(encodeCursor(c, "-id"), "-id")
+		assert.Nil(t, fieldErr)
+		assert.Equal(t, c, decoded)
+	}
+	c, fieldErr := decodeCursor("", "-id")
+	assert.Nil(t, fieldErr)
+	assert.Equal(t, repository.Cursor{}, c)
+
+	_, fieldErr = decodeCursor(encodeCursor(repository.Cursor{ID: "a"}, "amount"), "-id")
+	assert.NotNil(t, fieldErr, "a cursor can not be used with a different sort")
+}
+
+func encodeCursorRaw(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// listPayment returns a payment with the fields used by the filters and the sorts
+func listPayment(id, currency, amount, date, reference string) *model.Payment {
+	p := &model.Payment{ID: id, OrganisationID: testOrganisation}
+	p.Attributes.Currency = currency
+	p.Attributes.Amount = model.MustParseDecimal(amount)
+	p.Attributes.ProcessingDate = date
+	p.Attributes.Reference = reference
+	return p
+}
+
+func TestGetAllPayments_FilterAndSort(t *testing.T) {
+	repo := repository.NewMemory()
+	for _, p := range []*model.Payment{
+		listPayment("1", "GBP", "10.00", "2017-01-18", "rent jan"),
+		listPayment("2", "GBP", "9.50", "2017-02-18", "rent feb"),
+		listPayment("3", "USD", "100.00", "2017-01-20", "piano"),
+		listPayment("4", "GBP", "10.00", "2017-03-18", "rent_mar"),
+	} {
+		assert.Nil(t, repo.Create(scoped(), p))
+	}
+	router := NewRouter("/v1", repo, testOptions)
+
+	cases := map[string]string{
+		"/v1/payments?currency=GBP":                                                  "4,2,1",
+		"/v1/payments?currency=GBP&sort=amount":                                      "2,1,4",
+		"/v1/payments?sort=-amount":                                                  "3,4,1,2",
+		"/v1/payments?sort=processing_date":                                          "1,3,2,4",
+		"/v1/payments?processing_date_from=2017-01-19&processing_date_to=2017-02-18": "3,2",
+		"/v1/payments?amount_min=9.99&amount_max=10":                                 "4,1",
+		"/v1/payments?reference_prefix=rent_":                                        "4",
+		"/v1/payments?reference_prefix=rent&sort=reference":                          "2,1,4",
+		"/v1/payments?organisation_id=" + testOrganisation + "&currency=USD":         "3",
+	}
+	for url, ids := range cases {
+		code, p := getPage(t, router, url)
+		assert.Equal(t, http.StatusOK, code, url)
+		assert.Equal(t, ids, pageIDs(p), url)
+	}
+
+	code, _ := getPage(t, router, "/v1/payments?currency=EUR")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	//the cursor keeps the sort and the filters of the links
+	var ids []string
+	url := "/v1/payments?currency=GBP&sort=-amount&limit=1"
+	for url != "" {
+		code, p := getPage(t, router, url)
+		assert.Equal(t, http.StatusOK, code, url)
+		ids = append(ids, pageIDs(p))
+		url = ""
+		if p.Links.Next != "" {
+			url = linkPath(p.Links.Next)
+		}
+	}
+	assert.Equal(t, []string{"4", "1", "2"}, ids)
+}
+
+func TestGetAllPayments_InvalidParams(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	req := httptest.NewRequest("GET", "/v1/payments?sort=organisation_id&amount_min=1e3&processing_date_from=18/01/2017", nil)
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	for _, field := range []string{`"field":"sort","rule":"sort"`, `"field":"amount_min","rule":"decimal"`, `"field":"processing_date_from","rule":"date"`} {
+		assert.Contains(t, rr.Body.String(), field)
+	}
+}
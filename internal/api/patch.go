@@ -0,0 +1,436 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"math/big"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Media types of the patch documents accepted by PatchPayment
+const (
+	//MergePatchContentType is a JSON Merge Patch (RFC 7396)
+	MergePatchContentType = "application/merge-patch+json"
+	//JSONPatchContentType is a JSON Patch (RFC 6902)
+	JSONPatchContentType = "application/json-patch+json"
+)
+
+//acceptPatch is the Accept-Patch header (RFC 5789) of the payments
+var acceptPatch = MergePatchContentType + ", " + JSONPatchContentType
+
+//PatchError is returned when a patch can not be applied to the payment, e.g. a path that does not exist or a failed test
+type PatchError struct {
+	Op   string
+	Path string
+	Msg  string
+}
+
+func (e *PatchError) Error() string {
+	if e.Op == "" {
+		return "patch: " + e.Msg
+	}
+	return fmt.Sprintf("patch %s %s: %s", e.Op, e.Path, e.Msg)
+}
+
+//PatchPayment applies the JSON Merge Patch or the JSON Patch of the request body to the stored payment,
+//validates the result like UpdatePayment and stores it if the version was not changed since it was read.
+//Returns the updated payment, 415 for another content type, 422 if the patch can not be applied
+//and 409 if the payment is no longer in an editable status or its version changed.
+//Must be used inside WithPaymentCtx.
+func PatchPayment(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paymentID := mux.Vars(r)["paymentID"]
+		stored := paymentFromContext(r.Context())
+		if !stored.Status.Editable() {
+			SendErrorResponse(w, r, CodePaymentNotEditable, errors.Errorf("paymentID:%s in status %s can not be edited", paymentID, stored.Status))
+			return
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != MergePatchContentType && mediaType != JSONPatchContentType {
+			w.Header().Set("Accept-Patch", acceptPatch)
+			SendErrorResponse(w, r, CodeUnsupportedMediaType, errors.Errorf("the content type must be %s or %s", MergePatchContentType, JSONPatchContentType))
+			return
+		}
+		var patch interface{}
+		if err := decodeJSONBody(r.Body, &patch); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+
+		doc, err := jsonDocument(stored)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if mediaType == MergePatchContentType {
+			doc = mergePatch(doc, patch)
+		} else if doc, err = jsonPatch(doc, patch); err != nil {
+			SendErrorResponse(w, r, CodeInvalidPatch, err)
+			return
+		}
+		patched, err := json.Marshal(doc)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+
+		var t *model.Payment
+		if err := decodeJSONBody(bytes.NewReader(patched), &t); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		if t == nil {
+			SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{{Rule: "required", Message: "the patched payment is null"}}})
+			return
+		}
+		if err := validate(t); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		model.FormatAmounts(t)
+		//like UpdatePayment, the id and the fields managed by the service can not be patched
+		t.ID = paymentID
+		keepServiceFields(t, stored)
+		t.UpdateBy(subject(r))
+		if err := repo.Update(r.Context(), t); err != nil {
+			sendUpdateError(w, r, paymentID, err)
+			return
+		}
+		if etag, err := ETag(t); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+		SendResponse(w, r, http.StatusOK, t)
+	})
+}
+
+//jsonDocument returns the json document of v as the maps, slices and values of encoding/json, with json.Number numbers
+func jsonDocument(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var doc interface{}
+	return doc, decoder.Decode(&doc)
+}
+
+//mergePatch applies the JSON Merge Patch (RFC 7396) to the target: the members of a patch object replace the ones of the target,
+//recursively for the objects, and its null members remove them. A patch that is not an object replaces the target.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergePatch(t[name], value)
+	}
+	return t
+}
+
+//jsonPatch applies the operations of the JSON Patch (RFC 6902) to the document in order and returns the patched document.
+//Returns a *PatchError if an operation is not valid or can not be applied, the document must then be discarded.
+func jsonPatch(doc, patch interface{}) (interface{}, error) {
+	ops, ok := patch.([]interface{})
+	if !ok {
+		return nil, &PatchError{Msg: "a JSON Patch must be an array of operations"}
+	}
+	for i, o := range ops {
+		op, ok := o.(map[string]interface{})
+		if !ok {
+			return nil, &PatchError{Msg: fmt.Sprintf("operation %d is not an object", i)}
+		}
+		name, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, &PatchError{Op: name, Msg: fmt.Sprintf("operation %d has no path", i)}
+		}
+		tokens, err := parsePointer(path)
+		if err != nil {
+			return nil, &PatchError{Op: name, Path: path, Msg: err.Error()}
+		}
+		value, hasValue := op["value"]
+		var from []string
+		if name == "move" || name == "copy" {
+			f, ok := op["from"].(string)
+			if !ok {
+				return nil, &PatchError{Op: name, Path: path, Msg: "from is required"}
+			}
+			if from, err = parsePointer(f); err != nil {
+				return nil, &PatchError{Op: name, Path: path, Msg: "from: " + err.Error()}
+			}
+		}
+
+		switch name {
+		case "add", "replace", "test":
+			if !hasValue {
+				return nil, &PatchError{Op: name, Path: path, Msg: "value is required"}
+			}
+		}
+		switch name {
+		case "add":
+			doc, err = addValue(doc, tokens, value)
+		case "remove":
+			doc, err = removeValue(doc, tokens)
+		case "replace":
+			if _, err = getValue(doc, tokens); err == nil {
+				doc, err = replaceValue(doc, tokens, value)
+			}
+		case "move":
+			if isPrefix(from, tokens) && len(from) < len(tokens) {
+				return nil, &PatchError{Op: name, Path: path, Msg: "a value can not be moved into one of its children"}
+			}
+			if value, err = getValue(doc, from); err == nil {
+				if doc, err = removeValue(doc, from); err == nil {
+					doc, err = addValue(doc, tokens, value)
+				}
+			}
+		case "copy":
+			if value, err = getValue(doc, from); err == nil {
+				doc, err = addValue(doc, tokens, deepCopy(value))
+			}
+		case "test":
+			var current interface{}
+			if current, err = getValue(doc, tokens); err == nil && !jsonEqual(current, value) {
+				err = errors.New("the value is not the one tested")
+			}
+		default:
+			return nil, &PatchError{Op: name, Path: path, Msg: "the op must be add, remove, replace, move, copy or test"}
+		}
+		if err != nil {
+			return nil, &PatchError{Op: name, Path: path, Msg: err.Error()}
+		}
+	}
+	return doc, nil
+}
+
+//parsePointer returns the reference tokens of a JSON Pointer (RFC 6901), none for the whole document
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, errors.Errorf("the pointer %q does not start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(t, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != tokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//getValue returns the value of the document at the tokens
+func getValue(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[t]
+			if !ok {
+				return nil, errors.Errorf("the member %q does not exist", t)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(t, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, errors.Errorf("%q is not in an object or an array", t)
+		}
+	}
+	return doc, nil
+}
+
+//addValue adds the value at the tokens, replacing the member of an object or inserting it in an array
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, tokens, func(parent interface{}, t string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[t] = value
+			return node, nil
+		case []interface{}:
+			if t == "-" {
+				return append(node, value), nil
+			}
+			i, err := arrayIndex(t, len(node))
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, errors.Errorf("%q is not in an object or an array", t)
+	})
+}
+
+//removeValue removes the value at the tokens, which must exist
+func removeValue(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("the whole document can not be removed")
+	}
+	return updateParent(doc, tokens, func(parent interface{}, t string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[t]; !ok {
+				return nil, errors.Errorf("the member %q does not exist", t)
+			}
+			delete(node, t)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(t, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, errors.Errorf("%q is not in an object or an array", t)
+	})
+}
+
+//replaceValue replaces the value at the tokens, which must exist
+func replaceValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, tokens, func(parent interface{}, t string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[t] = value
+			return node, nil
+		case []interface{}:
+			node[mustIndex(t)] = value
+			return node, nil
+		}
+		return nil, errors.Errorf("%q is not in an object or an array", t)
+	})
+}
+
+//updateParent replaces the parent of the last token by the one returned by fn, so the arrays can grow and shrink
+func updateParent(doc interface{}, tokens []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+	child, err := getValue(doc, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = updateParent(child, tokens[1:], fn); err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[tokens[0]] = child
+	case []interface{}:
+		node[mustIndex(tokens[0])] = child
+	}
+	return doc, nil
+}
+
+//arrayIndex parses the index of an array token, it must not be greater than max
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, errors.Errorf("%q is not an array index", token)
+	}
+	if i > max {
+		return 0, errors.Errorf("the index %d is out of the array", i)
+	}
+	return i, nil
+}
+
+//mustIndex returns the index of a token already checked by getValue
+func mustIndex(token string) int {
+	i, _ := strconv.Atoi(token)
+	return i
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for name, member := range v {
+			c[name] = deepCopy(member)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = deepCopy(item)
+		}
+		return c
+	}
+	return value
+}
+
+//jsonEqual compares two json values as the test operation does, the numbers are equal if their values are
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, v := range x {
+			w, ok := y[name]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		rx, okx := new(big.Rat).SetString(string(x))
+		ry, oky := new(big.Rat).SetString(string(y))
+		return okx && oky && rx.Cmp(ry) == 0
+	}
+	return a == b
+}
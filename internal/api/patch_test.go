@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//decodeDocument decodes a json document like jsonDocument
+func decodeDocument(t *testing.T, s string) interface{} {
+	decoder := json.NewDecoder(bytes.NewBufferString(s))
+	decoder.UseNumber()
+	var doc interface{}
+	assert.Nil(t, decoder.Decode(&doc), s)
+	return doc
+}
+
+//examples of RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct{ target, patch, result string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		result := mergePatch(decodeDocument(t, tt.target), decodeDocument(t, tt.patch))
+		assert.Equal(t, decodeDocument(t, tt.result), result, tt.target+" "+tt.patch)
+	}
+}
+
+//examples of RFC 6902 appendix A
+func TestJSONPatch(t *testing.T) {
+	tests := []struct{ doc, patch, result string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+		{`{"a":1.0}`, `[{"op":"test","path":"/a","value":1}]`, `{"a":1.0}`},
+		{`{"a":1}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+	}
+	for _, tt := range tests {
+		result, err := jsonPatch(decodeDocument(t, tt.doc), decodeDocument(t, tt.patch))
+		if assert.Nil(t, err, tt.patch) {
+			assert.Equal(t, decodeDocument(t, tt.result), result, tt.patch)
+		}
+	}
+}
+
+func TestJSONPatch_Errors(t *testing.T) {
+	tests := []struct{ doc, patch string }{
+		{`{"foo":"bar"}`, `{"op":"add","path":"/baz","value":"qux"}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"baz","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"update","path":"/foo","value":1}]`},
+		{`{"foo":"bar"}`, `[{"op":"copy","path":"/baz"}]`},
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`},
+		{`{"foo":[1,2]}`, `[{"op":"add","path":"/foo/3","value":3}]`},
+		{`{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/01"}]`},
+		{`{"foo":[1,2]}`, `[{"op":"remove","path":"/foo/-"}]`},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":""}]`},
+	}
+	for _, tt := range tests {
+		_, err := jsonPatch(decodeDocument(t, tt.doc), decodeDocument(t, tt.patch))
+		assert.IsType(t, &PatchError{}, err, tt.patch)
+	}
+}
+
+//patchRequest sends the patch with the content type as a maker of testOrganisation
+func patchRequest(router http.Handler, url, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", url, bytes.NewBufferString(body))
+	req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPatchPayment(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	rr := patchRequest(router, url, MergePatchContentType, `{"attributes": {"reference": "Piano lessons", "numeric_reference": "1002002"}, "status": "submitted"}`)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var body struct {
+		Data model.Payment `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, "Piano lessons", body.Data.Attributes.Reference)
+	assert.Equal(t, "1002002", body.Data.Attributes.NumericReference)
+	assert.Equal(t, "Wil piano Jan", body.Data.Attributes.EndToEndReference)
+	assert.Equal(t, 1, body.Data.Version)
+	//the fields managed by the service are not patched
+	assert.Equal(t, model.StatusDraft, body.Data.Status)
+	assert.Equal(t, "alice", body.Data.CreatedBy)
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+
+	rr = patchRequest(router, url, JSONPatchContentType+"; charset=utf-8",
+		`[{"op": "test", "path": "/version", "value": 1}, {"op": "replace", "path": "/attributes/amount", "value": "99.5"}]`)
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Body.String(), `"amount":"99.50"`)
+
+	rr = asCaller(router, "bob", "viewer", "GET", url, "")
+	assert.Contains(t, rr.Body.String(), `"amount":"99.50"`)
+	assert.Contains(t, rr.Body.String(), `"reference":"Piano lessons"`)
+	assert.Equal(t, acceptPatch, rr.Header().Get("Accept-Patch"))
+}
+
+func TestPatchPayment_Errors(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	tests := []struct {
+		contentType, body string
+		status            int
+		code              ErrorCode
+	}{
+		{"application/json", `{"attributes": {"reference": "x"}}`, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+		{MergePatchContentType, `{"attributes": `, http.StatusBadRequest, CodeValidationFailed},
+		{MergePatchContentType, `{"attributes": {"currency": "XXY"}}`, http.StatusBadRequest, CodeValidationFailed},
+		{MergePatchContentType, `{"attributes": {"amount": "12.345"}}`, http.StatusBadRequest, CodeValidationFailed},
+		{MergePatchContentType, `null`, http.StatusBadRequest, CodeValidationFailed},
+		{MergePatchContentType, `{"version": 7}`, http.StatusConflict, CodeVersionConflict},
+		{JSONPatchContentType, `[{"op": "test", "path": "/version", "value": 3}]`, http.StatusUnprocessableEntity, CodeInvalidPatch},
+		{JSONPatchContentType, `[{"op": "remove", "path": "/attributes/unknown"}]`, http.StatusUnprocessableEntity, CodeInvalidPatch},
+		{JSONPatchContentType, `[{"op": "remove", "path": "/type"}]`, http.StatusBadRequest, CodeValidationFailed},
+	}
+	for _, tt := range tests {
+		rr := patchRequest(router, url, tt.contentType, tt.body)
+		assert.Equal(t, tt.status, rr.Code, tt.body)
+		assert.Contains(t, rr.Body.String(), `"code":"`+string(tt.code)+`"`, tt.body)
+	}
+	rr := patchRequest(router, url, "text/plain", "")
+	assert.Equal(t, acceptPatch, rr.Header().Get("Accept-Patch"))
+
+	//the payment was not changed
+	rr = asCaller(router, "bob", "viewer", "GET", url, "")
+	assert.Contains(t, rr.Body.String(), `"version":0`)
+
+	rr = asCaller(router, "alice", "maker", "POST", url+"/status", `{"status": "cancelled"}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = patchRequest(router, url, MergePatchContentType, `{"attributes": {"reference": "x"}}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"payment_not_editable"`)
+}
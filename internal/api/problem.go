@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//ErrorCode is a stable application code of an error. Clients should use it instead of the message.
+type ErrorCode string
+
+//Error codes of the catalog, documented in docs/errors.md
+const (
+	CodeValidationFailed     ErrorCode = "validation_failed"
+	CodeInvalidStatus        ErrorCode = "invalid_status"
+	CodePaymentNotFound      ErrorCode = "payment_not_found"
+	CodeVersionNotFound      ErrorCode = "version_not_found"
+	CodeDuplicatePayment     ErrorCode = "duplicate_payment"
+	CodePaymentIDUnavailable ErrorCode = "payment_id_unavailable"
+	CodePaymentNotEditable   ErrorCode = "payment_not_editable"
+	CodePaymentNotDeleted    ErrorCode = "payment_not_deleted"
+	CodeInvalidTransition    ErrorCode = "invalid_transition"
+	CodeVersionConflict      ErrorCode = "version_conflict"
+	CodePreconditionFailed   ErrorCode = "precondition_failed"
+	CodeIfMatchRequired      ErrorCode = "if_match_required"
+	CodeInternalError        ErrorCode = "internal_error"
+	CodeDeadlineExceeded     ErrorCode = "deadline_exceeded"
+	CodeUnauthenticated      ErrorCode = "unauthenticated"
+	CodeOrganisationDenied   ErrorCode = "organisation_denied"
+	CodeInsufficientScope    ErrorCode = "insufficient_scope"
+	CodeAPIKeyNotFound       ErrorCode = "apikey_not_found"
+	CodeAPIKeyRevoked        ErrorCode = "apikey_revoked"
+	CodeSelfApproval         ErrorCode = "self_approval"
+	CodeApprovalRequired     ErrorCode = "approval_required"
+	CodeIdempotencyKeyReused ErrorCode = "idempotency_key_reused"
+	CodeUnsupportedMediaType ErrorCode = "unsupported_media_type"
+	CodeInvalidPatch         ErrorCode = "invalid_patch"
+	CodeWebhookNotFound      ErrorCode = "webhook_not_found"
+	CodeDeliveryNotFound     ErrorCode = "delivery_not_found"
+	CodeDeliveryNotDead      ErrorCode = "delivery_not_dead"
+)
+
+//ErrorTypeBase is the prefix of the type URI of the problems, the code is the fragment.
+const ErrorTypeBase = "https://github.com/plusspeed/payments-api/blob/master/docs/errors.md#"
+
+//ProblemContentType is the media type of the RFC 7807 problem details
+const ProblemContentType = "application/problem+json"
+
+//errorCatalog contains the http status and the title of each code
+var errorCatalog = map[ErrorCode]struct {
+	Status int
+	Title  string
+}{
+	CodeValidationFailed:     {http.StatusBadRequest, "The request is not valid"},
+	CodeInvalidStatus:        {http.StatusBadRequest, "The status is not known"},
+	CodePaymentNotFound:      {http.StatusNotFound, "The payment does not exist"},
+	CodeVersionNotFound:      {http.StatusNotFound, "The version is not in the history of the payment"},
+	CodeDuplicatePayment:     {http.StatusConflict, "A different payment with the same id already exists"},
+	CodePaymentIDUnavailable: {http.StatusConflict, "The id can not be used for a payment"},
+	CodePaymentNotEditable:   {http.StatusConflict, "The payment can not be edited in its status"},
+	CodePaymentNotDeleted:    {http.StatusConflict, "The payment is not deleted"},
+	CodeInvalidTransition:    {http.StatusConflict, "The payment can not move to the status"},
+	CodeVersionConflict:      {http.StatusConflict, "The version is not the current one"},
+	CodePreconditionFailed:   {http.StatusPreconditionFailed, "If-Match does not match the current ETag"},
+	CodeIfMatchRequired:      {http.StatusPreconditionRequired, "If-Match header is required"},
+	CodeInternalError:        {http.StatusInternalServerError, "Internal error"},
+	CodeDeadlineExceeded:     {http.StatusGatewayTimeout, "The request did not finish before its deadline"},
+	CodeUnauthenticated:      {http.StatusUnauthorized, "The request is not authenticated"},
+	CodeOrganisationDenied:   {http.StatusForbidden, "The organisation of the payment is not one of the caller"},
+	CodeInsufficientScope:    {http.StatusForbidden, "The caller does not have a role allowed on the route"},
+	CodeAPIKeyNotFound:       {http.StatusNotFound, "The api key does not exist"},
+	CodeAPIKeyRevoked:        {http.StatusConflict, "The api key was revoked"},
+	CodeSelfApproval:         {http.StatusForbidden, "The payment can not be approved or rejected by its maker"},
+	CodeApprovalRequired:     {http.StatusConflict, "The payment must be approved before it is submitted"},
+	CodeIdempotencyKeyReused: {http.StatusUnprocessableEntity, "The idempotency key was used for a different request"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "The content type of the body is not supported"},
+	CodeInvalidPatch:         {http.StatusUnprocessableEntity, "The patch can not be applied to the payment"},
+	CodeWebhookNotFound:      {http.StatusNotFound, "The webhook subscription does not exist"},
+	CodeDeliveryNotFound:     {http.StatusNotFound, "The webhook delivery does not exist"},
+	CodeDeliveryNotDead:      {http.StatusConflict, "The webhook delivery is not in the dead letters"},
+}
+
+//Status returns the http status of the code
+func (c ErrorCode) Status() int {
+	if e, ok := errorCatalog[c]; ok {
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
+
+//Title returns the short summary of the code, it does not change between occurrences
+func (c ErrorCode) Title() string {
+	return errorCatalog[c].Title
+}
+
+//Type returns the URI that identifies the code
+func (c ErrorCode) Type() string {
+	return ErrorTypeBase + string(c)
+}
+
+//Problem is a RFC 7807 problem details object
+type Problem struct {
+	Type     string    `json:"type"`
+	Title    string    `json:"title"`
+	Status   int       `json:"status"`
+	Detail   string    `json:"detail,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Code     ErrorCode `json:"code"`
+	//Fields lists every field that failed the validation of the request
+	Fields []FieldError `json:"fields,omitempty"`
+	//extensions are the members of the payload of the error, e.g. current_version
+	extensions interface{}
+}
+
+//MarshalJSON adds the members of the extensions to the problem
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	body, err := json.Marshal(problem(p))
+	if err != nil || p.extensions == nil {
+		return body, err
+	}
+	members := map[string]interface{}{}
+	extensions, err := json.Marshal(p.extensions)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(extensions, &members); err != nil {
+		return nil, err
+	}
+	//the standard members can not be replaced by an extension
+	if err = json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	return json.Marshal(members)
+}
+
+//wantsProblem returns false if the client prefers application/json to application/problem+json in its Accept header
+func wantsProblem(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	problemQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case ProblemContentType:
+			problemQ = q
+		case "application/json":
+			jsonQ = q
+		}
+	}
+	return jsonQ <= 0 || problemQ >= jsonQ
+}
+
+//errorCode returns CodeDeadlineExceeded for an internal error of a request whose deadline expired
+func errorCode(r *http.Request, code ErrorCode) ErrorCode {
+	if code == CodeInternalError && r.Context().Err() == context.DeadlineExceeded {
+		return CodeDeadlineExceeded
+	}
+	return code
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWantsProblem(t *testing.T) {
+	cases := map[string]bool{
+		"":                            true,
+		"*/*":                         true,
+		"application/problem+json":    true,
+		"application/json":            false,
+		"application/json, */*;q=0.1": false,
+		"application/problem+json, application/json":       true,
+		"application/problem+json;q=0.5, application/json": false,
+		"application/json;q=0":                             true,
+	}
+	for accept, want := range cases {
+		r := httptest.NewRequest("GET", "/v1/payment/1", nil)
+		r.Header.Set("Accept", accept)
+		assert.Equal(t, want, wantsProblem(r), accept)
+	}
+}
+
+func TestSendErrorResponse_Problem(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/v1/payment/1?a=b", nil)
+	w := httptest.NewRecorder()
+	SendErrorResponseWithData(w, r, CodeVersionConflict, errors.New("stale"), VersionConflict{CurrentVersion: 3})
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+	var body map[string]interface{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, ErrorTypeBase+"version_conflict", body["type"])
+	assert.Equal(t, "The version is not the current one", body["title"])
+	assert.Equal(t, float64(http.StatusConflict), body["status"])
+	assert.Equal(t, "stale", body["detail"])
+	assert.Equal(t, "/v1/payment/1?a=b", body["instance"])
+	assert.Equal(t, "version_conflict", body["code"])
+	assert.Equal(t, float64(3), body["current_version"])
+}
+
+func TestSendErrorResponse_Envelope(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/payment", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	err := &ValidationError{Fields: []FieldError{{Field: "id", Rule: "required", Message: "failed on the required rule"}}}
+	SendErrorResponse(w, r, CodeValidationFailed, err)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var body Response
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Nil(t, body.Data)
+	assert.Equal(t, http.StatusBadRequest, body.Error.InternalCode)
+	assert.Equal(t, CodeValidationFailed, body.Error.ErrorCode)
+	assert.Equal(t, err.Fields, body.Error.Fields)
+}
+
+func TestErrorCatalog(t *testing.T) {
+	for code, e := range errorCatalog {
+		assert.NotEmpty(t, e.Title, code)
+		assert.Equal(t, e.Status, code.Status())
+	}
+	assert.Equal(t, http.StatusInternalServerError, ErrorCode("unknown").Status())
+}
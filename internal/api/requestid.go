@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"github.com/pborman/uuid"
+	"net/http"
+)
+
+//RequestIDHeader identifies a request in the logs and in the audit of the payments it changes
+const RequestIDHeader = "X-Request-ID"
+
+//maxRequestID is the max length of a request id sent by a client
+const maxRequestID = 128
+
+type requestIDKey struct{}
+
+//WithRequestID adds the X-Request-ID header of the request to its context and to the response.
+//A request without the header, or with one that is too long or not printable ascii, gets a new uuid.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewRandom().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+//requestID returns the id added by WithRequestID, empty if there is none
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
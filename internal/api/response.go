@@ -16,38 +16,95 @@ type Response struct {
 
 //Error contains the error information
 type Error struct {
-	InternalCode int    `json:"code"`
-	Message      string `json:"msg"`
+	//InternalCode is the http status of the response
+	InternalCode int `json:"code"`
+	//ErrorCode is the stable application code of the error
+	ErrorCode ErrorCode `json:"error_code"`
+	Message   string    `json:"msg"`
+	//Fields lists every field that failed the validation of the request
+	Fields []FieldError `json:"fields,omitempty"`
 }
 
-//Links contains
+//Links contains the url of the response and, for a page of a list, of the next and previous pages
 type Links struct {
 	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+//VersionConflict is the payload of a 409 response when the version sent is not the current one
+type VersionConflict struct {
+	CurrentVersion int `json:"current_version"`
 }
 
 //SendResponse converts a code and a interface into a Response and sends the response.
 func SendResponse(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
+	SendResponseWithLinks(w, r, code, payload, &Links{})
+}
+
+//SendResponseWithLinks sends a Response with the links, Self is set to the url of the request.
+func SendResponseWithLinks(w http.ResponseWriter, r *http.Request, code int, payload interface{}, links *Links) {
+	links.Self = fmt.Sprintf("%s%s", r.Host, r.URL.String())
 	var rspPayload = &Response{
 		Data:  &payload,
 		Error: nil,
-		Links: &Links{Self: fmt.Sprintf("%s%s", r.Host, r.URL.String())},
+		Links: links,
 	}
 	sendJSONResponse(w, r, code, rspPayload)
 }
 
-//SendErrorResponse converts a code and an error into a Response with Error not nil and sends the response.
-func SendErrorResponse(w http.ResponseWriter, r *http.Request, code int, err error) {
-	var rspPayload = &Response{
-		Data:  nil,
-		Error: &Error{InternalCode: code, Message: err.Error()},
-		Links: &Links{Self: fmt.Sprintf("%s%s", r.Host, r.URL.String())},
+//SendErrorResponse sends the error as a application/problem+json with the status of the code.
+//Clients that prefer application/json in the Accept header get a Response with Error not nil.
+//The fields of a *ValidationError are sent in Fields.
+func SendErrorResponse(w http.ResponseWriter, r *http.Request, code ErrorCode, err error) {
+	sendError(w, r, code, err, nil)
+}
+
+//SendErrorResponseWithData sends an error that also carries a payload describing the error.
+//The members of the payload are added to the problem, or sent in Data of the Response.
+func SendErrorResponseWithData(w http.ResponseWriter, r *http.Request, code ErrorCode, err error, payload interface{}) {
+	sendError(w, r, code, err, payload)
+}
+
+func sendError(w http.ResponseWriter, r *http.Request, code ErrorCode, err error, payload interface{}) {
+	var fields []FieldError
+	if validationErr, ok := err.(*ValidationError); ok {
+		fields = validationErr.Fields
 	}
-	logrus.WithError(err).Info("failed response")
-	sendJSONResponse(w, r, code, rspPayload)
+	code = errorCode(r, code)
+	logrus.WithError(err).WithField("code", code).WithField("request_id", requestID(r)).Info("failed response")
+
+	if !wantsProblem(r) {
+		var rspPayload = &Response{
+			Error: &Error{InternalCode: code.Status(), ErrorCode: code, Message: err.Error(), Fields: fields},
+			Links: &Links{Self: fmt.Sprintf("%s%s", r.Host, r.URL.String())},
+		}
+		if payload != nil {
+			rspPayload.Data = &payload
+		}
+		sendJSONResponse(w, r, code.Status(), rspPayload)
+		return
+	}
+
+	problem := Problem{
+		Type:       code.Type(),
+		Title:      code.Title(),
+		Status:     code.Status(),
+		Detail:     err.Error(),
+		Instance:   r.URL.RequestURI(),
+		Code:       code,
+		Fields:     fields,
+		extensions: payload,
+	}
+	sendJSONResponse(w, r, code.Status(), problem)
 }
 
 func sendJSONResponse(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	contentType := "application/json"
+	if _, ok := payload.(Problem); ok {
+		contentType = ProblemContentType
+	}
+	w.Header().Set("Content-Type", contentType)
 	response, err := json.Marshal(payload)
 
 	if err != nil {
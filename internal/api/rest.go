@@ -1,65 +1,166 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/auth"
 	"github.com/plusspeed/payments-api/internal/model"
 	"github.com/plusspeed/payments-api/internal/repository"
-	"gopkg.in/go-playground/validator.v8"
 	"net/http"
-	"strconv"
 	"strings"
+	"time"
+)
+
+//Options configures the behaviour of the router.
+type Options struct {
+	//RequireIfMatch rejects PUT, PATCH and DELETE requests without a If-Match header.
+	RequireIfMatch bool
+	//DefaultTimeout is the deadline of the requests. 0 means no deadline.
+	DefaultTimeout time.Duration
+	//RouteTimeouts overrides DefaultTimeout for the routes by name, e.g. RouteListPayments.
+	RouteTimeouts map[string]time.Duration
+	//Authenticator authenticates the requests on the payments and the api keys. If nil every request is rejected.
+	Authenticator auth.Authenticator
+	//Keys stores the api keys managed by the admin routes. If nil the routes are not added.
+	Keys repository.KeyStore
+	//Audit returns the history of the payments. If nil the history and diff routes are not added,
+	//and the payments can not be read as of a time or a version.
+	Audit repository.AuditLog
+	//Idempotency stores the responses of the POST requests on the payments with an Idempotency-Key header.
+	//If nil the header is ignored.
+	Idempotency repository.IdempotencyStore
+	//IdempotencyTTL is how long the responses are replayed. 0 means DefaultIdempotencyTTL.
+	IdempotencyTTL time.Duration
+	//Changes returns the change feed of the payments. If nil the changes route is not added.
+	Changes repository.ChangeFeed
+	//WriteTimeout is the one of the server, the long-polling requests end before it when their write deadline can not be extended.
+	//0 means no write timeout.
+	WriteTimeout time.Duration
+	//Webhooks stores the webhook subscriptions and their dead letters managed by the admin routes. If nil the routes are not added.
+	Webhooks repository.WebhookStore
+}
+
+//roles allowed on the routes
+var (
+	readers   = []string{auth.RoleViewer, auth.RoleMaker, auth.RoleApprover}
+	makers    = []string{auth.RoleMaker}
+	approvers = []string{auth.RoleApprover}
+	admins    = []string{auth.RoleAdmin}
+	//reviewers settle or reject the submitted payments, the other transitions are made by the makers
+	reviewers     = []string{auth.RoleApprover, auth.RoleAdmin}
+	transitioners = []string{auth.RoleMaker, auth.RoleApprover, auth.RoleAdmin}
 )
 
 //NewRouter starts the service. In the case of a service failure, it will PANIC.
-func NewRouter(basePath string, db *repository.Repository) *mux.Router {
+func NewRouter(basePath string, repo repository.PaymentTransaction, opts Options) *mux.Router {
 	r := mux.NewRouter().StrictSlash(true)
-	r.HandleFunc("/health", HealthCheckHandler(*db))
+	//handle registers the route with the deadline configured for its name, every request has a request id
+	handle := func(name, path string, h http.Handler) *mux.Route {
+		return r.Handle(path, WithRequestID(WithTimeout(opts.timeout(name), h))).Name(name)
+	}
+	handle(RouteHealth, "/health", HealthCheckHandler(repo))
+	handle(RouteCurrencies, basePath+"/currencies", GetCurrencies()).Methods("GET")
+
+	//the payments and the keys are only accessed by an authenticated caller with one of the roles, in the scope of its organisations
+	authenticated := func(name, path string, roles []string, h http.HandlerFunc) *mux.Route {
+		return handle(name, path, Authenticate(opts.Authenticator, RequireRole(roles, h)))
+	}
+	//idempotent replays the response of a POST request to its retries with the same Idempotency-Key
+	idempotent := func(h http.Handler) http.HandlerFunc {
+		return Idempotent(opts.Idempotency, opts.IdempotencyTTL, h).ServeHTTP
+	}
+	authenticated(RouteCreatePayment, basePath+"/payment", makers, idempotent(CreatePayment(repo))).Methods("POST")
+	authenticated(RouteGetPayment, basePath+"/payment/{paymentID}", readers, WithSnapshot(opts.Audit, GetPayment(repo))).Methods("GET")
+	authenticated(RouteDeletePayment, basePath+"/payment/{paymentID}", makers, WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, DeletePayment))).Methods("DELETE")
+	authenticated(RouteUpdatePayment, basePath+"/payment/{paymentID}", makers, WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, UpdatePayment))).Methods("PUT")
+	authenticated(RoutePatchPayment, basePath+"/payment/{paymentID}", makers, WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, PatchPayment))).Methods("PATCH")
+	authenticated(RouteTransitionPayment, basePath+"/payment/{paymentID}/status", transitioners, idempotent(WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, TransitionPayment)))).Methods("POST")
+	authenticated(RouteApprovePayment, basePath+"/payment/{paymentID}/approve", approvers, idempotent(WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, ApprovePayment)))).Methods("POST")
+	authenticated(RouteRejectPayment, basePath+"/payment/{paymentID}/reject", approvers, idempotent(WithPaymentCtx(repo, CheckIfMatch(opts.RequireIfMatch, RejectPayment)))).Methods("POST")
+	authenticated(RouteRestorePayment, basePath+"/payment/{paymentID}/restore", makers, idempotent(RestorePayment(repo))).Methods("POST")
+	authenticated(RouteListPayments, basePath+"/payments", readers, GetAllPayments(repo)).Methods("GET")
+	if opts.Changes != nil {
+		authenticated(RouteChanges, basePath+"/payments/changes", readers, GetChanges(opts.Changes, opts.WriteTimeout)).Methods("GET")
+	}
+	if opts.Audit != nil {
+		authenticated(RouteHistory, basePath+"/payment/{paymentID}/history", readers, GetHistory(opts.Audit)).Methods("GET")
+		authenticated(RouteDiff, basePath+"/payment/{paymentID}/diff", readers, DiffPayment(opts.Audit)).Methods("GET")
+	}
 
-	r.HandleFunc(basePath+"/payment", CreatePayment(*db)).Methods("POST")
-	r.HandleFunc(basePath+"/payment/{paymentID}", GetPayment(*db)).Methods("GET")
-	r.HandleFunc(basePath+"/payment/{paymentID}", WithPaymentCtx(*db, DeletePayment)).Methods("DELETE")
-	r.HandleFunc(basePath+"/payment/{paymentID}", WithPaymentCtx(*db, UpdatePayment)).Methods("PUT")
-	r.HandleFunc(basePath+"/payments", GetAllPayments(*db)).
-		Queries("offset", "{offset}", "limit", "{limit}").
-		Methods("GET")
+	if opts.Keys != nil {
+		authenticated(RouteCreateAPIKey, basePath+"/apikeys", admins, CreateAPIKey(opts.Keys)).Methods("POST")
+		authenticated(RouteListAPIKeys, basePath+"/apikeys", admins, ListAPIKeys(opts.Keys)).Methods("GET")
+		authenticated(RouteRotateAPIKey, basePath+"/apikeys/{keyID}/rotate", admins, RotateAPIKey(opts.Keys)).Methods("POST")
+		authenticated(RouteRevokeAPIKey, basePath+"/apikeys/{keyID}", admins, RevokeAPIKey(opts.Keys)).Methods("DELETE")
+	}
 
-	http.Handle("/", r)
+	if opts.Webhooks != nil {
+		authenticated(RouteCreateWebhook, basePath+"/webhooks", admins, CreateWebhook(opts.Webhooks)).Methods("POST")
+		authenticated(RouteListWebhooks, basePath+"/webhooks", admins, ListWebhooks(opts.Webhooks)).Methods("GET")
+		//the dead letters are registered before the subscriptions so deadletters is not taken for a subscriptionID
+		authenticated(RouteListDeadLetters, basePath+"/webhooks/deadletters", admins, ListDeadLetters(opts.Webhooks)).Methods("GET")
+		authenticated(RouteReplayDeadLetter, basePath+"/webhooks/deadletters/{deliveryID}/replay", admins, ReplayDeadLetter(opts.Webhooks)).Methods("POST")
+		authenticated(RouteGetWebhook, basePath+"/webhooks/{subscriptionID}", admins, GetWebhook(opts.Webhooks)).Methods("GET")
+		authenticated(RouteDeleteWebhook, basePath+"/webhooks/{subscriptionID}", admins, DeleteWebhook(opts.Webhooks)).Methods("DELETE")
+	}
 
 	return r
 }
 
-//CreatePayment creates a new payment transaction resource
-func CreatePayment(repo repository.Repository) http.HandlerFunc {
+//CreatePayment creates a new payment transaction resource in the draft status, made by the caller
+func CreatePayment(repo repository.PaymentTransaction) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		var t *model.Payment
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			SendErrorResponse(w, r, http.StatusBadRequest, err)
+		if err := decodeJSON(r, &t); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
 			return
 		}
 
 		err := validate(t)
 		if err != nil {
-			SendErrorResponse(w, r, http.StatusBadRequest, err)
+			SendErrorResponse(w, r, CodeValidationFailed, err)
 			return
 		}
+		model.FormatAmounts(t)
 
-		dup, err := repo.Get(t.ID)
+		dup, err := repo.Get(r.Context(), t.ID)
 		if err == nil {
-			if cmp.Equal(*t, *dup) {
+			//the fields managed by the service are not part of the comparison.
+			//The id of a deleted payment can not be used again until it is purged.
+			keepServiceFields(t, dup)
+			if !dup.Deleted() && cmp.Equal(*t, *dup) {
 				w.WriteHeader(http.StatusCreated)
 				return
 			}
-			SendErrorResponse(w, r, http.StatusConflict, errors.New("already exists"))
+			SendErrorResponse(w, r, CodeDuplicatePayment, errors.Errorf("paymentID:%s already exists", t.ID))
 			return
 		}
 
-		err = repo.Create(t)
+		keepServiceFields(t, &model.Payment{CreatedBy: subject(r)})
+		if err = t.Transition(model.StatusDraft, time.Now().UTC()); err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		err = repo.Create(r.Context(), t)
+		if err == repository.ErrAlreadyExists {
+			//the id is taken by a payment the caller can not see, or one created meanwhile by another request.
+			//The payments of the other organisations are not revealed, neither their ids.
+			if _, err := repo.Get(r.Context(), t.ID); err == nil {
+				SendErrorResponse(w, r, CodeDuplicatePayment, errors.Errorf("paymentID:%s already exists", t.ID))
+				return
+			}
+			SendErrorResponse(w, r, CodePaymentIDUnavailable, errors.New("the id can not be used, the payment must be sent with another id"))
+			return
+		}
+		if err == repository.ErrOutOfScope {
+			SendErrorResponse(w, r, CodeOrganisationDenied, errors.Errorf("organisation_id:%s is not one of the caller", t.OrganisationID))
+			return
+		}
 		if err != nil {
-			SendErrorResponse(w, r, http.StatusInternalServerError, err)
+			SendErrorResponse(w, r, CodeInternalError, err)
 			return
 		}
 		w.WriteHeader(http.StatusCreated)
@@ -67,39 +168,73 @@ func CreatePayment(repo repository.Repository) http.HandlerFunc {
 }
 
 // WithPaymentCtx checks if there is a transaction with the paymentID.
-// If the payment exists, calls next handlerFunc.
+// If the payment exists and is not deleted, adds it to the request context and calls next handlerFunc.
 // else returns an error message
-func WithPaymentCtx(repo repository.Repository, next func(repository.Repository) http.HandlerFunc) http.HandlerFunc {
+func WithPaymentCtx(repo repository.PaymentTransaction, next func(repository.PaymentTransaction) http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		paymentID := mux.Vars(r)["paymentID"]
 		if paymentID == "" {
-			SendErrorResponse(w, r, http.StatusNotFound, errors.Errorf("bad request paymentID:%s", paymentID))
+			SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("bad request paymentID:%s", paymentID))
 			return
 		}
-		_, err := repo.Get(paymentID)
+		payment, err := repo.Get(r.Context(), paymentID)
+		if err == nil && payment.Deleted() {
+			err = repository.ErrNotFound
+		}
 		if err != nil {
 			if err == repository.ErrNotFound {
-				SendErrorResponse(w, r, http.StatusNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+				SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
 				return
 			}
-			SendErrorResponse(w, r, http.StatusInternalServerError, err)
+			SendErrorResponse(w, r, CodeInternalError, err)
 			return
 		}
-		next(repo).ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), paymentCtxKey, payment)
+		next(repo).ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-//GetPayment returns the payment if exist.
-func GetPayment(repo repository.Repository) http.HandlerFunc {
+type ctxKey int
+
+const paymentCtxKey ctxKey = iota
+
+//paymentFromContext returns the payment loaded by WithPaymentCtx
+func paymentFromContext(ctx context.Context) *model.Payment {
+	payment, _ := ctx.Value(paymentCtxKey).(*model.Payment)
+	return payment
+}
+
+//GetPayment returns the payment if exist. A deleted payment is only returned with the include_deleted=true query param.
+//Sets the ETag header and returns 304 if it matches If-None-Match.
+func GetPayment(repo repository.PaymentTransaction) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		paymentID := mux.Vars(r)["paymentID"]
-		val, err := repo.Get(paymentID)
+		includeDeleted, fieldErr := parseIncludeDeleted(r.URL.Query())
+		if fieldErr != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{*fieldErr}})
+			return
+		}
+		val, err := repo.Get(r.Context(), paymentID)
+		if err == nil && val.Deleted() && !includeDeleted {
+			err = repository.ErrNotFound
+		}
 		if err != nil {
 			if err == repository.ErrNotFound {
-				SendErrorResponse(w, r, http.StatusNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+				SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
 				return
 			}
-			SendErrorResponse(w, r, http.StatusInternalServerError, err)
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		etag, err := ETag(val)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Accept-Patch", acceptPatch)
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag, true) {
+			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 		SendResponse(w, r, http.StatusOK, val)
@@ -107,14 +242,29 @@ func GetPayment(repo repository.Repository) http.HandlerFunc {
 	}
 }
 
-//DeletePayment deletes a resource payment if exist.
-func DeletePayment(repo repository.Repository) http.HandlerFunc {
+//DeletePayment marks a resource payment as deleted by the caller if exist.
+//The deleted payment is hidden until it is restored or purged.
+//With a If-Match header the payment is only deleted in the version matched by CheckIfMatch, returns 412 if it changed since.
+func DeletePayment(repo repository.PaymentTransaction) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		paymentID := mux.Vars(r)["paymentID"]
 
-		err := repo.Delete(paymentID)
-		if err != nil && err == repository.ErrNotFound {
-			SendErrorResponse(w, r, http.StatusInternalServerError, err)
+		version := repository.AnyVersion
+		if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); ifMatch != "" && ifMatch != "*" {
+			version = paymentFromContext(r.Context()).Version
+		}
+		err := repo.Delete(r.Context(), paymentID, version)
+		if conflict, ok := err.(*repository.VersionConflictError); ok {
+			SendErrorResponseWithData(w, r, CodePreconditionFailed, errors.Errorf("If-Match does not match the current version %d of the payment", conflict.Current),
+				VersionConflict{CurrentVersion: conflict.Current})
+			return
+		}
+		if err == repository.ErrNotFound {
+			SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -122,79 +272,120 @@ func DeletePayment(repo repository.Repository) http.HandlerFunc {
 	}
 }
 
+//RestorePayment restores a deleted payment and returns it, with its new version.
+//Returns 409 if the payment is not deleted.
+func RestorePayment(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paymentID := mux.Vars(r)["paymentID"]
+
+		payment, err := repo.Restore(r.Context(), paymentID)
+		if err == repository.ErrNotFound {
+			SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+			return
+		}
+		if err == repository.ErrNotDeleted {
+			SendErrorResponse(w, r, CodePaymentNotDeleted, errors.Errorf("paymentID:%s is not deleted", paymentID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if etag, err := ETag(payment); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+		SendResponse(w, r, http.StatusOK, payment)
+	})
+}
+
 //UpdatePayment updates a previous transaction.
-func UpdatePayment(repo repository.Repository) http.HandlerFunc {
+//The version sent must match the stored one, otherwise returns 409 with the current version.
+//Returns 409 if the payment is no longer in an editable status.
+func UpdatePayment(repo repository.PaymentTransaction) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		paymentID := mux.Vars(r)["paymentID"]
+		stored := paymentFromContext(r.Context())
+		if !stored.Status.Editable() {
+			SendErrorResponse(w, r, CodePaymentNotEditable, errors.Errorf("paymentID:%s in status %s can not be edited", paymentID, stored.Status))
+			return
+		}
 
 		var t *model.Payment
-		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
-			SendErrorResponse(w, r, http.StatusBadRequest, err)
+		if err := decodeJSON(r, &t); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
 			return
 		}
 		err := validate(t)
 		if err != nil {
-			SendErrorResponse(w, r, http.StatusBadRequest, err)
+			SendErrorResponse(w, r, CodeValidationFailed, err)
 			return
 		}
+		model.FormatAmounts(t)
 		//to ensure that the users does not try to modify a different payment
 		t.ID = paymentID
-		err = repo.Update(t)
+		//the status can only be changed with a transition, and the approvals by the approvers
+		keepServiceFields(t, stored)
+		t.UpdateBy(subject(r))
+		err = repo.Update(r.Context(), t)
 		if err != nil {
-			SendErrorResponse(w, r, http.StatusInternalServerError, err)
+			sendUpdateError(w, r, paymentID, err)
 			return
 		}
+		if etag, err := ETag(t); err == nil {
+			w.Header().Set("ETag", etag)
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	})
 }
 
-//GetAllPayments Returns all the payments.
-//Query params are optional.
-//The default limit is 100 and max is 100000. Offset default value is 0.
-func GetAllPayments(repo repository.Repository) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var offset, limit int
-		var err error
-		if offset, err = strconv.Atoi(mux.Vars(r)["offset"]); err != nil {
-			offset = 0
-		}
-		if limit, err = strconv.Atoi(mux.Vars(r)["limit"]); err != nil {
-			limit = 100
-		}
+//keepServiceFields copies into t the fields of stored that are managed by the service:
+//the status and its history, the makers, the approvals and the deletion.
+func keepServiceFields(t, stored *model.Payment) {
+	t.Status, t.StatusHistory = stored.Status, stored.StatusHistory
+	t.CreatedBy, t.UpdatedBy, t.Approvals = stored.CreatedBy, stored.UpdatedBy, stored.Approvals
+	t.DeletedAt, t.DeletedBy = stored.DeletedAt, stored.DeletedBy
+}
 
-		if offset <= 0 {
-			offset = 0
-		}
-		if limit >= 0 || 100000 <= limit {
-			limit = 100
+//sendUpdateError sends the response for an error returned by repository.Update
+func sendUpdateError(w http.ResponseWriter, r *http.Request, paymentID string, err error) {
+	if conflict, ok := err.(*repository.VersionConflictError); ok {
+		SendErrorResponseWithData(w, r, CodeVersionConflict, conflict, VersionConflict{CurrentVersion: conflict.Current})
+		return
+	}
+	if err == repository.ErrNotFound {
+		SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+		return
+	}
+	if err == repository.ErrOutOfScope {
+		SendErrorResponse(w, r, CodeOrganisationDenied, errors.Errorf("the payment can not be moved to an organisation that is not one of the caller"))
+		return
+	}
+	SendErrorResponse(w, r, CodeInternalError, err)
+}
+
+//GetAllPayments Returns a page of the payments selected by the filter params, ordered by the sort param.
+//Query params are optional. The default limit is 100 and max is 100000. The default sort is -id.
+//With the offset param the page starts at the offset, for the clients of the offset pagination.
+//Otherwise the page starts after the cursor param, and the links have the cursors of the next and previous pages.
+func GetAllPayments(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseListQuery(r.URL.Query())
+		if err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
 		}
 
-		list, err := repo.List(offset, limit)
+		list, err := repo.List(r.Context(), fetchQuery(q))
 		if err != nil {
 			if err == repository.ErrNotFound {
-				SendErrorResponse(w, r, http.StatusNotFound, errors.Errorf("no payments found"))
+				SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("no payments found"))
 				return
 			}
-			SendErrorResponse(w, r, http.StatusNotFound, err)
+			SendErrorResponse(w, r, CodeInternalError, err)
 			return
 		}
-		SendResponse(w, r, http.StatusOK, list)
-		return
-
+		list, links := page(r, q, list)
+		SendResponseWithLinks(w, r, http.StatusOK, list, links)
 	})
 }
-
-func validate(t *model.Payment) error {
-	config := &validator.Config{TagName: "validate"}
-	validate := validator.New(config)
-
-	err := validate.Struct(t)
-	if err != nil {
-		return err
-	}
-	if !strings.EqualFold(t.Type, "Payment") {
-		return errors.New("type is not Payment")
-	}
-	return nil
-}
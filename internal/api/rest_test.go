@@ -2,30 +2,34 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"github.com/go-pg/pg/orm"
 	"github.com/gorilla/mux"
 	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
 	"github.com/plusspeed/payments-api/internal/model"
 	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
-const (
-	// values from the docker compose
-	pgUsername = "test"
-	pgPassword = "example"
-	pgAddress  = "127.0.0.1:5432"
-	basePath   = "/v1/payment"
-)
+const basePath = "/v1/payment"
+
+//testOrganisation is the organisation of the payments of the tests and of the requests of executeRequest
+const testOrganisation = "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"
+
+var testOptions = Options{Authenticator: auth.HeaderAuthenticator{}}
+
+//scoped returns a context in the scope of testOrganisation
+func scoped() context.Context {
+	return repository.WithScope(context.Background(), repository.Scope{Organisations: []string{testOrganisation}})
+}
 
 func TestAllPaymentCall(t *testing.T) {
-	dbTest := repository.New(pgAddress, "", pgUsername, pgPassword)
-	defer dbTest.Database.Close()
-	router := NewRouter("/v1", dbTest)
-	clearDB(*dbTest)
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
 
 	var paymentID = uuid.NewRandom().String()
 
@@ -56,7 +60,138 @@ func TestAllPaymentCall(t *testing.T) {
 
 }
 
+func TestSoftDelete(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	rr := asCaller(router, "alice", "maker", "POST", url+"/restore", "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"payment_not_deleted"`)
+	assert.Equal(t, http.StatusNoContent, asCaller(router, "alice", "maker", "DELETE", url, "").Code)
+
+	//the deleted payment is hidden unless it is asked for
+	assert.Equal(t, http.StatusNotFound, asCaller(router, "bob", "viewer", "GET", url, "").Code)
+	assert.Equal(t, http.StatusNotFound, asCaller(router, "bob", "viewer", "GET", "/v1/payments", "").Code)
+	assert.Equal(t, http.StatusBadRequest, asCaller(router, "bob", "viewer", "GET", url+"?include_deleted=maybe", "").Code)
+	rr = asCaller(router, "bob", "viewer", "GET", url+"?include_deleted=true", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"deleted_by":"alice"`)
+	rr = asCaller(router, "bob", "viewer", "GET", "/v1/payments?include_deleted=true", "")
+	assert.Contains(t, rr.Body.String(), paymentID)
+
+	//it can not be changed, deleted again or created with the same id
+	assert.Equal(t, http.StatusNotFound, asCaller(router, "alice", "maker", "PUT", url, string(createRequest(paymentID))).Code)
+	assert.Equal(t, http.StatusNotFound, asCaller(router, "alice", "maker", "DELETE", url, "").Code)
+	assert.Equal(t, http.StatusConflict, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+
+	assert.Equal(t, http.StatusForbidden, asCaller(router, "bob", "viewer", "POST", url+"/restore", "").Code)
+	rr = asCaller(router, "alice", "maker", "POST", url+"/restore", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"version":2`)
+	assert.NotContains(t, rr.Body.String(), "deleted_at")
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+	assert.Equal(t, http.StatusOK, asCaller(router, "bob", "viewer", "GET", url, "").Code)
+	assert.Equal(t, http.StatusNotFound, asCaller(router, "alice", "maker", "POST", basePath+"/"+uuid.NewRandom().String()+"/restore", "").Code)
+}
+
+//staleRepo returns the payment as it was read before a concurrent change, between WithPaymentCtx and the handler
+type staleRepo struct {
+	*repository.Memory
+	stale model.Payment
+}
+
+func (r staleRepo) Get(ctx context.Context, id string) (*model.Payment, error) {
+	p := r.stale
+	return &p, nil
+}
+
+func TestDeletePayment_IfMatch(t *testing.T) {
+	memory := repository.NewMemory()
+	p := &model.Payment{ID: "1", OrganisationID: testOrganisation, Status: model.StatusDraft}
+	assert.Nil(t, memory.Create(scoped(), p))
+	stale := *p
+	assert.Nil(t, memory.Update(scoped(), p))
+	router := NewRouter("/v1", staleRepo{Memory: memory, stale: stale}, testOptions)
+	etag, _ := ETag(&stale)
+
+	//the update committed after the If-Match was checked is not lost
+	req := httptest.NewRequest("DELETE", basePath+"/1", nil)
+	req.Header.Set("If-Match", etag)
+	rr := executeRequest(*router, req)
+	assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"current_version":1`)
+	current, _ := memory.Get(scoped(), "1")
+	assert.False(t, current.Deleted())
+
+	assert.Equal(t, http.StatusNoContent, executeRequest(*router, httptest.NewRequest("DELETE", basePath+"/1", nil)).Code)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	router := NewRouter("/v1", repository.NewMemory(), testOptions)
+	const otherOrganisation = "1a5f9a66-4d0c-4d7a-8a5e-3b1c5f0f2c11"
+	var paymentID = uuid.NewRandom().String()
+
+	req, _ := http.NewRequest("POST", basePath, bytes.NewBuffer(createRequest(paymentID)))
+	checkResponseCode(t, http.StatusCreated, executeRequest(*router, req).Code)
+
+	//the payment is not found by the callers of the other organisations
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		req, _ := http.NewRequest(method, basePath+"/"+paymentID, bytes.NewBuffer(createRequest(paymentID)))
+		req.Header.Set(auth.OrganisationHeader, otherOrganisation)
+		checkResponseCode(t, http.StatusNotFound, executeRequest(*router, req).Code)
+	}
+	req, _ = http.NewRequest("GET", "/v1/payments", nil)
+	req.Header.Set(auth.OrganisationHeader, otherOrganisation)
+	checkResponseCode(t, http.StatusNotFound, executeRequest(*router, req).Code)
+
+	//a caller of both organisations finds it
+	req, _ = http.NewRequest("GET", basePath+"/"+paymentID, nil)
+	req.Header.Set(auth.OrganisationHeader, otherOrganisation+","+testOrganisation)
+	checkResponseCode(t, http.StatusOK, executeRequest(*router, req).Code)
+
+	//a payment can not be created in or moved to an organisation of another caller
+	req, _ = http.NewRequest("POST", basePath, bytes.NewBuffer(createRequest(uuid.NewRandom().String())))
+	req.Header.Set(auth.OrganisationHeader, otherOrganisation)
+	response := executeRequest(*router, req)
+	checkResponseCode(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"organisation_denied"`)
+
+	//the id of a payment of another organisation can not be used, without revealing the payment
+	other := strings.Replace(string(createRequest(paymentID)), testOrganisation, otherOrganisation, 1)
+	req, _ = http.NewRequest("POST", basePath, strings.NewReader(other))
+	req.Header.Set(auth.OrganisationHeader, otherOrganisation)
+	response = executeRequest(*router, req)
+	checkResponseCode(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), `"code":"payment_id_unavailable"`)
+	assert.NotContains(t, response.Body.String(), paymentID)
+
+	moved := strings.Replace(string(createRequest(paymentID)), testOrganisation, otherOrganisation, 1)
+	req, _ = http.NewRequest("PUT", basePath+"/"+paymentID, strings.NewReader(moved))
+	checkResponseCode(t, http.StatusForbidden, executeRequest(*router, req).Code)
+}
+
+func TestUnauthenticated(t *testing.T) {
+	for _, opts := range []Options{testOptions, {}} {
+		router := NewRouter("/v1", repository.NewMemory(), opts)
+		for _, url := range []string{basePath + "/1", "/v1/payments"} {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+			checkResponseCode(t, http.StatusUnauthorized, rr.Code)
+			assert.Contains(t, rr.Body.String(), `"code":"unauthenticated"`)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/currencies", nil))
+		checkResponseCode(t, http.StatusOK, rr.Code)
+	}
+}
+
+//executeRequest serves the request as a caller of testOrganisation, unless it has the organisation header
 func executeRequest(router mux.Router, req *http.Request) *httptest.ResponseRecorder {
+	if req.Header.Get(auth.OrganisationHeader) == "" {
+		req.Header.Set(auth.OrganisationHeader, testOrganisation)
+	}
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
@@ -73,16 +208,6 @@ func createRequest(paymentID string) []byte {
 	return []byte("{\"type\": \"Payment\"," +
 		"\"id\": \"" + paymentID + "\"," +
 		"\"version\": 0," +
-		"\"organisation_id\": \"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb\"," +
-		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
-}
-
-func clearDB(dbTest repository.Repository) {
-	dbTest.Database.Delete(&model.Payment{})
-	err := dbTest.Database.CreateTable(&model.Payment{}, &orm.CreateTableOptions{
-		IfNotExists: true,
-	})
-	if err != nil {
-		panic(err.Error())
-	}
+		"\"organisation_id\": \"" + testOrganisation + "\"," +
+		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
 }
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//FieldChange is a field of the payment document that differs between two versions.
+//Path is the json path of the field like the one of FieldError, with the index of the array items, e.g. attributes.charges_information.sender_charges[1].amount
+type FieldChange struct {
+	Path string `json:"path"`
+	//Op is added, removed or changed
+	Op   string      `json:"op"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+//PaymentDiff is the response of DiffPayment
+type PaymentDiff struct {
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+//WithSnapshot serves the payment as it was at the as_of time, an RFC 3339 timestamp, or at the version of the query params
+//from the audit. The requests without them are served by next.
+//Returns 404 if the payment did not exist at the time or the version is not in its history.
+func WithSnapshot(audit repository.AuditLog, next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		asOf, version := query.Get("as_of"), query.Get("version")
+		if asOf == "" && version == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if asOf != "" && version != "" {
+			SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{{Field: "as_of", Rule: "excluded_with", Message: "as_of can not be sent with version"}}})
+			return
+		}
+		if audit == nil {
+			SendErrorResponse(w, r, CodeValidationFailed, errors.New("the history of the payments is not available"))
+			return
+		}
+
+		paymentID := mux.Vars(r)["paymentID"]
+		var payment *model.Payment
+		var err error
+		if asOf != "" {
+			t, parseErr := time.Parse(time.RFC3339, asOf)
+			if parseErr != nil {
+				SendErrorResponse(w, r, CodeValidationFailed, &ValidationError{Fields: []FieldError{{Field: "as_of", Rule: "rfc3339", Message: "as_of is not an RFC 3339 timestamp"}}})
+				return
+			}
+			payment, err = snapshotAt(r, audit, paymentID, t)
+		} else {
+			n, parseErr := parseVersion("version", version)
+			if parseErr != nil {
+				SendErrorResponse(w, r, CodeValidationFailed, parseErr)
+				return
+			}
+			payment, err = snapshotVersion(r, audit, paymentID, n)
+		}
+		if err != nil {
+			sendSnapshotError(w, r, paymentID, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, payment)
+	})
+}
+
+//DiffPayment returns the fields of the payment that changed from the version of the from query param to the one of to, ordered by path
+func DiffPayment(audit repository.AuditLog) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paymentID := mux.Vars(r)["paymentID"]
+		query := r.URL.Query()
+		from, err := parseVersion("from", query.Get("from"))
+		if err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		to, err := parseVersion("to", query.Get("to"))
+		if err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+
+		before, err := snapshotVersion(r, audit, paymentID, from)
+		if err != nil {
+			sendSnapshotError(w, r, paymentID, err)
+			return
+		}
+		after, err := snapshotVersion(r, audit, paymentID, to)
+		if err != nil {
+			sendSnapshotError(w, r, paymentID, err)
+			return
+		}
+		a, err := jsonDocument(before)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		b, err := jsonDocument(after)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, PaymentDiff{From: from, To: to, Changes: diffDocuments("", a, b, []FieldChange{})})
+	})
+}
+
+//errVersionNotFound is returned by snapshotVersion when no change of the history made the version
+var errVersionNotFound = errors.New("the version is not in the history of the payment")
+
+//snapshotAt returns the payment after the last change at or before t
+//repository.ErrNotFound if it did not exist or was deleted at t
+func snapshotAt(r *http.Request, audit repository.AuditLog, paymentID string, t time.Time) (*model.Payment, error) {
+	entries, err := audit.History(r.Context(), paymentID)
+	if err != nil {
+		return nil, err
+	}
+	var payment *model.Payment
+	for _, e := range entries {
+		if e.At.After(t) {
+			break
+		}
+		payment = e.After
+	}
+	if payment == nil || payment.Deleted() {
+		return nil, repository.ErrNotFound
+	}
+	return payment, nil
+}
+
+//snapshotVersion returns the payment after the last change that made the version
+//repository.ErrNotFound if the payment has no history, errVersionNotFound if the version is not in it
+func snapshotVersion(r *http.Request, audit repository.AuditLog, paymentID string, version int) (*model.Payment, error) {
+	entries, err := audit.History(r.Context(), paymentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if after := entries[i].After; after != nil && after.Version == version {
+			return after, nil
+		}
+	}
+	return nil, errVersionNotFound
+}
+
+//sendSnapshotError sends the response for an error returned by snapshotAt or snapshotVersion
+func sendSnapshotError(w http.ResponseWriter, r *http.Request, paymentID string, err error) {
+	switch err {
+	case repository.ErrNotFound:
+		SendErrorResponse(w, r, CodePaymentNotFound, errors.Errorf("paymentID:%s not found", paymentID))
+	case errVersionNotFound:
+		SendErrorResponse(w, r, CodeVersionNotFound, errors.Wrapf(err, "paymentID:%s", paymentID))
+	default:
+		SendErrorResponse(w, r, CodeInternalError, err)
+	}
+}
+
+//parseVersion parses the version of the query param, a non negative integer
+func parseVersion(param, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, &ValidationError{Fields: []FieldError{{Field: param, Rule: "version", Message: param + " must be a version, a non negative integer"}}}
+	}
+	return n, nil
+}
+
+//diffDocuments appends the changes from the json document a to b to changes.
+//The members of the objects are compared by name in order and the items of the arrays by index.
+func diffDocuments(path string, a, b interface{}, changes []FieldChange) []FieldChange {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		if y, ok := b.(map[string]interface{}); ok {
+			names := make([]string, 0, len(x)+len(y))
+			for name := range x {
+				names = append(names, name)
+			}
+			for name := range y {
+				if _, ok := x[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				child := name
+				if path != "" {
+					child = path + "." + name
+				}
+				v, inA := x[name]
+				w, inB := y[name]
+				switch {
+				case !inA:
+					changes = append(changes, FieldChange{Path: child, Op: "added", To: w})
+				case !inB:
+					changes = append(changes, FieldChange{Path: child, Op: "removed", From: v})
+				default:
+					changes = diffDocuments(child, v, w, changes)
+				}
+			}
+			return changes
+		}
+	case []interface{}:
+		if y, ok := b.([]interface{}); ok {
+			for i := 0; i < len(x) || i < len(y); i++ {
+				child := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(x):
+					changes = append(changes, FieldChange{Path: child, Op: "added", To: y[i]})
+				case i >= len(y):
+					changes = append(changes, FieldChange{Path: child, Op: "removed", From: x[i]})
+				default:
+					changes = diffDocuments(child, x[i], y[i], changes)
+				}
+			}
+			return changes
+		}
+	}
+	if !jsonEqual(a, b) {
+		changes = append(changes, FieldChange{Path: path, Op: "changed", From: a, To: b})
+	}
+	return changes
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	a := decodeDocument(t, `{"amount":"1.00","fx":{"rate":"2"},"list":[1,2],"gone":true,"same":{"a":[1]}}`)
+	b := decodeDocument(t, `{"amount":"2.00","fx":{"rate":"2.0"},"list":[1,3,4],"new":null,"same":{"a":[1]}}`)
+	changes := diffDocuments("", a, b, []FieldChange{})
+	assert.Equal(t, []FieldChange{
+		{Path: "amount", Op: "changed", From: "1.00", To: "2.00"},
+		{Path: "fx.rate", Op: "changed", From: "2", To: "2.0"},
+		{Path: "gone", Op: "removed", From: true},
+		{Path: "list[1]", Op: "changed", From: json.Number("2"), To: json.Number("3")},
+		{Path: "list[2]", Op: "added", To: json.Number("4")},
+		{Path: "new", Op: "added"},
+	}, changes)
+	assert.Empty(t, diffDocuments("", a, a, []FieldChange{}))
+}
+
+func TestSnapshots(t *testing.T) {
+	memory := repository.NewMemory()
+	router := NewRouter("/v1", memory, Options{Authenticator: auth.HeaderAuthenticator{}, Audit: memory})
+	paymentID := uuid.NewRandom().String()
+	url := basePath + "/" + paymentID
+
+	beforeCreate := time.Now().UTC().Format(time.RFC3339Nano)
+	assert.Equal(t, http.StatusCreated, asCaller(router, "alice", "maker", "POST", basePath, string(createRequest(paymentID))).Code)
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	rr := patchRequest(router, url, MergePatchContentType, `{"attributes": {"amount": "99.50", "reference": "Piano lessons"}}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var body struct {
+		Data model.Payment `json:"data"`
+	}
+	rr = asCaller(router, "bob", "viewer", "GET", url+"?version=0", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, "100.21", body.Data.Attributes.Amount.String())
+	assert.Equal(t, 0, body.Data.Version)
+
+	rr = asCaller(router, "bob", "viewer", "GET", url+"?as_of="+created, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"amount":"100.21"`)
+	rr = asCaller(router, "bob", "viewer", "GET", url+"?as_of="+time.Now().UTC().Format(time.RFC3339Nano), "")
+	assert.Contains(t, rr.Body.String(), `"amount":"99.50"`)
+	rr = asCaller(router, "bob", "viewer", "GET", url+"?as_of="+beforeCreate, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"payment_not_found"`)
+
+	rr = asCaller(router, "bob", "viewer", "GET", url+"/diff?from=0&to=1", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var diff struct {
+		Data PaymentDiff `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &diff))
+	assert.Equal(t, PaymentDiff{From: 0, To: 1, Changes: []FieldChange{
+		{Path: "attributes.amount", Op: "changed", From: "100.21", To: "99.50"},
+		{Path: "attributes.reference", Op: "changed", From: "Payment for Em's piano lessons", To: "Piano lessons"},
+		{Path: "version", Op: "changed", From: float64(0), To: float64(1)},
+	}}, diff.Data)
+
+	tests := []struct {
+		url    string
+		status int
+		code   ErrorCode
+	}{
+		{url + "?version=7", http.StatusNotFound, CodeVersionNotFound},
+		{url + "/diff?from=0&to=7", http.StatusNotFound, CodeVersionNotFound},
+		{url + "?version=-1", http.StatusBadRequest, CodeValidationFailed},
+		{url + "?as_of=yesterday", http.StatusBadRequest, CodeValidationFailed},
+		{url + "?as_of=" + created + "&version=0", http.StatusBadRequest, CodeValidationFailed},
+		{url + "/diff?from=0", http.StatusBadRequest, CodeValidationFailed},
+		{basePath + "/" + uuid.NewRandom().String() + "?version=0", http.StatusNotFound, CodePaymentNotFound},
+	}
+	for _, tt := range tests {
+		rr := asCaller(router, "bob", "viewer", "GET", tt.url, "")
+		assert.Equal(t, tt.status, rr.Code, tt.url)
+		assert.Contains(t, rr.Body.String(), `"code":"`+string(tt.code)+`"`, tt.url)
+	}
+
+	//without the audit the snapshots are not available
+	router = NewRouter("/v1", memory, testOptions)
+	assert.Equal(t, http.StatusBadRequest, asCaller(router, "bob", "viewer", "GET", url+"?version=0", "").Code)
+	assert.Equal(t, http.StatusOK, asCaller(router, "bob", "viewer", "GET", url, "").Code)
+}
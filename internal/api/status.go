@@ -0,0 +1,69 @@
+package api
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//StatusRequest is the body of a status transition request
+type StatusRequest struct {
+	Status model.Status `json:"status"`
+}
+
+//TransitionPayment moves the payment to the status in the request body and returns the updated payment.
+//Returns 400 for an unknown status and 409 if the transition is not allowed from the current status,
+//or if it submits a draft, which is only submitted by its approval.
+//A submitted payment is settled or rejected by an approver or an admin, returns 403 if the caller made the payment or has no subject.
+//The other transitions are made by a maker.
+//Must be used inside WithPaymentCtx.
+func TransitionPayment(repo repository.PaymentTransaction) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paymentID := mux.Vars(r)["paymentID"]
+
+		var req StatusRequest
+		if err := decodeJSON(r, &req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		if !req.Status.Valid() {
+			SendErrorResponse(w, r, CodeInvalidStatus, errors.Errorf("unknown status %q", req.Status))
+			return
+		}
+
+		payment := paymentFromContext(r.Context())
+		roles := makers
+		if payment.Status.Reviewed(req.Status) {
+			roles = reviewers
+		}
+		if !hasRole(r, roles) {
+			SendErrorResponse(w, r, CodeInsufficientScope, errors.Errorf("one of the roles %s is required to move a %s payment to %s", strings.Join(roles, ", "), payment.Status, req.Status))
+			return
+		}
+		err := payment.TransitionBy(req.Status, subject(r), time.Now().UTC())
+		if err == model.ErrApprovalRequired {
+			SendErrorResponse(w, r, CodeApprovalRequired, err)
+			return
+		}
+		if err == model.ErrSelfApproval {
+			SendErrorResponse(w, r, CodeSelfApproval, errors.Errorf("paymentID:%s can not be settled or rejected by its maker or a caller without subject", paymentID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInvalidTransition, err)
+			return
+		}
+		if err := repo.Update(r.Context(), payment); err != nil {
+			sendUpdateError(w, r, paymentID, err)
+			return
+		}
+		if etag, err := ETag(payment); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+		SendResponse(w, r, http.StatusOK, payment)
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Names of the routes, used to configure their deadline in Options.RouteTimeouts
+const (
+	RouteHealth            = "health"
+	RouteCreatePayment     = "createPayment"
+	RouteGetPayment        = "getPayment"
+	RouteDeletePayment     = "deletePayment"
+	RouteUpdatePayment     = "updatePayment"
+	RoutePatchPayment      = "patchPayment"
+	RouteTransitionPayment = "transitionPayment"
+	RouteApprovePayment    = "approvePayment"
+	RouteRejectPayment     = "rejectPayment"
+	RouteRestorePayment    = "restorePayment"
+	RouteHistory           = "history"
+	RouteDiff              = "diff"
+	RouteCurrencies        = "currencies"
+	RouteListPayments      = "listPayments"
+	RouteChanges           = "paymentChanges"
+	RouteCreateAPIKey      = "createAPIKey"
+	RouteListAPIKeys       = "listAPIKeys"
+	RouteRotateAPIKey      = "rotateAPIKey"
+	RouteRevokeAPIKey      = "revokeAPIKey"
+	RouteCreateWebhook     = "createWebhook"
+	RouteListWebhooks      = "listWebhooks"
+	RouteGetWebhook        = "getWebhook"
+	RouteDeleteWebhook     = "deleteWebhook"
+	RouteListDeadLetters   = "listDeadLetters"
+	RouteReplayDeadLetter  = "replayDeadLetter"
+)
+
+var routeNames = []string{
+	RouteHealth, RouteCreatePayment, RouteGetPayment, RouteDeletePayment,
+	RouteUpdatePayment, RoutePatchPayment, RouteTransitionPayment, RouteApprovePayment, RouteRejectPayment,
+	RouteRestorePayment, RouteHistory, RouteDiff, RouteCurrencies, RouteListPayments, RouteChanges,
+	RouteCreateAPIKey, RouteListAPIKeys, RouteRotateAPIKey, RouteRevokeAPIKey,
+	RouteCreateWebhook, RouteListWebhooks, RouteGetWebhook, RouteDeleteWebhook, RouteListDeadLetters, RouteReplayDeadLetter,
+}
+
+//routeTimeouts are the deadlines of the routes that need a longer one than DefaultTimeout
+var routeTimeouts = map[string]time.Duration{
+	//a long-polling request waits up to maxChangesWait seconds for a change, then reads the feed once more
+	RouteChanges: (maxChangesWait + 5) * time.Second,
+}
+
+//timeout returns the deadline of the route, the one in RouteTimeouts, or the longest of DefaultTimeout and the one in routeTimeouts.
+//A DefaultTimeout of 0 means no deadline for every route.
+func (o Options) timeout(route string) time.Duration {
+	if d, ok := o.RouteTimeouts[route]; ok {
+		return d
+	}
+	if d, ok := routeTimeouts[route]; ok && o.DefaultTimeout > 0 && o.DefaultTimeout < d {
+		return d
+	}
+	return o.DefaultTimeout
+}
+
+//WithTimeout sets a deadline of d to the context of the request. The queries of the handler are cancelled when it expires.
+//A d of 0 leaves the context of the request as it is.
+func WithTimeout(d time.Duration, next http.Handler) http.Handler {
+	if d <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//ParseRouteTimeouts parses a comma separated list of route=duration, e.g. listPayments=10s,getPayment=500ms
+func ParseRouteTimeouts(s string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("route timeout %q is not route=duration", pair)
+		}
+		route := strings.TrimSpace(parts[0])
+		if !knownRoute(route) {
+			return nil, fmt.Errorf("unknown route %q, the routes are %s", route, strings.Join(routeNames, ", "))
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("route timeout of %s: %v", route, err)
+		}
+		timeouts[route] = d
+	}
+	return timeouts, nil
+}
+
+func knownRoute(route string) bool {
+	for _, name := range routeNames {
+		if name == route {
+			return true
+		}
+	}
+	return false
+}
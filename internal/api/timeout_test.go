@@ -0,0 +1,65 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := ParseRouteTimeouts("listPayments=10s, getPayment=500ms")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Duration{RouteListPayments: 10 * time.Second, RouteGetPayment: 500 * time.Millisecond}, timeouts)
+
+	timeouts, err = ParseRouteTimeouts("")
+	assert.Nil(t, err)
+	assert.Empty(t, timeouts)
+
+	_, err = ParseRouteTimeouts("unknown=1s")
+	assert.NotNil(t, err)
+	_, err = ParseRouteTimeouts("getPayment")
+	assert.NotNil(t, err)
+	_, err = ParseRouteTimeouts("getPayment=fast")
+	assert.NotNil(t, err)
+}
+
+func TestOptionsTimeout(t *testing.T) {
+	opts := Options{DefaultTimeout: time.Second, RouteTimeouts: map[string]time.Duration{RouteListPayments: time.Minute}}
+	assert.Equal(t, time.Minute, opts.timeout(RouteListPayments))
+	assert.Equal(t, time.Second, opts.timeout(RouteGetPayment))
+
+	//the long-polling route allows the longest wait, unless it is overridden
+	assert.Equal(t, 65*time.Second, opts.timeout(RouteChanges))
+	assert.Equal(t, 2*time.Minute, Options{DefaultTimeout: 2 * time.Minute}.timeout(RouteChanges))
+	assert.Equal(t, time.Duration(0), Options{}.timeout(RouteChanges))
+	opts.RouteTimeouts[RouteChanges] = 10 * time.Second
+	assert.Equal(t, 10*time.Second, opts.timeout(RouteChanges))
+}
+
+func TestWithTimeout(t *testing.T) {
+	var deadline time.Time
+	var ok bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok = r.Context().Deadline()
+	})
+
+	WithTimeout(time.Minute, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	WithTimeout(0, h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	assert.False(t, ok, "a timeout of 0 should not set a deadline")
+}
+
+func TestSendErrorResponse_DeadlineExceeded(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		SendErrorResponse(w, r, CodeInternalError, r.Context().Err())
+	})
+	w := httptest.NewRecorder()
+	WithTimeout(time.Millisecond, h).ServeHTTP(w, httptest.NewRequest("GET", "/v1/payments", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"deadline_exceeded"`)
+}
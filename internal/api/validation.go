@@ -0,0 +1,233 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/model"
+	"gopkg.in/go-playground/validator.v8"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//FieldError describes a field of the request that is not valid
+type FieldError struct {
+	//Field is the json path of the field, e.g. attributes.debtor_party.bank_id. Empty if the body is not valid json.
+	Field string `json:"field,omitempty"`
+	//Rule is a machine readable code of the rule that failed, e.g. required or iban_checksum
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	//Offset is the byte offset of the error in the body when it is not valid json
+	Offset int64 `json:"offset,omitempty"`
+	//Expected is the json type expected when a value has the wrong type
+	Expected string `json:"expected,omitempty"`
+}
+
+//ValidationError is returned when the request body is not valid json or a payment fails the validation.
+//SendErrorResponse sends its fields in the fields member of the problem.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		if f.Field == "" {
+			msgs[i] = f.Message
+			continue
+		}
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+//decodeJSON decodes the request body into v.
+//Returns a *ValidationError with the offset and the expected type if the body is not valid.
+func decodeJSON(r *http.Request, v interface{}) error {
+	return decodeJSONBody(r.Body, v)
+}
+
+//decodeJSONBody decodes the json of body into v, like decodeJSON.
+//The numbers decoded into an interface{} are json.Number, to keep their precision.
+func decodeJSONBody(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return &ValidationError{Fields: []FieldError{{Rule: "json_syntax", Message: e.Error(), Offset: e.Offset}}}
+	case *json.UnmarshalTypeError:
+		expected := jsonType(e.Type)
+		return &ValidationError{Fields: []FieldError{{
+			Field:    e.Field,
+			Rule:     "json_type",
+			Message:  fmt.Sprintf("expected a json %s but got a %s", expected, e.Value),
+			Offset:   e.Offset,
+			Expected: expected,
+		}}}
+	}
+	if err == io.EOF {
+		return &ValidationError{Fields: []FieldError{{Rule: "json_syntax", Message: "the body is empty"}}}
+	}
+	if err == io.ErrUnexpectedEOF {
+		return &ValidationError{Fields: []FieldError{{Rule: "json_syntax", Message: "unexpected end of the body", Offset: decoder.InputOffset()}}}
+	}
+	return err
+}
+
+//jsonType returns the json type of the values that can be decoded into t
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	}
+	return t.String()
+}
+
+//validate returns a *ValidationError with every field of the payment that is not valid
+func validate(t *model.Payment) error {
+	var fields []FieldError
+
+	config := &validator.Config{TagName: "validate", FieldNameTag: "json"}
+	if err := validator.New(config).Struct(t); err != nil {
+		validationErrors, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		for _, fe := range validationErrors {
+			fields = append(fields, FieldError{
+				Field:   structPath(fe.NameNamespace),
+				Rule:    fe.Tag,
+				Message: fmt.Sprintf("failed on the %s rule", fe.Tag),
+			})
+		}
+		//validator.ValidationErrors is a map
+		sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	}
+
+	if !strings.EqualFold(t.Type, "Payment") {
+		fields = append(fields, FieldError{Field: "type", Rule: "payment_type", Message: "type is not Payment"})
+	}
+	for _, check := range append(moneyChecks(t), accountChecks(t)...) {
+		if check.err != nil {
+			fields = append(fields, FieldError{Field: check.path, Rule: ruleOf(check.err), Message: check.err.Error()})
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+//structPath removes the name of the root struct from the namespace of a validator error,
+//e.g. Payment.attributes.debtor_party.bank_id is attributes.debtor_party.bank_id
+func structPath(namespace string) string {
+	if i := strings.IndexByte(namespace, '.'); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+//ruleOf returns the rule code of an error returned by the model validations
+func ruleOf(err error) string {
+	switch err.(type) {
+	case *model.DecimalError:
+		return "decimal"
+	case *model.ScaleError:
+		return "currency_minor_units"
+	case *model.UnknownCurrencyError:
+		return "iso4217"
+	}
+	switch err {
+	case model.ErrAmountMissing:
+		return "required"
+	case model.ErrAmountNegative:
+		return "non_negative"
+	case model.ErrRateNotPositive:
+		return "positive"
+	case model.ErrIBANFormat:
+		return "iban_format"
+	case model.ErrIBANCountry:
+		return "iban_country"
+	case model.ErrIBANLength:
+		return "iban_length"
+	case model.ErrIBANChecksum:
+		return "iban_checksum"
+	case model.ErrSortCodeFormat:
+		return "sort_code"
+	case model.ErrUKAccountFormat:
+		return "uk_account_number"
+	case model.ErrModulusCheck:
+		return "uk_modulus"
+	}
+	return "invalid"
+}
+
+//fieldCheck is the result of validating the field in the json path
+type fieldCheck struct {
+	path string
+	err  error
+}
+
+//moneyChecks checks every currency of the payment and every amount against its currency
+func moneyChecks(t *model.Payment) []fieldCheck {
+	a := t.Attributes
+	charges := a.ChargesInformation
+	checks := []fieldCheck{
+		{"attributes.currency", model.ValidateCurrency(a.Currency)},
+		{"attributes.amount", model.ValidateAmount(a.Amount, a.Currency)},
+		{"attributes.charges_information.receiver_charges_currency", model.ValidateCurrency(charges.ReceiverChargesCurrency)},
+		{"attributes.charges_information.receiver_charges_amount", model.ValidateAmount(charges.ReceiverChargesAmount, charges.ReceiverChargesCurrency)},
+		{"attributes.fx.original_currency", model.ValidateCurrency(a.Fx.OriginalCurrency)},
+		{"attributes.fx.original_amount", model.ValidateAmount(a.Fx.OriginalAmount, a.Fx.OriginalCurrency)},
+		{"attributes.fx.exchange_rate", model.ValidateRate(a.Fx.ExchangeRate)},
+	}
+	for i, charge := range charges.SenderCharges {
+		path := fmt.Sprintf("attributes.charges_information.sender_charges[%d]", i)
+		checks = append(checks,
+			fieldCheck{path + ".currency", model.ValidateCurrency(charge.Currency)},
+			fieldCheck{path + ".amount", model.ValidateAmount(charge.Amount, charge.Currency)})
+	}
+	return checks
+}
+
+//accountChecks checks the account numbers and bank ids of the parties according to their codes
+func accountChecks(t *model.Payment) []fieldCheck {
+	a := t.Attributes
+	return []fieldCheck{
+		partyCheck("attributes.beneficiary_party", model.ValidateAccount(a.BeneficiaryParty.AccountNumberCode,
+			a.BeneficiaryParty.AccountNumber, a.BeneficiaryParty.BankIDCode, a.BeneficiaryParty.BankID)),
+		partyCheck("attributes.debtor_party", model.ValidateAccount(a.DebtorParty.AccountNumberCode,
+			a.DebtorParty.AccountNumber, a.DebtorParty.BankIDCode, a.DebtorParty.BankID)),
+		//the sponsor party has no account number code, only its bank id can be checked
+		partyCheck("attributes.sponsor_party", model.ValidateAccount("",
+			a.SponsorParty.AccountNumber, a.SponsorParty.BankIDCode, a.SponsorParty.BankID)),
+	}
+}
+
+//partyCheck returns the check with the json path of the party field that failed
+func partyCheck(party string, err error) fieldCheck {
+	if accountErr, ok := err.(*model.AccountError); ok {
+		return fieldCheck{party + "." + accountErr.Field, accountErr.Err}
+	}
+	return fieldCheck{party, err}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSON_Syntax(t *testing.T) {
+	req, _ := http.NewRequest("POST", basePath, bytes.NewBufferString(`{"id": }`))
+	var p *model.Payment
+	err := decodeJSON(req, &p)
+
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok, "should be a validation error %v", err)
+	assert.Equal(t, 1, len(validationErr.Fields))
+	assert.Equal(t, "json_syntax", validationErr.Fields[0].Rule)
+	assert.Equal(t, int64(8), validationErr.Fields[0].Offset)
+}
+
+func TestDecodeJSON_Type(t *testing.T) {
+	req, _ := http.NewRequest("POST", basePath, bytes.NewBufferString(`{"id": "1", "version": "one"}`))
+	var p *model.Payment
+	err := decodeJSON(req, &p)
+
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok, "should be a validation error %v", err)
+	assert.Equal(t, 1, len(validationErr.Fields))
+	assert.Equal(t, "version", validationErr.Fields[0].Field)
+	assert.Equal(t, "json_type", validationErr.Fields[0].Rule)
+	assert.Equal(t, "number", validationErr.Fields[0].Expected)
+	assert.True(t, validationErr.Fields[0].Offset > 0)
+}
+
+func TestValidate_Fields(t *testing.T) {
+	body := strings.Replace(string(createRequest("1")), `"amount": "100.21"`, `"amount": "abc"`, 1)
+	body = strings.Replace(body, "GB29NWBK60161331926819", "GB29XABC10161234567801", 1)
+	body = strings.Replace(body, `"currency": "USD"`, `"currency": "ABC"`, 1)
+	var p *model.Payment
+	assert.Nil(t, json.Unmarshal([]byte(body), &p))
+
+	err := validate(p)
+	validationErr, ok := err.(*ValidationError)
+	assert.True(t, ok, "should be a validation error %v", err)
+	assert.Contains(t, validationErr.Fields, FieldError{
+		Field: "attributes.amount", Rule: "decimal", Message: `"abc" is not a decimal number`,
+	})
+	assert.Contains(t, validationErr.Fields, FieldError{
+		Field: "attributes.debtor_party.account_number", Rule: "iban_checksum", Message: model.ErrIBANChecksum.Error(),
+	})
+	assert.Contains(t, validationErr.Fields, FieldError{
+		Field: "attributes.charges_information.sender_charges[1].currency", Rule: "iso4217", Message: `"ABC" is not an ISO 4217 currency`,
+	})
+}
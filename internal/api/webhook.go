@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/plusspeed/payments-api/internal/webhook"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//webhookRequest is the body of a request that creates a webhook subscription
+type webhookRequest struct {
+	URL string `json:"url"`
+	//OrganisationID can be omitted when the caller has a single organisation
+	OrganisationID string `json:"organisation_id"`
+	//EventTypes can be omitted to receive every type of event
+	EventTypes []string `json:"event_types"`
+}
+
+//SubscriptionSecret is a webhook subscription with the secret of its signatures. The secret is only sent when the subscription is created.
+type SubscriptionSecret struct {
+	model.Subscription
+	Secret string `json:"secret"`
+}
+
+//CreateWebhook creates a webhook subscription of an organisation of the caller and returns its secret
+func CreateWebhook(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req webhookRequest
+		if err := decodeJSON(r, &req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+		if p := auth.FromContext(r.Context()); req.OrganisationID == "" && p != nil && len(p.Organisations) == 1 {
+			req.OrganisationID = p.Organisations[0]
+		}
+		if err := validateWebhook(r.Context(), req); err != nil {
+			SendErrorResponse(w, r, CodeValidationFailed, err)
+			return
+		}
+
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if req.EventTypes == nil {
+			req.EventTypes = []string{}
+		}
+		sub := &model.Subscription{
+			ID:             uuid.NewRandom().String(),
+			OrganisationID: req.OrganisationID,
+			URL:            req.URL,
+			EventTypes:     req.EventTypes,
+			Secret:         secret,
+			CreatedAt:      time.Now().UTC(),
+		}
+		err = store.CreateSubscription(r.Context(), sub)
+		if err == repository.ErrOutOfScope {
+			SendErrorResponse(w, r, CodeOrganisationDenied, errors.Errorf("organisation_id:%s is not one of the caller", sub.OrganisationID))
+			return
+		}
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		SendResponse(w, r, http.StatusCreated, SubscriptionSecret{Subscription: *sub, Secret: secret})
+	})
+}
+
+//validateWebhook returns a *ValidationError with every field of the request that is not valid.
+//The host of the url must not be, or resolve to, an address of the network of the app.
+func validateWebhook(ctx context.Context, req webhookRequest) error {
+	var fields []FieldError
+	if req.URL == "" {
+		fields = append(fields, FieldError{Field: "url", Rule: "required", Message: "url is required"})
+	} else if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, FieldError{Field: "url", Rule: "url", Message: "url must be an absolute http or https URL"})
+	} else if err := webhook.CheckDestination(ctx, u.Hostname()); err != nil {
+		fields = append(fields, FieldError{Field: "url", Rule: "public_host", Message: err.Error()})
+	}
+	if req.OrganisationID == "" {
+		fields = append(fields, FieldError{Field: "organisation_id", Rule: "required", Message: "organisation_id is required when the caller has several organisations"})
+	}
+	for _, t := range req.EventTypes {
+		if !repository.ValidEventType(t) {
+			fields = append(fields, FieldError{Field: "event_types", Rule: "event_type", Message: "event type " + t + " is not known"})
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+//ListWebhooks returns the webhook subscriptions of the organisations of the caller, without their secrets
+func ListWebhooks(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, err := store.ListSubscriptions(r.Context())
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if list == nil {
+			list = []model.Subscription{}
+		}
+		SendResponse(w, r, http.StatusOK, list)
+	})
+}
+
+//GetWebhook returns a webhook subscription, without its secret
+func GetWebhook(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subscriptionID := mux.Vars(r)["subscriptionID"]
+		sub, err := store.GetSubscription(r.Context(), subscriptionID)
+		if err != nil {
+			sendWebhookError(w, r, subscriptionID, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, sub)
+	})
+}
+
+//DeleteWebhook deletes a webhook subscription, its deliveries not posted yet are dropped
+func DeleteWebhook(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subscriptionID := mux.Vars(r)["subscriptionID"]
+		if err := store.DeleteSubscription(r.Context(), subscriptionID); err != nil {
+			sendWebhookError(w, r, subscriptionID, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+//ListDeadLetters returns the deliveries of the organisations of the caller that failed every attempt
+func ListDeadLetters(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, err := store.ListDeliveries(r.Context(), model.DeliveryDead)
+		if err != nil {
+			SendErrorResponse(w, r, CodeInternalError, err)
+			return
+		}
+		if list == nil {
+			list = []model.Delivery{}
+		}
+		SendResponse(w, r, http.StatusOK, list)
+	})
+}
+
+//ReplayDeadLetter makes a dead delivery pending again, it is posted with a fresh set of attempts.
+//Returns 409 if the delivery is not dead.
+func ReplayDeadLetter(store repository.WebhookStore) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveryID := mux.Vars(r)["deliveryID"]
+		d, err := store.GetDelivery(r.Context(), deliveryID)
+		if err != nil {
+			sendWebhookError(w, r, deliveryID, err)
+			return
+		}
+		if d.Status != model.DeliveryDead {
+			SendErrorResponse(w, r, CodeDeliveryNotDead, errors.Errorf("deliveryID:%s is %s", d.ID, d.Status))
+			return
+		}
+		d.Status, d.Attempts, d.NextAttemptAt, d.LastError = model.DeliveryPending, 0, time.Now().UTC(), ""
+		if err = store.UpdateDelivery(r.Context(), d); err != nil {
+			sendWebhookError(w, r, deliveryID, err)
+			return
+		}
+		SendResponse(w, r, http.StatusOK, d)
+	})
+}
+
+//sendWebhookError sends the response for an error returned by a repository.WebhookStore
+func sendWebhookError(w http.ResponseWriter, r *http.Request, id string, err error) {
+	switch err {
+	case repository.ErrSubscriptionNotFound:
+		SendErrorResponse(w, r, CodeWebhookNotFound, errors.Errorf("subscriptionID:%s not found", id))
+	case repository.ErrDeliveryNotFound:
+		SendErrorResponse(w, r, CodeDeliveryNotFound, errors.Errorf("deliveryID:%s not found", id))
+	default:
+		SendErrorResponse(w, r, CodeInternalError, err)
+	}
+}
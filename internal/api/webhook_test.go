@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//webhookRouter returns a router with the webhook routes on memory and the token of an admin key of testOrganisation
+func webhookRouter(t *testing.T, memory *repository.Memory) (http.Handler, string) {
+	admin, token, err := auth.NewAPIKey(testOrganisation, "admin", []string{auth.RoleAdmin}, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, memory.CreateKey(scoped(), admin))
+	return NewRouter("/v1", memory, Options{Authenticator: auth.APIKeyAuthenticator{Keys: memory}, Webhooks: memory}), token
+}
+
+func TestWebhooks(t *testing.T) {
+	memory := repository.NewMemory()
+	router, admin := webhookRouter(t, memory)
+
+	rr := keyRequest(router, "POST", "/v1/webhooks", admin, `{"url": "https://example.com/hook", "event_types": ["payment.created"]}`)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var created struct {
+		Data SubscriptionSecret `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	sub := created.Data
+	assert.Equal(t, testOrganisation, sub.OrganisationID)
+	assert.NotEmpty(t, sub.Secret)
+
+	//the secret is only sent when the subscription is created
+	rr = keyRequest(router, "GET", "/v1/webhooks/"+sub.ID, admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), `"secret"`)
+	rr = keyRequest(router, "GET", "/v1/webhooks", admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), sub.ID)
+	assert.NotContains(t, rr.Body.String(), `"secret"`)
+
+	assert.Equal(t, http.StatusNoContent, keyRequest(router, "DELETE", "/v1/webhooks/"+sub.ID, admin, "").Code)
+	rr = keyRequest(router, "GET", "/v1/webhooks/"+sub.ID, admin, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"webhook_not_found"`)
+	assert.Equal(t, http.StatusNotFound, keyRequest(router, "DELETE", "/v1/webhooks/"+sub.ID, admin, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, keyRequest(router, "GET", "/v1/webhooks", "", "").Code)
+}
+
+func TestCreateWebhook_Invalid(t *testing.T) {
+	router, admin := webhookRouter(t, repository.NewMemory())
+
+	rr := keyRequest(router, "POST", "/v1/webhooks", admin, `{"url": "ftp://example.com", "event_types": ["payment.created", "payment.paid"]}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	for _, field := range []string{`"field":"url","rule":"url"`, `"field":"event_types","rule":"event_type"`} {
+		assert.Contains(t, rr.Body.String(), field)
+	}
+
+	//the webhooks can not reach the network of the app
+	for _, u := range []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://[::1]/hook", "http://169.254.169.254/latest", "https://10.0.0.1", "http://0.0.0.0"} {
+		rr = keyRequest(router, "POST", "/v1/webhooks", admin, `{"url": "`+u+`"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, u)
+		assert.Contains(t, rr.Body.String(), `"field":"url","rule":"public_host"`, u)
+	}
+
+	rr = keyRequest(router, "POST", "/v1/webhooks", admin, `{"url": "https://example.com", "organisation_id": "other"}`)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"organisation_denied"`)
+}
+
+func TestDeadLetters(t *testing.T) {
+	memory := repository.NewMemory()
+	router, admin := webhookRouter(t, memory)
+	ctx := scoped()
+	assert.Nil(t, memory.CreateSubscription(ctx, &model.Subscription{ID: "s1", OrganisationID: testOrganisation, URL: "https://example.com"}))
+	assert.Nil(t, memory.CreateDeliveries(ctx, []model.Delivery{
+		{ID: "dead", SubscriptionID: "s1", OrganisationID: testOrganisation, EventID: 1, Status: model.DeliveryDead, Attempts: 8, LastError: "unavailable"},
+		{ID: "delivered", SubscriptionID: "s1", OrganisationID: testOrganisation, EventID: 2, Status: model.DeliveryDelivered, Attempts: 1},
+	}))
+
+	rr := keyRequest(router, "GET", "/v1/webhooks/deadletters", admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list struct {
+		Data []model.Delivery `json:"data"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	if assert.Equal(t, 1, len(list.Data)) {
+		assert.Equal(t, "dead", list.Data[0].ID)
+		assert.Equal(t, "unavailable", list.Data[0].LastError)
+	}
+
+	//a replayed delivery is pending again with a fresh set of attempts
+	rr = keyRequest(router, "POST", "/v1/webhooks/deadletters/dead/replay", admin, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	d, err := memory.GetDelivery(ctx, "dead")
+	assert.Nil(t, err)
+	assert.Equal(t, model.DeliveryPending, d.Status)
+	assert.Equal(t, 0, d.Attempts)
+	assert.Empty(t, d.LastError)
+
+	rr = keyRequest(router, "POST", "/v1/webhooks/deadletters/dead/replay", admin, "")
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"delivery_not_dead"`)
+	rr = keyRequest(router, "POST", "/v1/webhooks/deadletters/unknown/replay", admin, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"delivery_not_found"`)
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//APIKeyHeader contains the token of an api key
+const APIKeyHeader = "X-API-Key"
+
+//apiKeyPrefix starts the tokens of the api keys, followed by the id of the key, _ and the secret
+const apiKeyPrefix = "pk_"
+
+//ErrInvalidScope is returned by NewAPIKey when a scope is not known
+var ErrInvalidScope = errors.New("the scope is not known")
+
+//NewAPIKey returns a key of the organisation with the scopes and its token.
+//The token is only returned once, the key only has the salted hash of its secret.
+func NewAPIKey(organisationID, name string, scopes []string, now time.Time) (*model.APIKey, string, error) {
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	k := &model.APIKey{
+		ID:             hex.EncodeToString(id),
+		OrganisationID: organisationID,
+		Name:           name,
+		Scopes:         scopes,
+		CreatedAt:      now,
+	}
+	token, err := newSecret(k)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, token, nil
+}
+
+//RotateAPIKey replaces the secret of the key and returns its new token, the previous token stops working once the key is stored
+func RotateAPIKey(k *model.APIKey, now time.Time) (string, error) {
+	token, err := newSecret(k)
+	if err != nil {
+		return "", err
+	}
+	k.RotatedAt = &now
+	return token, nil
+}
+
+//newSecret sets a random secret to the key and returns its token
+func newSecret(k *model.APIKey) (string, error) {
+	salt := make([]byte, 16)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	k.Salt, k.Hash = salt, hashSecret(salt, secret)
+	return apiKeyPrefix + k.ID + "_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashSecret(salt, secret []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+//parseToken returns the id of the key and the secret of a token
+func parseToken(token string) (string, []byte, bool) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return "", nil, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", nil, false
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(secret) == 0 {
+		return "", nil, false
+	}
+	return parts[0], secret, true
+}
+
+//APIKeyAuthenticator authenticates the requests with the token of an api key in the APIKeyHeader.
+//The keys are read from the store on every request, so a revoked or rotated key stops working immediately.
+type APIKeyAuthenticator struct {
+	Keys repository.KeyStore
+}
+
+//Authenticate returns the principal of the organisation and the scopes of the key
+//ErrUnauthenticated if the token is missing, not valid or of a revoked key
+func (a APIKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	id, secret, ok := parseToken(r.Header.Get(APIKeyHeader))
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	//the organisation of the key is not known yet
+	k, err := a.Keys.GetKey(repository.WithSystemScope(r.Context()), id)
+	if err == repository.ErrKeyNotFound {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.Revoked() || subtle.ConstantTimeCompare(hashSecret(k.Salt, secret), k.Hash) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{
+		Subject:       "apikey:" + k.ID,
+		Organisations: []string{k.OrganisationID},
+		Scopes:        k.Scopes,
+	}, nil
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	k, token, err := NewAPIKey("org1", "ci", []string{ScopeRead}, time.Now())
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, apiKeyPrefix+k.ID+"_"))
+	assert.NotContains(t, string(k.Hash), token, "the token should not be stored")
+
+	id, secret, ok := parseToken(token)
+	assert.True(t, ok)
+	assert.Equal(t, k.ID, id)
+	assert.Equal(t, k.Hash, hashSecret(k.Salt, secret))
+
+	other, _, err := NewAPIKey("org1", "ci", []string{ScopeRead}, time.Now())
+	assert.Nil(t, err)
+	assert.NotEqual(t, k.ID, other.ID)
+	assert.NotEqual(t, k.Salt, other.Salt, "every key should have its own salt")
+
+	_, _, err = NewAPIKey("org1", "ci", []string{"delete"}, time.Now())
+	assert.Equal(t, ErrInvalidScope, err)
+
+	for _, token := range []string{"", "pk_", "pk_id", "pk__c2VjcmV0", "pk_id_!", "sk_id_c2VjcmV0"} {
+		_, _, ok := parseToken(token)
+		assert.False(t, ok, token)
+	}
+}
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	keys := repository.NewMemory()
+	ctx := repository.WithSystemScope(context.Background())
+	k, token, err := NewAPIKey("org1", "ci", []string{ScopeRead, ScopeWrite}, time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, keys.CreateKey(ctx, k))
+	a := APIKeyAuthenticator{Keys: keys}
+
+	authenticate := func(token string) (*Principal, error) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if token != "" {
+			r.Header.Set(APIKeyHeader, token)
+		}
+		return a.Authenticate(r)
+	}
+
+	p, err := authenticate(token)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"org1"}, p.Organisations)
+	assert.Equal(t, []string{ScopeRead, ScopeWrite}, p.Scopes)
+
+	for _, invalid := range []string{"", token + "x", apiKeyPrefix + "unknown_c2VjcmV0"} {
+		_, err = authenticate(invalid)
+		assert.Equal(t, ErrUnauthenticated, err, invalid)
+	}
+
+	//the previous token stops working when the key is rotated
+	rotated, err := RotateAPIKey(k, time.Now())
+	assert.Nil(t, err)
+	assert.NotNil(t, k.RotatedAt)
+	assert.Nil(t, keys.UpdateKey(ctx, k))
+	_, err = authenticate(token)
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = authenticate(rotated)
+	assert.Nil(t, err)
+
+	now := time.Now()
+	k.RevokedAt = &now
+	assert.Nil(t, keys.UpdateKey(ctx, k))
+	_, err = authenticate(rotated)
+	assert.Equal(t, ErrUnauthenticated, err, "a revoked key should not authenticate")
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//ErrUnauthenticated is returned by an Authenticator when the request has no valid credentials
+var ErrUnauthenticated = errors.New("the request is not authenticated")
+
+//Roles of a principal, in its scopes. Each route requires one of them.
+const (
+	//RoleViewer reads the payments
+	RoleViewer = "viewer"
+	//RoleMaker reads, creates, updates, deletes and changes the status of the payments
+	RoleMaker = "maker"
+	//RoleApprover reads the payments, and approves or rejects the drafts of the makers
+	RoleApprover = "approver"
+	//RoleAdmin manages the api keys of the organisation, it does not access the payments
+	RoleAdmin = "admin"
+)
+
+//Scopes of the api keys created before the roles
+const (
+	//ScopeRead grants RoleViewer
+	ScopeRead = "read"
+	//ScopeWrite grants RoleMaker
+	ScopeWrite = "write"
+)
+
+//legacyScopes contains the role granted by each scope of before the roles
+var legacyScopes = map[string]string{ScopeRead: RoleViewer, ScopeWrite: RoleMaker}
+
+//ValidScope returns true if the scope is a role, or one of the scopes of before the roles
+func ValidScope(scope string) bool {
+	switch scope {
+	case RoleViewer, RoleMaker, RoleApprover, RoleAdmin:
+		return true
+	}
+	_, ok := legacyScopes[scope]
+	return ok
+}
+
+//Principal is the caller of a request, the organisations it acts for and what it can do
+type Principal struct {
+	//Subject identifies the caller, it may be empty when the authenticator does not know it.
+	//A caller without subject can not approve the payments.
+	Subject string
+	//Organisations are the ids of the organisations whose payments the caller can access, at least one
+	Organisations []string
+	//Scopes are the roles of the caller
+	Scopes []string
+}
+
+//HasRole returns true if one of the scopes of the principal grants the role
+func (p *Principal) HasRole(role string) bool {
+	for _, s := range p.Scopes {
+		if s == role || legacyScopes[s] == role {
+			return true
+		}
+	}
+	return false
+}
+
+//Member returns true if the principal acts for the organisation
+func (p *Principal) Member(organisationID string) bool {
+	for _, o := range p.Organisations {
+		if o == organisationID {
+			return true
+		}
+	}
+	return false
+}
+
+//Authenticator returns the principal of a request
+//ErrUnauthenticated if the request has no valid credentials
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+//Authenticators tries each authenticator in order and returns the first principal, e.g. an api key or a JWT.
+//It returns the error of an authenticator that fails for another reason than ErrUnauthenticated.
+type Authenticators []Authenticator
+
+//Authenticate returns the principal of the first authenticator that accepts the request
+//ErrUnauthenticated if none of them does
+func (as Authenticators) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range as {
+		p, err := a.Authenticate(r)
+		if err != ErrUnauthenticated {
+			return p, err
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+//Headers of the HeaderAuthenticator
+const (
+	//OrganisationHeader contains the ids of the organisations of the caller separated by commas
+	OrganisationHeader = "X-Organisation-ID"
+	//SubjectHeader contains the id of the caller
+	SubjectHeader = "X-Subject-ID"
+	//RolesHeader contains the roles of the caller separated by commas
+	RolesHeader = "X-Roles"
+)
+
+//HeaderAuthenticator trusts the organisations, the subject and the roles of the headers.
+//Without the RolesHeader the caller is a viewer and a maker.
+//It is meant for the deployments behind a gateway that authenticates the callers and sets the headers,
+//the app must not be reachable without the gateway.
+type HeaderAuthenticator struct{}
+
+//Authenticate returns the principal of the headers
+//ErrUnauthenticated if the OrganisationHeader is missing, or a header has an empty organisation or an unknown role
+func (HeaderAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get(OrganisationHeader)
+	if header == "" {
+		return nil, ErrUnauthenticated
+	}
+	p := &Principal{Subject: r.Header.Get(SubjectHeader), Scopes: []string{RoleViewer, RoleMaker}}
+	for _, o := range strings.Split(header, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			return nil, ErrUnauthenticated
+		}
+		p.Organisations = append(p.Organisations, o)
+	}
+	if roles := r.Header.Get(RolesHeader); roles != "" {
+		p.Scopes = nil
+		for _, role := range strings.Split(roles, ",") {
+			role = strings.TrimSpace(role)
+			if !ValidScope(role) {
+				return nil, ErrUnauthenticated
+			}
+			p.Scopes = append(p.Scopes, role)
+		}
+	}
+	return p, nil
+}
+
+type principalKey struct{}
+
+//WithPrincipal returns a copy of ctx with the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+//FromContext returns the principal of the context, nil if the request was not authenticated
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
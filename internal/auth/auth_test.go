@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestHeaderAuthenticator(t *testing.T) {
+	tests := []struct {
+		header string
+		orgs   []string
+		err    error
+	}{
+		{"org1", []string{"org1"}, nil},
+		{"org1, org2", []string{"org1", "org2"}, nil},
+		{"", nil, ErrUnauthenticated},
+		{"org1,,org2", nil, ErrUnauthenticated},
+		{" ", nil, ErrUnauthenticated},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set(OrganisationHeader, tt.header)
+		}
+		p, err := HeaderAuthenticator{}.Authenticate(r)
+		assert.Equal(t, tt.err, err, tt.header)
+		if tt.err == nil {
+			assert.Equal(t, tt.orgs, p.Organisations, tt.header)
+		}
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	assert.Nil(t, FromContext(context.Background()))
+
+	p := &Principal{Organisations: []string{"org1", "org2"}}
+	ctx := WithPrincipal(context.Background(), p)
+	assert.Equal(t, p, FromContext(ctx))
+	assert.True(t, p.Member("org2"))
+	assert.False(t, p.Member("org3"))
+}
+
+func TestHeaderAuthenticator_Roles(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set(OrganisationHeader, "org1")
+	p, err := HeaderAuthenticator{}.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Organisations: []string{"org1"}, Scopes: []string{RoleViewer, RoleMaker}}, p)
+
+	r.Header.Set(SubjectHeader, "bob")
+	r.Header.Set(RolesHeader, "approver, viewer")
+	p, err = HeaderAuthenticator{}.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, &Principal{Subject: "bob", Organisations: []string{"org1"}, Scopes: []string{RoleApprover, RoleViewer}}, p)
+
+	r.Header.Set(RolesHeader, "approver,owner")
+	_, err = HeaderAuthenticator{}.Authenticate(r)
+	assert.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestPrincipal_HasRole(t *testing.T) {
+	p := &Principal{Scopes: []string{RoleApprover, ScopeWrite}}
+	assert.True(t, p.HasRole(RoleApprover))
+	assert.True(t, p.HasRole(RoleMaker), "the write scope should grant the maker role")
+	assert.False(t, p.HasRole(RoleViewer))
+	assert.False(t, p.HasRole(RoleAdmin))
+
+	assert.True(t, (&Principal{Scopes: []string{ScopeRead}}).HasRole(RoleViewer))
+	assert.False(t, (&Principal{}).HasRole(RoleViewer))
+}
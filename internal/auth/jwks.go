@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrUnknownKey is returned by JWKS.Key when the set has no key with the id, even after a refresh
+var ErrUnknownKey = errors.New("the key is not in the key set")
+
+//minRefresh is the minimum time between two refreshes of a JWKS caused by an unknown key id, or after a failed one,
+//so the tokens with random key ids or an unavailable issuer can not make the app fetch the set on every request
+const minRefresh = 30 * time.Second
+
+//JWKS is a JSON Web Key Set (RFC 7517) loaded from a file or a URL and cached.
+//The set is loaded again when its cache expires, or when a token has a key id that is not in it,
+//so the keys rotated by the issuer are found without restarting the app.
+//The cached keys are used while the set can not be loaded.
+type JWKS struct {
+	//Source is the path of the file or the http(s) URL of the set
+	Source string
+	//TTL is how long the set is cached
+	TTL    time.Duration
+	Client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	//fetched is the time of the last load, attempted of the last try and err its error
+	fetched   time.Time
+	attempted time.Time
+	err       error
+	//loading is closed when the running load finishes, nil if there is none
+	loading chan struct{}
+	now     func() time.Time
+}
+
+//NewJWKS returns the key set of the source, loaded on the first use
+func NewJWKS(source string, ttl time.Duration) *JWKS {
+	return &JWKS{Source: source, TTL: ttl, Client: &http.Client{Timeout: 10 * time.Second}, now: time.Now}
+}
+
+//Key returns the *rsa.PublicKey or *ecdsa.PublicKey with the id
+//ErrUnknownKey if the set has no key with the id
+func (s *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	keys, err := s.keys, s.err
+	stale := keys == nil || now.Sub(s.fetched) > s.TTL
+	//a failed load is not tried again before minRefresh
+	retry := err == nil || now.Sub(s.attempted) >= minRefresh
+	s.mu.Unlock()
+
+	if stale && retry {
+		keys, err = s.refresh(ctx)
+		if err != nil && keys != nil {
+			logrus.WithError(err).Warn("using the cached jwks")
+		}
+	}
+	if keys == nil {
+		return nil, err
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	s.mu.Lock()
+	retry = s.now().Sub(s.attempted) >= minRefresh
+	s.mu.Unlock()
+	if !retry {
+		return nil, ErrUnknownKey
+	}
+	//the issuer may have rotated its keys since the set was loaded
+	if keys, err = s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+//refresh loads the set from its source without holding the lock, one load at a time:
+//the callers that arrive during a load wait for it. Returns the cached keys and the error of the load,
+//the keys are kept if it fails.
+func (s *JWKS) refresh(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	s.mu.Lock()
+	loading := s.loading
+	if loading == nil {
+		loading = make(chan struct{})
+		s.loading = loading
+		//the load is not cancelled with the request that started it, the others wait for it
+		go s.load(context.WithoutCancel(ctx), loading)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-loading:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys, s.err
+}
+
+//load reads and parses the set, then records the result and closes loading
+func (s *JWKS) load(ctx context.Context, loading chan struct{}) {
+	keys, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.attempted, s.err, s.loading = now, err, nil
+	if err == nil {
+		s.keys, s.fetched = keys, now
+	}
+	close(loading)
+}
+
+//fetch reads the set from its source and parses it
+func (s *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	body, err := s.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading the jwks %s: %v", s.Source, err)
+	}
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the jwks %s: %v", s.Source, err)
+	}
+	return keys, nil
+}
+
+func (s *JWKS) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		return os.ReadFile(s.Source)
+	}
+	req, err := http.NewRequest("GET", s.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+//jwk is a key of a set, only the members of the RSA and the P-256 EC keys are read
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//parseJWKS returns the signature keys of the set by id. The keys of the other types are ignored.
+func parseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch {
+		case k.Kty == "RSA":
+			key, err = rsaKey(k)
+		case k.Kty == "EC" && k.Crv == "P-256":
+			key, err = ecKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64URLInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64URLInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("the exponent is not valid")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func ecKey(k jwk) (*ecdsa.PublicKey, error) {
+	x, err := base64URLInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64URLInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if x.BitLen() > 256 || y.BitLen() > 256 {
+		return nil, errors.New("the coordinates are not of P-256")
+	}
+	point := make([]byte, 65)
+	point[0] = 4
+	x.FillBytes(point[1:33])
+	y.FillBytes(point[33:])
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, errors.New("the point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+}
+
+func base64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
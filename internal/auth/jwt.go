@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//KeySet returns the public keys that sign the tokens, it is implemented by JWKS
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+//default claims of JWTAuthenticator
+const (
+	DefaultOrganisationsClaim = "org_ids"
+	DefaultScopesClaim        = "scope"
+)
+
+//JWTAuthenticator authenticates the requests with a RS256 or ES256 JSON Web Token (RFC 7519)
+//in the Authorization header with the Bearer scheme, signed by a key of the KeySet.
+type JWTAuthenticator struct {
+	Keys KeySet
+	//Issuer and Audience must match the iss and the aud claims of the tokens
+	Issuer   string
+	Audience string
+	//OrganisationsClaim is the claim with the organisation ids, a string or a list of strings. Default org_ids.
+	OrganisationsClaim string
+	//ScopesClaim is the claim with the scopes, separated by spaces or a list of strings. Default scope.
+	ScopesClaim string
+	//Leeway is the clock skew allowed when checking exp and nbf
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+//jwtHeader is the JOSE header of a token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+//Authenticate returns the principal of the subject, the organisations and the scopes of the token
+//ErrUnauthenticated if the token is missing, not valid, expired or not issued for the audience
+func (a JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthenticated
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	//only the asymmetric algorithms are accepted, never none or a HMAC with the public key as secret
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return nil, ErrUnauthenticated
+	}
+	key, err := a.Keys.Key(r.Context(), header.Kid)
+	if err == ErrUnknownKey {
+		return nil, ErrUnauthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return nil, ErrUnauthenticated
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrUnauthenticated
+	}
+	if !a.validClaims(claims) {
+		return nil, ErrUnauthenticated
+	}
+	organisations := stringsClaim(claims[a.organisationsClaim()], ",")
+	if len(organisations) == 0 {
+		return nil, ErrUnauthenticated
+	}
+	subject, _ := claims["sub"].(string)
+	return &Principal{
+		Subject:       subject,
+		Organisations: organisations,
+		Scopes:        stringsClaim(claims[a.scopesClaim()], " "),
+	}, nil
+}
+
+//validClaims checks the issuer, the audience, the expiry and the not before time of the token
+func (a JWTAuthenticator) validClaims(claims map[string]interface{}) bool {
+	now := time.Now()
+	if a.now != nil {
+		now = a.now()
+	}
+	if iss, _ := claims["iss"].(string); iss != a.Issuer {
+		return false
+	}
+	audience := false
+	for _, aud := range stringsClaim(claims["aud"], "") {
+		audience = audience || aud == a.Audience
+	}
+	if !audience {
+		return false
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return false
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return false
+	}
+	return true
+}
+
+func (a JWTAuthenticator) organisationsClaim() string {
+	if a.OrganisationsClaim == "" {
+		return DefaultOrganisationsClaim
+	}
+	return a.OrganisationsClaim
+}
+
+func (a JWTAuthenticator) scopesClaim() string {
+	if a.ScopesClaim == "" {
+		return DefaultScopesClaim
+	}
+	return a.ScopesClaim
+}
+
+//bearerToken returns the token of the Authorization header with the Bearer scheme
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//verifySignature checks the signature of the signing input with a key of the type of the algorithm
+func verifySignature(alg string, key crypto.PublicKey, input string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(input))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		//the signature is r and s as 32 bytes big endian each (RFC 7518 section 3.4)
+		if alg != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+//stringsClaim returns the strings of a claim that is a list of strings, or a string split by sep when sep is not empty
+func stringsClaim(claim interface{}, sep string) []string {
+	var values []string
+	switch c := claim.(type) {
+	case string:
+		if sep == "" {
+			values = []string{c}
+			break
+		}
+		values = strings.Split(c, sep)
+	case []interface{}:
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var nonEmpty []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			nonEmpty = append(nonEmpty, v)
+		}
+	}
+	return nonEmpty
+}
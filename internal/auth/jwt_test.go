@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	rsaPrivate, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecPrivate, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+//staticKeys is a KeySet that does not change
+type staticKeys map[string]crypto.PublicKey
+
+func (k staticKeys) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := k[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func segment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//signToken returns a token of the claims signed with the private key
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	input := segment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.Nil(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":     "https://issuer.test",
+		"aud":     []string{"payments", "other"},
+		"sub":     "alice",
+		"exp":     time.Now().Add(time.Minute).Unix(),
+		"org_ids": []string{"org1", "org2"},
+		"scope":   "read write",
+	}
+}
+
+func bearer(token string) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	a := JWTAuthenticator{
+		Keys:     staticKeys{"rsa": &rsaPrivate.PublicKey, "ec": &ecPrivate.PublicKey},
+		Issuer:   "https://issuer.test",
+		Audience: "payments",
+	}
+
+	for _, token := range []string{signToken(t, "RS256", "rsa", rsaPrivate, validClaims()), signToken(t, "ES256", "ec", ecPrivate, validClaims())} {
+		p, err := a.Authenticate(bearer(token))
+		assert.Nil(t, err)
+		assert.Equal(t, &Principal{Subject: "alice", Organisations: []string{"org1", "org2"}, Scopes: []string{ScopeRead, ScopeWrite}}, p)
+	}
+
+	claims := validClaims()
+	claims["tenants"], claims["scp"] = "org3", []string{"read"}
+	custom := a
+	custom.OrganisationsClaim, custom.ScopesClaim = "tenants", "scp"
+	p, err := custom.Authenticate(bearer(signToken(t, "RS256", "rsa", rsaPrivate, claims)))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"org3"}, p.Organisations)
+	assert.Equal(t, []string{ScopeRead}, p.Scopes)
+
+	invalid := map[string]func(map[string]interface{}){
+		"issuer":        func(c map[string]interface{}) { c["iss"] = "https://other.test" },
+		"audience":      func(c map[string]interface{}) { c["aud"] = "other" },
+		"expired":       func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":     func(c map[string]interface{}) { delete(c, "exp") },
+		"not before":    func(c map[string]interface{}) { c["nbf"] = time.Now().Add(time.Minute).Unix() },
+		"organisations": func(c map[string]interface{}) { delete(c, "org_ids") },
+	}
+	for name, change := range invalid {
+		claims := validClaims()
+		change(claims)
+		_, err := a.Authenticate(bearer(signToken(t, "RS256", "rsa", rsaPrivate, claims)))
+		assert.Equal(t, ErrUnauthenticated, err, name)
+	}
+
+	token := signToken(t, "RS256", "rsa", rsaPrivate, validClaims())
+	parts := strings.Split(token, ".")
+	tampered := validClaims()
+	tampered["org_ids"] = []string{"org3"}
+	none := segment(map[string]string{"alg": "none", "kid": "rsa"}) + "." + parts[1] + "."
+	for name, token := range map[string]string{
+		"missing":         "",
+		"malformed":       "abc",
+		"tampered":        parts[0] + "." + segment(tampered) + "." + parts[2],
+		"none":            none,
+		"unknown key":     signToken(t, "RS256", "other", rsaPrivate, validClaims()),
+		"wrong algorithm": signToken(t, "ES256", "rsa", ecPrivate, validClaims()),
+		"wrong key":       signToken(t, "ES256", "ec", mustECKey(t), validClaims()),
+	} {
+		_, err := a.Authenticate(bearer(token))
+		assert.Equal(t, ErrUnauthenticated, err, name)
+	}
+
+	//the expiry accepts the leeway
+	claims = validClaims()
+	claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+	withLeeway := a
+	withLeeway.Leeway = time.Minute
+	_, err = withLeeway.Authenticate(bearer(signToken(t, "RS256", "rsa", rsaPrivate, claims)))
+	assert.Nil(t, err)
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	return k
+}
+
+//jwksJSON returns the set of the public keys by id
+func jwksJSON(keys map[string]crypto.PublicKey) []byte {
+	b64 := func(i *big.Int, size int) string {
+		b := i.Bytes()
+		if size > 0 {
+			b = i.FillBytes(make([]byte, size))
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(k.N, 0), "e": b64(big.NewInt(int64(k.E)), 0)})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X, 32), "y": b64(k.Y, 32)})
+		}
+	}
+	set.Keys = append(set.Keys, map[string]string{"kty": "oct", "kid": "ignored", "k": "c2VjcmV0"})
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func TestJWKS_URL(t *testing.T) {
+	keys := map[string]crypto.PublicKey{"rsa": &rsaPrivate.PublicKey}
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(jwksJSON(keys))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	set := NewJWKS(server.URL, time.Hour)
+	set.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	key, err := set.Key(ctx, "rsa")
+	assert.Nil(t, err)
+	assert.Equal(t, &rsaPrivate.PublicKey, key)
+	_, err = set.Key(ctx, "rsa")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "the set should be cached")
+
+	//the issuer rotates its keys, the new key is found after the minimum time between refreshes
+	keys = map[string]crypto.PublicKey{"ec": &ecPrivate.PublicKey}
+	_, err = set.Key(ctx, "ec")
+	assert.Equal(t, ErrUnknownKey, err)
+	now = now.Add(minRefresh + time.Second)
+	key, err = set.Key(ctx, "ec")
+	assert.Nil(t, err)
+	assert.Equal(t, &ecPrivate.PublicKey, key)
+	_, err = set.Key(ctx, "rsa")
+	assert.Equal(t, ErrUnknownKey, err, "the rotated key should be removed")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKS_RefreshFails(t *testing.T) {
+	var fetches, failing int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwksJSON(map[string]crypto.PublicKey{"rsa": &rsaPrivate.PublicKey}))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	set := NewJWKS(server.URL, time.Minute)
+	set.now = func() time.Time { return now }
+	ctx := context.Background()
+	_, err := set.Key(ctx, "rsa")
+	assert.Nil(t, err)
+
+	//the cached keys are used when the set can not be loaded after the ttl, and the load is not tried again before minRefresh
+	atomic.StoreInt32(&failing, 1)
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 3; i++ {
+		key, err := set.Key(ctx, "rsa")
+		assert.Nil(t, err)
+		assert.Equal(t, &rsaPrivate.PublicKey, key)
+		_, err = set.Key(ctx, "unknown")
+		assert.Equal(t, ErrUnknownKey, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	atomic.StoreInt32(&failing, 0)
+	now = now.Add(minRefresh)
+	_, err = set.Key(ctx, "rsa")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+
+	//without cached keys the error of the load is returned until it is tried again
+	atomic.StoreInt32(&failing, 1)
+	set = NewJWKS(server.URL, time.Minute)
+	set.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		_, err = set.Key(ctx, "rsa")
+		assert.NotNil(t, err)
+		assert.NotEqual(t, ErrUnknownKey, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKS_ConcurrentRefresh(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		w.Write(jwksJSON(map[string]crypto.PublicKey{"rsa": &rsaPrivate.PublicKey}))
+	}))
+	defer server.Close()
+	set := NewJWKS(server.URL, time.Hour)
+
+	//the callers waiting for a load do not hold the lock, a cancelled one returns at once
+	cancelled, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := set.Key(cancelled, "rsa")
+		done <- err
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := set.Key(context.Background(), "rsa")
+			assert.Nil(t, err)
+		}()
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "the set should be loaded once")
+}
+
+func TestJWKS_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(path, jwksJSON(map[string]crypto.PublicKey{"ec": &ecPrivate.PublicKey}), 0600))
+
+	now := time.Now()
+	set := NewJWKS(path, time.Minute)
+	set.now = func() time.Time { return now }
+	a := JWTAuthenticator{Keys: set, Issuer: "https://issuer.test", Audience: "payments"}
+
+	_, err := a.Authenticate(bearer(signToken(t, "ES256", "ec", ecPrivate, validClaims())))
+	assert.Nil(t, err)
+
+	//the file is loaded again when the cache expires
+	assert.Nil(t, os.WriteFile(path, jwksJSON(map[string]crypto.PublicKey{"rsa": &rsaPrivate.PublicKey}), 0600))
+	now = now.Add(2 * time.Minute)
+	_, err = a.Authenticate(bearer(signToken(t, "ES256", "ec", ecPrivate, validClaims())))
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate(bearer(signToken(t, "RS256", "rsa", rsaPrivate, validClaims())))
+	assert.Nil(t, err)
+
+	_, err = NewJWKS(filepath.Join(t.TempDir(), "missing.json"), time.Minute).Key(context.Background(), "ec")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrUnknownKey, err)
+}
+
+func TestAuthenticators(t *testing.T) {
+	keys := staticKeys{"ec": &ecPrivate.PublicKey}
+	as := Authenticators{HeaderAuthenticator{}, JWTAuthenticator{Keys: keys, Issuer: "https://issuer.test", Audience: "payments"}}
+
+	p, err := as.Authenticate(bearer(signToken(t, "ES256", "ec", ecPrivate, validClaims())))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", p.Subject)
+
+	r := bearer("")
+	r.Header.Set(OrganisationHeader, "org1")
+	p, err = as.Authenticate(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"org1"}, p.Organisations)
+
+	_, err = as.Authenticate(bearer("abc"))
+	assert.Equal(t, ErrUnauthenticated, err)
+}
@@ -0,0 +1,48 @@
+package model
+
+import "fmt"
+
+//Account number codes
+const (
+	AccountNumberCodeIBAN = "IBAN"
+	AccountNumberCodeBBAN = "BBAN"
+)
+
+//BankIDCodeUKSortCode is the bank id code of a UK sort code
+const BankIDCodeUKSortCode = "GBDSC"
+
+//AccountError is returned when a field of a party is not valid, Field is its json name
+type AccountError struct {
+	Field string
+	Err   error
+}
+
+func (e *AccountError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+//ValidateAccount checks the account number and the bank id of a party according to their codes.
+//IBANs are checked with ValidateIBAN, GBDSC bank ids with ValidateSortCode and
+//BBANs with a GBDSC bank id with ValidateUKAccount. Other codes are not checked.
+//Returns an *AccountError naming the field that failed.
+func ValidateAccount(accountNumberCode, accountNumber, bankIDCode, bankID string) error {
+	if bankIDCode == BankIDCodeUKSortCode {
+		if err := ValidateSortCode(bankID); err != nil {
+			return &AccountError{Field: "bank_id", Err: err}
+		}
+	}
+
+	switch accountNumberCode {
+	case AccountNumberCodeIBAN:
+		if err := ValidateIBAN(accountNumber); err != nil {
+			return &AccountError{Field: "account_number", Err: err}
+		}
+	case AccountNumberCodeBBAN:
+		if bankIDCode == BankIDCodeUKSortCode {
+			if err := ValidateUKAccount(bankID, accountNumber); err != nil {
+				return &AccountError{Field: "account_number", Err: err}
+			}
+		}
+	}
+	return nil
+}
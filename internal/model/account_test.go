@@ -0,0 +1,134 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidateIBAN(t *testing.T) {
+	for _, iban := range []string{"GB29NWBK60161331926819", "DE89370400440532013000", "NO9386011117947", "MT84MALT011000012345MTLCAST001S"} {
+		assert.Nil(t, ValidateIBAN(iban), iban)
+	}
+	assert.Equal(t, ErrIBANFormat, ValidateIBAN("GB29 NWBK 6016 1331 9268 19"))
+	assert.Equal(t, ErrIBANFormat, ValidateIBAN("gb29nwbk60161331926819"))
+	assert.Equal(t, ErrIBANCountry, ValidateIBAN("US29NWBK60161331926819"))
+	assert.Equal(t, ErrIBANLength, ValidateIBAN("GB29NWBK6016133192681"))
+	assert.Equal(t, ErrIBANChecksum, ValidateIBAN("GB29XABC10161234567801"))
+	assert.Equal(t, ErrIBANChecksum, ValidateIBAN("GB28NWBK60161331926819"))
+}
+
+const testModulusTable = `
+080000 089999 MOD10 0 0 0 0 0 0 7 1 3 7 1 3 7 1
+100000 109999 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1
+200000 209999 DBLAL 2 1 2 1 2 1 2 1 2 1 2 1 2 1
+300000 309999 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 5
+`
+
+func TestValidateUKAccount(t *testing.T) {
+	table, err := ParseModulusTable(strings.NewReader(testModulusTable))
+	assert.Nil(t, err)
+	SetModulusTable(table)
+	defer SetModulusTable(&ModulusTable{})
+
+	assert.Nil(t, ValidateUKAccount("089999", "66374958"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("089999", "66374959"))
+	assert.Nil(t, ValidateUKAccount("107999", "88837491"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("107999", "88837492"))
+	assert.Nil(t, ValidateUKAccount("202959", "63748472"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("202959", "63748473"))
+
+	// sort codes not in the table and the ones with an exception that is not supported are presumed valid
+	assert.Nil(t, ValidateUKAccount("403000", "31926819"))
+	assert.Nil(t, ValidateUKAccount("300000", "12345678"))
+
+	assert.Equal(t, ErrSortCodeFormat, ValidateUKAccount("40-30-00", "31926819"))
+	assert.Equal(t, ErrUKAccountFormat, ValidateUKAccount("403000", "3192681"))
+}
+
+//testExceptionTable has the rows of the VocaLink specification examples with exceptions
+const testExceptionTable = `
+118765 118765 DBLAL 0 0 2 1 2 1 2 1 2 1 2 1 2 1 1
+134012 134020 MOD11 0 0 0 7 5 9 8 4 6 3 5 2 0 0 4
+200915 200915 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 6
+772798 772798 DBLAL 2 1 2 1 2 1 2 1 2 1 2 1 2 1 7
+820000 826097 MOD11 0 0 0 0 0 0 0 0 7 3 4 9 2 1
+820000 826097 DBLAL 0 0 0 0 0 0 0 0 2 1 2 1 2 1 3
+827101 828999 MOD11 0 0 0 0 0 0 0 0 7 3 4 9 2 1
+827101 828999 DBLAL 0 0 0 0 0 0 0 0 2 1 2 1 2 1 3
+938600 938699 MOD11 0 0 0 0 0 0 0 0 0 0 0 0 0 0 5
+`
+
+func TestValidateUKAccount_Exceptions(t *testing.T) {
+	table, err := ParseModulusTable(strings.NewReader(testExceptionTable))
+	assert.Nil(t, err)
+	SetModulusTable(table)
+	defer SetModulusTable(&ModulusTable{})
+
+	//the examples of the specification
+	assert.Nil(t, ValidateUKAccount("118765", "64371389"), "exception 1")
+	assert.Nil(t, ValidateUKAccount("134020", "63849203"), "exception 4")
+	assert.Nil(t, ValidateUKAccount("200915", "41011166"), "exception 6")
+	assert.Nil(t, ValidateUKAccount("772798", "99345694"), "exception 7")
+	assert.Nil(t, ValidateUKAccount("820000", "73688637"), "exception 3 with c = 6")
+	assert.Nil(t, ValidateUKAccount("827999", "73988638"), "exception 3 with c = 9")
+	assert.Nil(t, ValidateUKAccount("938611", "07806039"), "exception 5 is presumed valid")
+
+	//the exceptions do not let the wrong numbers pass
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("118765", "64371388"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("134020", "63849204"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("200915", "31011166"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("772798", "99345695"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("820000", "73688638"))
+}
+
+const testPairTable = `
+080000 089999 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 12
+080000 089999 MOD10 0 0 0 0 0 0 7 1 3 7 1 3 7 1 13
+100000 109999 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 10
+100000 109999 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 11
+180000 180099 MOD11 0 0 0 0 0 0 8 7 6 5 4 3 2 1 14
+309070 309072 MOD11 0 0 1 2 5 3 6 4 8 7 10 9 3 1 2
+309070 309072 MOD11 0 0 1 2 5 3 6 4 8 7 10 9 3 1 9
+`
+
+func TestValidateUKAccount_Pairs(t *testing.T) {
+	table, err := ParseModulusTable(strings.NewReader(testPairTable))
+	assert.Nil(t, err)
+	SetModulusTable(table)
+	defer SetModulusTable(&ModulusTable{})
+
+	//the pairs 12 and 13, 10 and 11, 2 and 9 pass when either check passes
+	assert.Nil(t, ValidateUKAccount("089999", "66374958"), "the second check passes")
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("089999", "66374959"))
+	assert.Nil(t, ValidateUKAccount("107999", "88837491"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("107999", "88837492"))
+	assert.Nil(t, ValidateUKAccount("107999", "09123498"), "exception 10 does not check the sort code and a b when a b is 09 and g is 9")
+	assert.Nil(t, ValidateUKAccount("309070", "12345677"), "exception 2 substitutes the weights")
+	assert.Nil(t, ValidateUKAccount("309070", "12345666"), "exception 9 substitutes the sort code")
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("309070", "12345668"))
+
+	//exception 14 checks again without h when it is 0, 1 or 9
+	assert.Nil(t, ValidateUKAccount("180002", "12345609"))
+	assert.Equal(t, ErrModulusCheck, ValidateUKAccount("180002", "12345605"))
+}
+
+func TestParseModulusTable(t *testing.T) {
+	_, err := ParseModulusTable(strings.NewReader("080000 089999 MOD10 0 0 0"))
+	assert.NotNil(t, err)
+	_, err = ParseModulusTable(strings.NewReader("080000 089999 MOD12 0 0 0 0 0 0 7 1 3 7 1 3 7 1"))
+	assert.NotNil(t, err)
+}
+
+func TestValidateAccount(t *testing.T) {
+	assert.Nil(t, ValidateAccount("IBAN", "GB29NWBK60161331926819", "GBDSC", "203301"))
+	assert.Nil(t, ValidateAccount("BBAN", "31926819", "GBDSC", "403000"))
+	assert.Nil(t, ValidateAccount("BBAN", "1234", "OTHER", "1"), "other bank id codes are not checked")
+
+	assert.Equal(t, &AccountError{Field: "account_number", Err: ErrIBANChecksum},
+		ValidateAccount("IBAN", "GB29XABC10161234567801", "GBDSC", "203301"))
+	assert.Equal(t, &AccountError{Field: "account_number", Err: ErrUKAccountFormat},
+		ValidateAccount("BBAN", "3192681", "GBDSC", "403000"))
+	assert.Equal(t, &AccountError{Field: "bank_id", Err: ErrSortCodeFormat},
+		ValidateAccount("", "56781234", "GBDSC", "12312"))
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+//ErrAmountMissing is returned when an amount is not set
+var ErrAmountMissing = errors.New("amount is required")
+
+//ErrAmountNegative is returned when an amount is below zero
+var ErrAmountNegative = errors.New("amount can not be negative")
+
+//ErrRateNotPositive is returned when an exchange rate is zero or below
+var ErrRateNotPositive = errors.New("exchange rate must be greater than zero")
+
+//ScaleError is returned when an amount has more decimal places than its currency allows
+type ScaleError struct {
+	Amount     Decimal
+	Currency   string
+	MinorUnits int
+}
+
+func (e *ScaleError) Error() string {
+	return fmt.Sprintf("amount %s has more than %d decimal places for currency %s", e.Amount, e.MinorUnits, e.Currency)
+}
+
+//ValidateAmount returns an error if the amount is malformed, not set, is negative
+//or has more decimal places than the minor units of the currency.
+//The currency itself is checked by ValidateCurrency.
+func ValidateAmount(amount Decimal, currency string) error {
+	if err := amount.Err(); err != nil {
+		return err
+	}
+	if !amount.IsSet() {
+		return ErrAmountMissing
+	}
+	if amount.Sign() < 0 {
+		return ErrAmountNegative
+	}
+	if units, ok := MinorUnits(currency); ok && amount.Scale() > units {
+		return &ScaleError{Amount: amount, Currency: currency, MinorUnits: units}
+	}
+	return nil
+}
+
+//FormatAmount returns the amount with the minor units of the currency, e.g. "5" in GBP is "5.00".
+//Amounts with more decimal places or in an unknown currency are returned as they are.
+func FormatAmount(amount Decimal, currency string) Decimal {
+	units, ok := MinorUnits(currency)
+	if !ok || !amount.IsSet() || amount.Scale() >= units {
+		return amount
+	}
+	formatted, err := amount.Rescale(units)
+	if err != nil {
+		return amount
+	}
+	return formatted
+}
+
+//FormatAmounts formats every amount of the payment with the minor units of its currency
+func FormatAmounts(p *Payment) {
+	a := &p.Attributes
+	a.Amount = FormatAmount(a.Amount, a.Currency)
+	a.Fx.OriginalAmount = FormatAmount(a.Fx.OriginalAmount, a.Fx.OriginalCurrency)
+	charges := &a.ChargesInformation
+	charges.ReceiverChargesAmount = FormatAmount(charges.ReceiverChargesAmount, charges.ReceiverChargesCurrency)
+	for i := range charges.SenderCharges {
+		charges.SenderCharges[i].Amount = FormatAmount(charges.SenderCharges[i].Amount, charges.SenderCharges[i].Currency)
+	}
+}
+
+//ValidateRate returns an error if the exchange rate is malformed, not set or is not greater than zero
+func ValidateRate(rate Decimal) error {
+	if err := rate.Err(); err != nil {
+		return err
+	}
+	if !rate.IsSet() {
+		return ErrAmountMissing
+	}
+	if rate.Sign() <= 0 {
+		return ErrRateNotPositive
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+//APIKey is a key of an organisation to call the API.
+//The secret of the key is never stored, only its hash salted with Salt.
+type APIKey struct {
+	tableName struct{} `sql:"api_keys"`
+
+	ID             string    `json:"id"`
+	OrganisationID string    `json:"organisation_id" sql:",notnull"`
+	Name           string    `json:"name" sql:",notnull"`
+	Scopes         []string  `json:"scopes" sql:",array,notnull"`
+	Salt           []byte    `json:"-" sql:",notnull"`
+	Hash           []byte    `json:"-" sql:",notnull"`
+	CreatedAt      time.Time `json:"created_at" sql:",notnull"`
+	//RotatedAt is when the secret was last replaced
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	//RevokedAt is when the key was revoked, a revoked key does not authenticate any request
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+//Revoked returns true if the key was revoked
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
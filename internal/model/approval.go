@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrSelfApproval is returned when a maker of a payment, or a caller that is not identified, approves or rejects it,
+//or settles or rejects it once it is submitted
+var ErrSelfApproval = errors.New("the payment can not be approved, rejected or settled by one of its makers")
+
+//ErrApprovalRequired is returned when a draft that was not approved is submitted
+var ErrApprovalRequired = errors.New("the payment must be approved before it is submitted")
+
+//ErrNotPendingApproval is returned when a payment that is not a draft is approved or rejected
+var ErrNotPendingApproval = errors.New("only a draft payment can be approved or rejected")
+
+//Decision is the outcome of the review of a payment by an approver
+type Decision string
+
+//Decisions of an approver
+const (
+	DecisionApproved Decision = "approved"
+	DecisionRejected Decision = "rejected"
+)
+
+//Approval records who approved or rejected a payment and when
+type Approval struct {
+	Decision Decision  `json:"decision"`
+	By       string    `json:"by"`
+	At       time.Time `json:"at"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+//MadeBy returns true if by created the payment or changed it
+func (p *Payment) MadeBy(by string) bool {
+	if by == p.CreatedBy {
+		return true
+	}
+	for _, maker := range p.UpdatedBy {
+		if by == maker {
+			return true
+		}
+	}
+	return false
+}
+
+//UpdateBy records that by changed the payment, once per maker
+func (p *Payment) UpdateBy(by string) {
+	if by != "" && !p.MadeBy(by) {
+		p.UpdatedBy = append(p.UpdatedBy, by)
+	}
+}
+
+//Approved returns true if the last decision on the payment is an approval
+func (p *Payment) Approved() bool {
+	return len(p.Approvals) > 0 && p.Approvals[len(p.Approvals)-1].Decision == DecisionApproved
+}
+
+//Approve records the approval of the draft by the approver and submits it.
+//ErrNotPendingApproval if the payment is not a draft, ErrSelfApproval if by is empty or a maker of the payment.
+func (p *Payment) Approve(by string, at time.Time) error {
+	if err := p.review(by); err != nil {
+		return err
+	}
+	p.Approvals = append(p.Approvals, Approval{Decision: DecisionApproved, By: by, At: at})
+	return p.Transition(StatusSubmitted, at)
+}
+
+//Reject records the rejection of the draft by the approver. The payment stays a draft, so its maker can change it.
+//ErrNotPendingApproval if the payment is not a draft, ErrSelfApproval if by is empty or a maker of the payment.
+func (p *Payment) Reject(by, reason string, at time.Time) error {
+	if err := p.review(by); err != nil {
+		return err
+	}
+	p.Approvals = append(p.Approvals, Approval{Decision: DecisionRejected, By: by, At: at, Reason: reason})
+	return nil
+}
+
+//review checks that the approver can decide on the payment
+func (p *Payment) review(by string) error {
+	if p.Status != StatusDraft {
+		return ErrNotPendingApproval
+	}
+	if by == "" || p.MadeBy(by) {
+		return ErrSelfApproval
+	}
+	return nil
+}
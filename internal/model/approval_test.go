@@ -0,0 +1,66 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPayment_Approve(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{CreatedBy: "alice"}
+	assert.Nil(t, p.Transition(StatusDraft, at))
+
+	//a draft is only submitted by its approval
+	assert.Equal(t, ErrApprovalRequired, p.Transition(StatusSubmitted, at))
+	assert.Equal(t, ErrSelfApproval, p.Approve("alice", at))
+	assert.Equal(t, ErrSelfApproval, p.Approve("", at))
+
+	assert.Nil(t, p.Reject("bob", "wrong beneficiary", at.Add(time.Minute)))
+	assert.Equal(t, StatusDraft, p.Status)
+	assert.False(t, p.Approved())
+	assert.Equal(t, ErrApprovalRequired, p.Transition(StatusSubmitted, at))
+
+	assert.Nil(t, p.Approve("carol", at.Add(time.Hour)))
+	assert.Equal(t, StatusSubmitted, p.Status)
+	assert.True(t, p.Approved())
+	assert.Equal(t, []Approval{
+		{Decision: DecisionRejected, By: "bob", At: at.Add(time.Minute), Reason: "wrong beneficiary"},
+		{Decision: DecisionApproved, By: "carol", At: at.Add(time.Hour)},
+	}, p.Approvals)
+	assert.Equal(t, StatusTransition{From: StatusDraft, To: StatusSubmitted, At: at.Add(time.Hour)}, p.StatusHistory[1])
+
+	assert.Equal(t, ErrNotPendingApproval, p.Approve("carol", at))
+	assert.Equal(t, ErrNotPendingApproval, p.Reject("carol", "", at))
+	assert.Equal(t, 2, len(p.Approvals), "refused decisions should not be recorded")
+}
+
+func TestPayment_UpdateBy(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{CreatedBy: "alice"}
+	assert.Nil(t, p.Transition(StatusDraft, at))
+
+	p.UpdateBy("alice")
+	p.UpdateBy("bob")
+	p.UpdateBy("bob")
+	p.UpdateBy("")
+	assert.Equal(t, []string{"bob"}, p.UpdatedBy, "the makers should be recorded once")
+	assert.True(t, p.MadeBy("bob"))
+	assert.False(t, p.MadeBy("carol"))
+
+	//every maker of the payment is refused
+	assert.Equal(t, ErrSelfApproval, p.Approve("bob", at))
+	assert.Equal(t, ErrSelfApproval, p.Reject("bob", "wrong amount", at))
+	assert.Nil(t, p.Approve("carol", at))
+	assert.Equal(t, ErrSelfApproval, p.TransitionBy(StatusSettled, "bob", at))
+}
+
+func TestPayment_CancelDraft(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{CreatedBy: "alice"}
+	assert.Nil(t, p.Transition(StatusDraft, at))
+
+	//the maker can cancel its draft without an approval, it is not released
+	assert.Nil(t, p.Transition(StatusCancelled, at))
+	assert.Equal(t, ErrNotPendingApproval, p.Approve("bob", at))
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"bytes"
+	_ "embed" //for the ISO 4217 table
+	"encoding/csv"
+	"fmt"
+	"strconv"
+)
+
+//iso4217 is the list of active currencies with their minor units.
+//Funds, precious metals and testing codes without minor units are not included.
+//
+//go:embed iso4217.csv
+var iso4217 []byte
+
+//Currency is an ISO 4217 currency
+type Currency struct {
+	Code       string `json:"code"`
+	Number     string `json:"number"`
+	MinorUnits int    `json:"minor_units"`
+	Name       string `json:"name"`
+}
+
+//currencies is the parsed iso4217 table, ordered by code
+var currencies = mustParseCurrencies(iso4217)
+
+var currenciesByCode = func() map[string]Currency {
+	byCode := make(map[string]Currency, len(currencies))
+	for _, c := range currencies {
+		byCode[c.Code] = c
+	}
+	return byCode
+}()
+
+//UnknownCurrencyError is returned when a code is not an ISO 4217 currency
+type UnknownCurrencyError struct {
+	Code string
+}
+
+func (e *UnknownCurrencyError) Error() string {
+	return fmt.Sprintf("%q is not an ISO 4217 currency", e.Code)
+}
+
+//Currencies returns all the ISO 4217 currencies ordered by code
+func Currencies() []Currency {
+	list := make([]Currency, len(currencies))
+	copy(list, currencies)
+	return list
+}
+
+//LookupCurrency returns the currency with the ISO 4217 code and false if it does not exist
+func LookupCurrency(code string) (Currency, bool) {
+	c, ok := currenciesByCode[code]
+	return c, ok
+}
+
+//ValidateCurrency returns an *UnknownCurrencyError if code is not an ISO 4217 currency
+func ValidateCurrency(code string) error {
+	if _, ok := currenciesByCode[code]; !ok {
+		return &UnknownCurrencyError{Code: code}
+	}
+	return nil
+}
+
+//MinorUnits returns the number of decimal places of the currency and false if it does not exist
+func MinorUnits(code string) (int, bool) {
+	c, ok := currenciesByCode[code]
+	return c.MinorUnits, ok
+}
+
+func mustParseCurrencies(table []byte) []Currency {
+	records, err := csv.NewReader(bytes.NewReader(table)).ReadAll()
+	if err != nil {
+		panic(err)
+	}
+	var list []Currency
+	//the first record is the header
+	for _, record := range records[1:] {
+		units, err := strconv.Atoi(record[2])
+		if err != nil {
+			panic(fmt.Sprintf("invalid minor units for currency %s: %v", record[0], err))
+		}
+		list = append(list, Currency{Code: record[0], Number: record[1], MinorUnits: units, Name: record[3]})
+	}
+	return list
+}
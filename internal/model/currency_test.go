@@ -0,0 +1,44 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+func TestCurrencies(t *testing.T) {
+	list := Currencies()
+	assert.True(t, len(list) > 150, "the ISO 4217 table should be embedded")
+	assert.True(t, sort.SliceIsSorted(list, func(i, j int) bool { return list[i].Code < list[j].Code }), "should be ordered by code")
+
+	gbp, ok := LookupCurrency("GBP")
+	assert.True(t, ok)
+	assert.Equal(t, Currency{Code: "GBP", Number: "826", MinorUnits: 2, Name: "Pound Sterling"}, gbp)
+
+	units, ok := MinorUnits("KWD")
+	assert.True(t, ok)
+	assert.Equal(t, 3, units)
+
+	_, ok = MinorUnits("XXX")
+	assert.False(t, ok)
+}
+
+func TestValidateCurrency(t *testing.T) {
+	assert.Nil(t, ValidateCurrency("USD"))
+	assert.Equal(t, &UnknownCurrencyError{Code: "usd"}, ValidateCurrency("usd"))
+	assert.Equal(t, &UnknownCurrencyError{Code: "ABC"}, ValidateCurrency("ABC"))
+}
+
+func TestFormatAmount(t *testing.T) {
+	assert.Equal(t, "5.00", FormatAmount(MustParseDecimal("5"), "GBP").String())
+	assert.Equal(t, "100.20", FormatAmount(MustParseDecimal("100.2"), "EUR").String())
+	assert.Equal(t, "100", FormatAmount(MustParseDecimal("100"), "JPY").String())
+	assert.Equal(t, "1.000", FormatAmount(MustParseDecimal("1"), "BHD").String())
+	assert.Equal(t, "5", FormatAmount(MustParseDecimal("5"), "ABC").String(), "unknown currencies are not formatted")
+
+	p := &Payment{}
+	p.Attributes.Currency = "GBP"
+	p.Attributes.Amount = MustParseDecimal("1.5")
+	FormatAmounts(p)
+	assert.Equal(t, "1.50", p.Attributes.Amount.String())
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//maxDecimalDigits is the number of significant digits that fit in the int64 of a Decimal
+const maxDecimalDigits = 18
+
+var decimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+//DecimalError is returned when a value can not be parsed as a Decimal
+type DecimalError struct {
+	Value  string
+	Reason string
+}
+
+func (e *DecimalError) Error() string {
+	return fmt.Sprintf("%q %s", e.Value, e.Reason)
+}
+
+//Decimal is an exact decimal number kept as an unscaled integer and its number of decimal places.
+//The number of decimal places is kept as sent, so "2.00000" is serialised back as "2.00000".
+//The zero value is a Decimal that was not set.
+type Decimal struct {
+	unscaled int64
+	scale    int
+	set      bool
+	//malformed keeps a json value that is not a decimal so the validation can report it with its field
+	malformed string
+}
+
+//ParseDecimal parses a decimal number like "100.21" or "-5".
+//Exponents, missing digits and more than 18 significant digits are rejected with a *DecimalError.
+func ParseDecimal(s string) (Decimal, error) {
+	if !decimalPattern.MatchString(s) {
+		return Decimal{}, &DecimalError{Value: s, Reason: "is not a decimal number"}
+	}
+	negative := strings.HasPrefix(s, "-")
+	abs := strings.TrimLeft(s, "+-")
+
+	integer, fraction := abs, ""
+	if i := strings.IndexByte(abs, '.'); i >= 0 {
+		integer, fraction = abs[:i], abs[i+1:]
+	}
+	if len(strings.TrimLeft(integer+fraction, "0")) > maxDecimalDigits {
+		return Decimal{}, &DecimalError{Value: s, Reason: fmt.Sprintf("has more than %d significant digits", maxDecimalDigits)}
+	}
+
+	unscaled, err := strconv.ParseInt(integer+fraction, 10, 64)
+	if err != nil {
+		return Decimal{}, &DecimalError{Value: s, Reason: "is not a decimal number"}
+	}
+	if negative {
+		unscaled = -unscaled
+	}
+	return Decimal{unscaled: unscaled, scale: len(fraction), set: true}, nil
+}
+
+//MustParseDecimal is like ParseDecimal but panics if s is not a decimal number
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+//IsSet returns false for the zero value of Decimal and for a malformed one
+func (d Decimal) IsSet() bool {
+	return d.set
+}
+
+//Err returns an error if the json value of the decimal could not be parsed
+func (d Decimal) Err() error {
+	if d.malformed == "" {
+		return nil
+	}
+	_, err := ParseDecimal(d.malformed)
+	return err
+}
+
+//Scale returns the number of decimal places
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+//Sign returns -1, 0 or +1 depending on the sign of d
+func (d Decimal) Sign() int {
+	switch {
+	case d.unscaled < 0:
+		return -1
+	case d.unscaled > 0:
+		return 1
+	}
+	return 0
+}
+
+//Cmp compares the values of d and o, regardless of their number of decimal places.
+//Returns -1 if d < o, 0 if d == o and +1 if d > o.
+func (d Decimal) Cmp(o Decimal) int {
+	a, b := big.NewInt(d.unscaled), big.NewInt(o.unscaled)
+	if d.scale < o.scale {
+		a.Mul(a, pow10(o.scale-d.scale))
+	} else {
+		b.Mul(b, pow10(d.scale-o.scale))
+	}
+	return a.Cmp(b)
+}
+
+//Rescale returns the decimal with more decimal places, e.g. "5" rescaled to 2 is "5.00".
+//Returns an error if places is lower than the current scale or the result does not fit.
+func (d Decimal) Rescale(places int) (Decimal, error) {
+	if places < d.scale {
+		return Decimal{}, fmt.Errorf("%s can not be rescaled to %d decimal places without rounding", d, places)
+	}
+	unscaled := new(big.Int).Mul(big.NewInt(d.unscaled), pow10(places-d.scale))
+	if !unscaled.IsInt64() || len(strings.TrimLeft(unscaled.String(), "-")) > maxDecimalDigits {
+		return Decimal{}, fmt.Errorf("%s has too many digits to be rescaled to %d decimal places", d, places)
+	}
+	return Decimal{unscaled: unscaled.Int64(), scale: places, set: d.set}, nil
+}
+
+//Equal returns true if d and o have the same value and the same number of decimal places.
+//Use Cmp to compare only the values.
+func (d Decimal) Equal(o Decimal) bool {
+	return d == o
+}
+
+//String returns the decimal with its number of decimal places, or "" if it was not set.
+//A malformed decimal returns the value it was decoded from.
+func (d Decimal) String() string {
+	if !d.set {
+		return d.malformed
+	}
+	sign := ""
+	abs := d.unscaled
+	if abs < 0 {
+		sign, abs = "-", -abs
+	}
+	digits := strconv.FormatInt(abs, 10)
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-d.scale] + "." + digits[len(digits)-d.scale:]
+}
+
+//MarshalJSON serialises the decimal as a JSON string to not lose precision
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//UnmarshalJSON accepts a JSON string or number. An empty string or null leaves the decimal not set.
+//A string that is not a decimal does not fail the decoding, it is reported by Err.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	raw := string(data)
+	if raw == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		if raw == "" {
+			*d = Decimal{}
+			return nil
+		}
+	}
+
+	parsed, err := ParseDecimal(raw)
+	if err != nil {
+		*d = Decimal{malformed: raw}
+		return nil
+	}
+	*d = parsed
+	return nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
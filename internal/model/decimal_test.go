@@ -0,0 +1,72 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	for _, s := range []string{"0", "100.21", "2.00000", "-5.00", "0.001", "123456789012345678"} {
+		d, err := ParseDecimal(s)
+		assert.Nil(t, err, s)
+		assert.Equal(t, s, d.String())
+	}
+	d, err := ParseDecimal("+1.50")
+	assert.Nil(t, err)
+	assert.Equal(t, "1.50", d.String())
+	assert.Equal(t, 2, d.Scale())
+
+	for _, s := range []string{"", "abc", "1.", ".5", "1e5", "1,00", " 1", "--1", "1234567890123456789"} {
+		_, err := ParseDecimal(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestDecimal_Cmp(t *testing.T) {
+	assert.Equal(t, 0, MustParseDecimal("1.5").Cmp(MustParseDecimal("1.50")))
+	assert.Equal(t, -1, MustParseDecimal("1.49").Cmp(MustParseDecimal("1.5")))
+	assert.Equal(t, 1, MustParseDecimal("10").Cmp(MustParseDecimal("9.999")))
+	assert.Equal(t, -1, MustParseDecimal("-1").Cmp(MustParseDecimal("0")))
+
+	assert.False(t, MustParseDecimal("1.5").Equal(MustParseDecimal("1.50")), "equal should compare the decimal places")
+	assert.Equal(t, -1, MustParseDecimal("-0.01").Sign())
+	assert.Equal(t, 0, MustParseDecimal("0.00").Sign())
+}
+
+func TestDecimal_JSON(t *testing.T) {
+	var v struct {
+		Amount Decimal `json:"amount"`
+		Rate   Decimal `json:"rate"`
+		Empty  Decimal `json:"empty"`
+	}
+	err := json.Unmarshal([]byte(`{"amount": "100.21", "rate": 2.00000, "empty": ""}`), &v)
+	assert.Nil(t, err)
+	assert.Equal(t, MustParseDecimal("100.21"), v.Amount)
+	assert.Equal(t, MustParseDecimal("2.00000"), v.Rate)
+	assert.False(t, v.Empty.IsSet())
+
+	body, err := json.Marshal(v)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"amount":"100.21","rate":"2.00000","empty":""}`, string(body))
+
+	err = json.Unmarshal([]byte(`{"amount": "abc"}`), &v)
+	assert.Nil(t, err)
+	assert.False(t, v.Amount.IsSet())
+	assert.NotNil(t, v.Amount.Err())
+	assert.Equal(t, "abc", v.Amount.String())
+}
+
+func TestValidateAmount(t *testing.T) {
+	assert.Nil(t, ValidateAmount(MustParseDecimal("100.21"), "GBP"))
+	assert.Nil(t, ValidateAmount(MustParseDecimal("100"), "JPY"))
+	assert.Nil(t, ValidateAmount(MustParseDecimal("1.234"), "KWD"))
+	assert.Equal(t, ErrAmountMissing, ValidateAmount(Decimal{}, "GBP"))
+	assert.NotNil(t, ValidateAmount(Decimal{malformed: "abc"}, "GBP"))
+	assert.Equal(t, ErrAmountNegative, ValidateAmount(MustParseDecimal("-1.00"), "GBP"))
+	assert.IsType(t, &ScaleError{}, ValidateAmount(MustParseDecimal("1.001"), "GBP"))
+	assert.IsType(t, &ScaleError{}, ValidateAmount(MustParseDecimal("1.5"), "JPY"))
+
+	assert.Nil(t, ValidateRate(MustParseDecimal("2.00000")))
+	assert.Equal(t, ErrRateNotPositive, ValidateRate(MustParseDecimal("0")))
+}
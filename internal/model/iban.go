@@ -0,0 +1,66 @@
+package model
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+)
+
+//ibanLengths is the length of the IBAN of each country in the SWIFT IBAN registry
+var ibanLengths = map[string]int{
+	"AD": 24, "AE": 23, "AL": 28, "AT": 20, "AZ": 28, "BA": 20, "BE": 16, "BG": 22, "BH": 22, "BI": 27,
+	"BR": 29, "BY": 28, "CH": 21, "CR": 22, "CY": 28, "CZ": 24, "DE": 22, "DJ": 27, "DK": 18, "DO": 28,
+	"EE": 20, "EG": 29, "ES": 24, "FI": 18, "FK": 18, "FO": 18, "FR": 27, "GB": 22, "GE": 22, "GI": 23,
+	"GL": 18, "GR": 27, "GT": 28, "HN": 28, "HR": 21, "HU": 28, "IE": 22, "IL": 23, "IQ": 23, "IS": 26,
+	"IT": 27, "JO": 30, "KW": 30, "KZ": 20, "LB": 28, "LC": 32, "LI": 21, "LT": 20, "LU": 20, "LV": 21,
+	"LY": 25, "MC": 27, "MD": 24, "ME": 22, "MK": 19, "MN": 20, "MR": 27, "MT": 31, "MU": 30, "NI": 28,
+	"NL": 18, "NO": 15, "OM": 23, "PK": 24, "PL": 28, "PS": 29, "PT": 25, "QA": 29, "RO": 24, "RS": 22,
+	"RU": 33, "SA": 24, "SC": 31, "SD": 18, "SE": 24, "SI": 19, "SK": 24, "SM": 27, "SO": 23, "ST": 25,
+	"SV": 28, "TL": 23, "TN": 24, "TR": 26, "UA": 29, "VA": 22, "VG": 24, "XK": 20,
+}
+
+var ibanPattern = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]+$`)
+
+//ErrIBANFormat is returned when an IBAN is not two letters, two check digits and alphanumeric characters
+var ErrIBANFormat = errors.New("IBAN must be in the electronic format, e.g. GB29NWBK60161331926819")
+
+//ErrIBANCountry is returned when the country of an IBAN is not in the IBAN registry
+var ErrIBANCountry = errors.New("IBAN country does not use IBANs")
+
+//ErrIBANLength is returned when an IBAN does not have the length of its country
+var ErrIBANLength = errors.New("IBAN length is not valid for its country")
+
+//ErrIBANChecksum is returned when the check digits of an IBAN are wrong
+var ErrIBANChecksum = errors.New("IBAN check digits are not valid")
+
+//ValidateIBAN checks the format, the length for the country and the mod-97 check digits of an IBAN
+func ValidateIBAN(iban string) error {
+	if !ibanPattern.MatchString(iban) {
+		return ErrIBANFormat
+	}
+	length, ok := ibanLengths[iban[:2]]
+	if !ok {
+		return ErrIBANCountry
+	}
+	if len(iban) != length {
+		return ErrIBANLength
+	}
+
+	//ISO 13616: move the first 4 characters to the end and replace the letters with 10..35
+	rearranged := iban[4:] + iban[:4]
+	digits := make([]byte, 0, 2*len(rearranged))
+	for i := 0; i < len(rearranged); i++ {
+		c := rearranged[i]
+		if c >= 'A' && c <= 'Z' {
+			n := c - 'A' + 10
+			digits = append(digits, '0'+n/10, '0'+n%10)
+			continue
+		}
+		digits = append(digits, c)
+	}
+	n, _ := new(big.Int).SetString(string(digits), 10)
+	if new(big.Int).Mod(n, big.NewInt(97)).Int64() != 1 {
+		return ErrIBANChecksum
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package model
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Modulus checking algorithms of the VocaLink specification
+const (
+	ModulusMod10 = "MOD10"
+	ModulusMod11 = "MOD11"
+	ModulusDblAl = "DBLAL"
+)
+
+var sortCodePattern = regexp.MustCompile(`^[0-9]{6}$`)
+
+var ukAccountPattern = regexp.MustCompile(`^[0-9]{8}$`)
+
+//ErrSortCodeFormat is returned when a UK sort code is not 6 digits
+var ErrSortCodeFormat = errors.New("sort code must be 6 digits")
+
+//ErrUKAccountFormat is returned when a UK account number is not 8 digits
+var ErrUKAccountFormat = errors.New("UK account number must be 8 digits")
+
+//ErrModulusCheck is returned when a UK account number fails the modulus check of its sort code
+var ErrModulusCheck = errors.New("account number is not valid for the sort code")
+
+//supportedExceptions are the exceptions of the VocaLink specification that ValidateUKAccount implements.
+//Exception 5 needs the substitution table of the specification, its sort codes are presumed valid like the ones not in the table.
+var supportedExceptions = map[int]bool{0: true, 1: true, 2: true, 3: true, 4: true, 6: true, 7: true, 8: true, 9: true, 10: true, 11: true, 12: true, 13: true, 14: true}
+
+//eitherExceptions are the exceptions of the first row of the pairs 2 and 9, 10 and 11, 12 and 13,
+//of which the account number is valid when either check passes
+var eitherExceptions = map[int]bool{2: true, 10: true, 12: true}
+
+//Substitutions of the exceptions
+const (
+	//exception8SortCode replaces the sort code of the rows with the exception 8
+	exception8SortCode = "090126"
+	//exception9SortCode replaces the sort code of the rows with the exception 9
+	exception9SortCode = "309634"
+)
+
+//exception2Weights replace the weights of the rows with the exception 2 when a is not 0, and exception2WeightsG9 when g is 9 too
+var (
+	exception2Weights   = [14]int{0, 0, 1, 2, 5, 3, 6, 4, 8, 7, 10, 9, 3, 1}
+	exception2WeightsG9 = [14]int{0, 0, 0, 0, 0, 0, 0, 0, 8, 7, 10, 9, 3, 1}
+)
+
+//ModulusWeight is a row of the VocaLink modulus weight table (valacdos.txt)
+type ModulusWeight struct {
+	From      string
+	To        string
+	Algorithm string
+	Weights   [14]int
+	Exception int
+}
+
+//ModulusTable contains the modulus weights used to check UK account numbers
+type ModulusTable struct {
+	weights []ModulusWeight
+}
+
+var modulusTable = struct {
+	sync.RWMutex
+	table *ModulusTable
+}{table: &ModulusTable{}}
+
+//SetModulusTable replaces the table used by ValidateUKAccount.
+//The default table is empty, so only the format of the account number is checked.
+func SetModulusTable(t *ModulusTable) {
+	modulusTable.Lock()
+	defer modulusTable.Unlock()
+	modulusTable.table = t
+}
+
+//ParseModulusTable reads a modulus weight table in the format of the VocaLink valacdos.txt file.
+//Each line has the first and last sort code of the range, the algorithm, 14 weights and an optional exception.
+func ParseModulusTable(r io.Reader) (*ModulusTable, error) {
+	t := &ModulusTable{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 17 && len(fields) != 18 {
+			return nil, fmt.Errorf("modulus table line %d: expected 17 or 18 fields, got %d", line, len(fields))
+		}
+		w := ModulusWeight{From: fields[0], To: fields[1], Algorithm: fields[2]}
+		if !sortCodePattern.MatchString(w.From) || !sortCodePattern.MatchString(w.To) {
+			return nil, fmt.Errorf("modulus table line %d: %v", line, ErrSortCodeFormat)
+		}
+		if w.Algorithm != ModulusMod10 && w.Algorithm != ModulusMod11 && w.Algorithm != ModulusDblAl {
+			return nil, fmt.Errorf("modulus table line %d: unknown algorithm %q", line, w.Algorithm)
+		}
+		for i := range w.Weights {
+			weight, err := strconv.Atoi(fields[3+i])
+			if err != nil {
+				return nil, fmt.Errorf("modulus table line %d: %v", line, err)
+			}
+			w.Weights[i] = weight
+		}
+		if len(fields) == 18 {
+			exception, err := strconv.Atoi(fields[17])
+			if err != nil {
+				return nil, fmt.Errorf("modulus table line %d: %v", line, err)
+			}
+			w.Exception = exception
+		}
+		t.weights = append(t.weights, w)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//ValidateSortCode checks that a UK sort code has 6 digits
+func ValidateSortCode(sortCode string) error {
+	if !sortCodePattern.MatchString(sortCode) {
+		return ErrSortCodeFormat
+	}
+	return nil
+}
+
+//ValidateUKAccount checks that a UK account number has 8 digits and passes the modulus checks of its sort code.
+//Sort codes that are not in the modulus table can not be checked and are presumed valid, as the specification says,
+//and so are the ones with an exception that is not supported.
+func ValidateUKAccount(sortCode, accountNumber string) error {
+	if err := ValidateSortCode(sortCode); err != nil {
+		return err
+	}
+	if !ukAccountPattern.MatchString(accountNumber) {
+		return ErrUKAccountFormat
+	}
+
+	modulusTable.RLock()
+	table := modulusTable.table
+	modulusTable.RUnlock()
+
+	var rows []ModulusWeight
+	for _, w := range table.weights {
+		if sortCode < w.From || sortCode > w.To {
+			continue
+		}
+		if !supportedExceptions[w.Exception] {
+			return nil
+		}
+		rows = append(rows, w)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if eitherExceptions[rows[0].Exception] {
+		for _, w := range rows {
+			if w.check(sortCode, accountNumber) {
+				return nil
+			}
+		}
+		return ErrModulusCheck
+	}
+	for _, w := range rows {
+		if !w.check(sortCode, accountNumber) {
+			return ErrModulusCheck
+		}
+	}
+	return nil
+}
+
+//check runs the algorithm of the row on the sort code and the account number, with the exception of the row.
+//The digits are named u v w x y z for the sort code and a b c d e f g h for the account number, as in the specification.
+func (w ModulusWeight) check(sortCode, accountNumber string) bool {
+	a, b, c, g, h := accountNumber[0], accountNumber[1], accountNumber[2], accountNumber[6], accountNumber[7]
+	weights := w.Weights
+	switch w.Exception {
+	case 2:
+		if a != '0' && g != '9' {
+			weights = exception2Weights
+		} else if a != '0' {
+			weights = exception2WeightsG9
+		}
+	case 3:
+		//the check is not needed when c is 6 or 9
+		if c == '6' || c == '9' {
+			return true
+		}
+	case 6:
+		//foreign currency accounts can not be checked
+		if a >= '4' && a <= '8' && g == h {
+			return true
+		}
+	case 7:
+		//when g is 9 the sort code and a b are not checked
+		if g == '9' {
+			zeroSortCode(&weights)
+		}
+	case 8:
+		sortCode = exception8SortCode
+	case 9:
+		sortCode = exception9SortCode
+	case 10:
+		//when a b is 09 or 99 and g is 9 the sort code and a b are not checked
+		if (a == '0' || a == '9') && b == '9' && g == '9' {
+			zeroSortCode(&weights)
+		}
+	}
+
+	total := w.total(sortCode+accountNumber, weights)
+	switch {
+	case w.Exception == 1:
+		return (total+27)%10 == 0
+	case w.Exception == 4:
+		//the remainder is the check digits g h
+		return total%11 == int(g-'0')*10+int(h-'0')
+	case w.Exception == 14 && total%11 != 0:
+		//when h is 0, 1 or 9 it is dropped and the other digits are checked again, shifted right after a 0
+		if h != '0' && h != '1' && h != '9' {
+			return false
+		}
+		return w.total(sortCode+"0"+accountNumber[:7], weights)%11 == 0
+	case w.Algorithm == ModulusMod11:
+		return total%11 == 0
+	}
+	return total%10 == 0
+}
+
+//total returns the sum of the 14 digits of the sort code and the account number by the weights, with the algorithm of the row
+func (w ModulusWeight) total(number string, weights [14]int) int {
+	total := 0
+	for i := 0; i < 14; i++ {
+		product := int(number[i]-'0') * weights[i]
+		if w.Algorithm == ModulusDblAl {
+			//the digits of each product are added, e.g. 16 counts as 1 + 6
+			total += product/10 + product%10
+			continue
+		}
+		total += product
+	}
+	return total
+}
+
+//zeroSortCode zeroes the weights of the sort code and of the digits a b of the account number
+func zeroSortCode(weights *[14]int) {
+	for i := 0; i < 8; i++ {
+		weights[i] = 0
+	}
+}
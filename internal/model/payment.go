@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 //Payment Root element
 type Payment struct {
 	Type           string     `json:"type" sql:",notnull" validate:"required"`
@@ -7,11 +9,28 @@ type Payment struct {
 	Version        int        `json:"version" sql:",notnull"`
 	OrganisationID string     `json:"organisation_id" sql:",notnull" validate:"required"`
 	Attributes     Attributes `json:"attributes" sql:",notnull" validate:"required"`
+	//Status and StatusHistory are managed by the service, the values sent by clients are ignored.
+	Status        Status             `json:"status" sql:",notnull"`
+	StatusHistory []StatusTransition `json:"status_history"`
+	//CreatedBy is the subject of the maker of the payment, UpdatedBy of the makers that changed it after
+	//and Approvals the decisions of its approvers, managed by the service.
+	CreatedBy string     `json:"created_by"`
+	UpdatedBy []string   `json:"updated_by"`
+	Approvals []Approval `json:"approvals"`
+	//DeletedAt and DeletedBy are set when the payment is deleted, it is hidden until it is restored or purged. Managed by the service.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy string     `json:"deleted_by,omitempty"`
+}
+
+//Deleted returns true if the payment is deleted
+func (p *Payment) Deleted() bool {
+	return p.DeletedAt != nil
 }
 
-//Attributes contains details about a payment
+//Attributes contains details about a payment.
+//The amounts are validated against their currency with ValidateAmount.
 type Attributes struct {
-	Amount           string `json:"amount" sql:",notnull" validate:"required"`
+	Amount           Decimal `json:"amount" sql:",notnull"`
 	BeneficiaryParty struct {
 		AccountName       string `json:"account_name" sql:",notnull" validate:"required"`
 		AccountNumber     string `json:"account_number" sql:",notnull" validate:"required"`
@@ -25,11 +44,11 @@ type Attributes struct {
 	ChargesInformation struct {
 		BearerCode    string `json:"bearer_code" sql:",notnull" validate:"required"`
 		SenderCharges []struct {
-			Amount   string `json:"amount" sql:",notnull" validate:"required"`
-			Currency string `json:"currency" sql:",notnull" validate:"required"`
+			Amount   Decimal `json:"amount" sql:",notnull"`
+			Currency string  `json:"currency" sql:",notnull" validate:"required"`
 		} `json:"sender_charges" sql:",notnull" validate:"required"`
-		ReceiverChargesAmount   string `json:"receiver_charges_amount" sql:",notnull" validate:"required"`
-		ReceiverChargesCurrency string `json:"receiver_charges_currency" sql:",notnull" validate:"required"`
+		ReceiverChargesAmount   Decimal `json:"receiver_charges_amount" sql:",notnull"`
+		ReceiverChargesCurrency string  `json:"receiver_charges_currency" sql:",notnull" validate:"required"`
 	} `json:"charges_information" sql:",notnull" validate:"required"`
 	Currency    string `json:"currency" sql:",notnull" validate:"required"`
 	DebtorParty struct {
@@ -43,10 +62,10 @@ type Attributes struct {
 	} `json:"debtor_party" sql:",notnull" validate:"required"`
 	EndToEndReference string `json:"end_to_end_reference" sql:",notnull" validate:"required"`
 	Fx                struct {
-		ContractReference string `json:"contract_reference" sql:",notnull" validate:"required"`
-		ExchangeRate      string `json:"exchange_rate" sql:",notnull" validate:"required"`
-		OriginalAmount    string `json:"original_amount" sql:",notnull" validate:"required"`
-		OriginalCurrency  string `json:"original_currency" sql:",notnull" validate:"required"`
+		ContractReference string  `json:"contract_reference" sql:",notnull" validate:"required"`
+		ExchangeRate      Decimal `json:"exchange_rate" sql:",notnull"`
+		OriginalAmount    Decimal `json:"original_amount" sql:",notnull"`
+		OriginalCurrency  string  `json:"original_currency" sql:",notnull" validate:"required"`
 	} `json:"fx" sql:",notnull" validate:"required"`
 	NumericReference     string `json:"numeric_reference" sql:",notnull" validate:"required"`
 	ID                   string `json:"payment_id" sql:",notnull" validate:"required"`
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+//Status is the stage of a payment in its lifecycle
+type Status string
+
+//Payment statuses
+const (
+	StatusDraft     Status = "draft"
+	StatusSubmitted Status = "submitted"
+	StatusSettled   Status = "settled"
+	StatusRejected  Status = "rejected"
+	StatusCancelled Status = "cancelled"
+)
+
+//transitions contains the statuses a payment can move to from each status.
+//A new payment has no status and can only become a draft.
+//A draft is only submitted once it is approved, but it can be cancelled without an approval as no money moves.
+//Settled, rejected and cancelled are final.
+var transitions = map[Status][]Status{
+	"":              {StatusDraft},
+	StatusDraft:     {StatusSubmitted, StatusCancelled},
+	StatusSubmitted: {StatusSettled, StatusRejected, StatusCancelled},
+}
+
+//StatusTransition records when a payment moved from one status to another
+type StatusTransition struct {
+	From Status    `json:"from,omitempty"`
+	To   Status    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+//TransitionError is returned when a payment can not move to the requested status
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("payment can not move from status %q to %q", e.From, e.To)
+}
+
+//Valid returns true if s is one of the known statuses
+func (s Status) Valid() bool {
+	switch s {
+	case StatusDraft, StatusSubmitted, StatusSettled, StatusRejected, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+//CanTransitionTo returns true if a payment in status s can move to status to
+func (s Status) CanTransitionTo(to Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+//Reviewed returns true if the move from status s to status to decides the outcome of a submitted payment.
+//These transitions are made by a reviewer, not by the maker of the payment.
+func (s Status) Reviewed(to Status) bool {
+	return s == StatusSubmitted && (to == StatusSettled || to == StatusRejected)
+}
+
+//Editable returns true if the content of a payment in status s can still be modified
+func (s Status) Editable() bool {
+	return s == StatusDraft
+}
+
+//Transition moves the payment to status to and records the time of the transition.
+//Returns a *TransitionError if the transition is not allowed,
+//ErrApprovalRequired if a draft is submitted before it is approved.
+func (p *Payment) Transition(to Status, at time.Time) error {
+	if !p.Status.CanTransitionTo(to) {
+		return &TransitionError{From: p.Status, To: to}
+	}
+	if p.Status == StatusDraft && to == StatusSubmitted && !p.Approved() {
+		return ErrApprovalRequired
+	}
+	p.StatusHistory = append(p.StatusHistory, StatusTransition{From: p.Status, To: to, At: at})
+	p.Status = to
+	return nil
+}
+
+//TransitionBy moves the payment to status to like Transition, for the caller by.
+//ErrSelfApproval if the transition is reviewed and by is empty or a maker of the payment.
+func (p *Payment) TransitionBy(to Status, by string, at time.Time) error {
+	if p.Status.Reviewed(to) && (by == "" || p.MadeBy(by)) {
+		return ErrSelfApproval
+	}
+	return p.Transition(to, at)
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPayment_Transition(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{CreatedBy: "alice"}
+
+	assert.Nil(t, p.Transition(StatusDraft, at))
+	assert.True(t, p.Status.Editable())
+
+	assert.Nil(t, p.Approve("bob", at.Add(time.Minute)))
+	assert.False(t, p.Status.Editable())
+
+	assert.Nil(t, p.Transition(StatusSettled, at.Add(time.Hour)))
+	assert.Equal(t, StatusSettled, p.Status)
+	assert.Equal(t, []StatusTransition{
+		{To: StatusDraft, At: at},
+		{From: StatusDraft, To: StatusSubmitted, At: at.Add(time.Minute)},
+		{From: StatusSubmitted, To: StatusSettled, At: at.Add(time.Hour)},
+	}, p.StatusHistory)
+}
+
+func TestPayment_TransitionNotAllowed(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{}
+
+	err := p.Transition(StatusSubmitted, at)
+	assert.Equal(t, &TransitionError{From: "", To: StatusSubmitted}, err)
+
+	assert.Nil(t, p.Transition(StatusDraft, at))
+	assert.Nil(t, p.Transition(StatusCancelled, at))
+
+	// final statuses can not move anywhere
+	for _, to := range []Status{StatusDraft, StatusSubmitted, StatusSettled, StatusRejected, StatusCancelled} {
+		err = p.Transition(to, at)
+		assert.Equal(t, &TransitionError{From: StatusCancelled, To: to}, err)
+	}
+	assert.Equal(t, 2, len(p.StatusHistory), "failed transitions should not be recorded")
+}
+
+func TestPayment_TransitionBy(t *testing.T) {
+	at := time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC)
+	p := &Payment{CreatedBy: "alice"}
+	assert.Nil(t, p.TransitionBy(StatusDraft, "alice", at))
+	assert.Nil(t, p.Approve("bob", at))
+
+	for _, to := range []Status{StatusSettled, StatusRejected} {
+		assert.Equal(t, ErrSelfApproval, p.TransitionBy(to, "alice", at), "the maker should not decide the outcome")
+		assert.Equal(t, ErrSelfApproval, p.TransitionBy(to, "", at))
+	}
+	assert.Nil(t, p.TransitionBy(StatusRejected, "bob", at))
+	assert.Equal(t, StatusRejected, p.Status)
+}
+
+func TestStatus_Valid(t *testing.T) {
+	assert.True(t, StatusDraft.Valid())
+	assert.False(t, Status("").Valid())
+	assert.False(t, Status("paid").Valid())
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//Subscription is a webhook of an organisation, the events of its payments are posted to URL.
+//The deliveries are signed with Secret, it is only sent to the caller when the subscription is created.
+type Subscription struct {
+	tableName struct{} `sql:"webhook_subscriptions"`
+
+	ID             string `json:"id"`
+	OrganisationID string `json:"organisation_id" sql:",notnull"`
+	URL            string `json:"url" sql:",notnull"`
+	//EventTypes are the types of the events posted, every type when it is empty
+	EventTypes []string  `json:"event_types" sql:",array"`
+	Secret     string    `json:"-" sql:",notnull"`
+	CreatedAt  time.Time `json:"created_at" sql:",notnull"`
+}
+
+//Accepts returns true if the events of the type are posted to the subscription
+func (s *Subscription) Accepts(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//DeliveryStatus is the state of a Delivery
+type DeliveryStatus string
+
+//The deliveries are pending until they are delivered, or dead once every attempt failed.
+//A dead delivery is pending again when it is replayed.
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+//Delivery is an event to post to a subscription, with its attempts
+type Delivery struct {
+	tableName struct{} `sql:"webhook_deliveries"`
+
+	ID             string         `json:"id"`
+	SubscriptionID string         `json:"subscription_id" sql:",notnull"`
+	OrganisationID string         `json:"organisation_id" sql:",notnull"`
+	EventID        int64          `json:"event_id" sql:",notnull"`
+	EventType      string         `json:"event_type" sql:",notnull"`
+	Status         DeliveryStatus `json:"status" sql:",notnull"`
+	//Payload is the json of the event posted
+	Payload       json.RawMessage `json:"payload" sql:",notnull"`
+	Attempts      int             `json:"attempts" sql:",notnull"`
+	NextAttemptAt time.Time       `json:"next_attempt_at" sql:",notnull"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at" sql:",notnull"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
@@ -0,0 +1,162 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//failingSink fails the deliveries after the first ok ones, then delivers to next
+type failingSink struct {
+	ok   int
+	next Sink
+}
+
+func (s *failingSink) Publish(ctx context.Context, e repository.Event) error {
+	if s.ok == 0 {
+		return errors.New("unavailable")
+	}
+	s.ok--
+	return s.next.Publish(ctx, e)
+}
+
+//changes makes a create, a status change and a delete of a payment in memory
+func changes(t *testing.T) *repository.Memory {
+	ctx := repository.WithScope(context.Background(), repository.Scope{Organisations: []string{"1"}})
+	m := repository.NewMemory()
+	p := &model.Payment{ID: "1", OrganisationID: "1", Status: model.StatusDraft}
+	assert.Nil(t, m.Create(ctx, p))
+	p.Status = model.StatusCancelled
+	assert.Nil(t, m.Update(ctx, p))
+	assert.Nil(t, m.Delete(ctx, "1", repository.AnyVersion))
+	return m
+}
+
+//decodeEvents decodes the json lines written by a WriterSink
+func decodeEvents(t *testing.T, lines string) []repository.Event {
+	var events []repository.Event
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		var e repository.Event
+		assert.Nil(t, json.Unmarshal([]byte(line), &e), line)
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestPublisher(t *testing.T) {
+	var out bytes.Buffer
+	sink := &failingSink{ok: 1, next: &WriterSink{W: &out}}
+	p := &Publisher{Outbox: changes(t), Sink: sink, BatchSize: 2}
+
+	//the events after a failed delivery wait for it
+	n, err := p.Publish(context.Background())
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, 1, n)
+
+	sink.ok = 10
+	n, err = p.Publish(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	events := decodeEvents(t, out.String())
+	types := []repository.EventType{repository.EventCreated, repository.EventStatusChanged, repository.EventDeleted}
+	if assert.Equal(t, len(types), len(events)) {
+		for i, e := range events {
+			assert.Equal(t, int64(i+1), e.ID)
+			assert.Equal(t, types[i], e.Type)
+			assert.Equal(t, "1", e.PaymentID)
+			assert.Equal(t, "1", e.OrganisationID)
+			assert.False(t, e.OccurredAt.IsZero())
+		}
+		assert.Equal(t, model.StatusCancelled, events[1].Payment.Status)
+		assert.True(t, events[2].Payment.Deleted())
+	}
+
+	n, err = p.Publish(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "the events are published once")
+}
+
+func TestSinks(t *testing.T) {
+	var first, second bytes.Buffer
+	failing := &failingSink{ok: 1, next: &WriterSink{W: &second}}
+	p := &Publisher{Outbox: changes(t), Sink: Sinks{&WriterSink{W: &first}, failing}}
+
+	//an event is published again to the sinks before the one that failed
+	_, err := p.Publish(context.Background())
+	assert.EqualError(t, err, "unavailable")
+	failing.ok = 10
+	n, err := p.Publish(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 4, len(decodeEvents(t, first.String())))
+	assert.Equal(t, 3, len(decodeEvents(t, second.String())))
+}
+
+func TestPublisher_Run(t *testing.T) {
+	var out bytes.Buffer
+	p := &Publisher{Outbox: changes(t), Sink: &WriterSink{W: &out}, Interval: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, 3, len(decodeEvents(t, out.String())))
+}
+
+func TestWebhookSink(t *testing.T) {
+	status := http.StatusNoContent
+	var received []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(server.URL + "/events")
+	assert.Nil(t, err)
+	e := repository.Event{ID: 7, Type: repository.EventCreated, PaymentID: "1", OrganisationID: "1"}
+	assert.Nil(t, sink.Publish(context.Background(), e))
+	if assert.Equal(t, 1, len(received)) {
+		assert.Equal(t, "POST", received[0].Method)
+		assert.Equal(t, "/events", received[0].URL.Path)
+		assert.Equal(t, "7", received[0].Header.Get(EventIDHeader))
+		assert.Equal(t, "payment.created", received[0].Header.Get(EventTypeHeader))
+		assert.Equal(t, "application/json", received[0].Header.Get("Content-Type"))
+		assert.Contains(t, bodies[0], `"payment_id":"1"`)
+	}
+
+	status = http.StatusServiceUnavailable
+	assert.NotNil(t, sink.Publish(context.Background(), e), "a delivery is failed without a 2xx status")
+}
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink("stdout")
+	assert.Nil(t, err)
+	assert.IsType(t, &WriterSink{}, sink)
+
+	path := t.TempDir() + "/events.jsonl"
+	sink, err = NewSink("file:" + path)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Publish(context.Background(), repository.Event{ID: 1}))
+
+	_, err = NewSink("kafka://localhost")
+	assert.NotNil(t, err)
+}
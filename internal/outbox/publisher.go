@@ -0,0 +1,63 @@
+package outbox
+
+import (
+	"context"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+//DefaultBatchSize is the number of events read from the outbox at a time when Publisher.BatchSize is 0
+const DefaultBatchSize = 100
+
+//Publisher delivers the events of the outbox to a sink, in order and at least once.
+//An event is published again if the publisher stops before it is marked published,
+//the sinks identify the duplicates by the id of the event.
+type Publisher struct {
+	Outbox repository.Outbox
+	Sink   Sink
+	//Interval is the time between two reads of the outbox
+	Interval  time.Duration
+	BatchSize int
+}
+
+//Run publishes the events every Interval until ctx is done.
+//A delivery that fails is logged and tried again on the next interval.
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := p.Publish(ctx)
+		if n > 0 {
+			logrus.WithField("events", n).Debug("published the events")
+		}
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warn("error publishing the events")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//Publish delivers the events not published yet, a batch at a time, until there are none left or a delivery fails.
+//Returns the number of events published.
+func (p *Publisher) Publish(ctx context.Context) (int, error) {
+	size := p.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	ctx = repository.WithSystemScope(ctx)
+	total := 0
+	for {
+		n, err := p.Outbox.PublishEvents(ctx, size, func(e repository.Event) error {
+			return p.Sink.Publish(ctx, e)
+		})
+		total += n
+		if err != nil || n < size {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Headers of the requests of WebhookSink, the body is the json of the event
+const (
+	EventIDHeader   = "X-Event-ID"
+	EventTypeHeader = "X-Event-Type"
+)
+
+//Sink receives the events published. Publish returns nil once the event is delivered,
+//an event may be delivered more than once.
+type Sink interface {
+	Publish(ctx context.Context, e repository.Event) error
+}
+
+//NewSink returns the sink of the target: stdout, file:<path> to append the events to a file,
+//or an http(s) URL to post them to
+func NewSink(target string) (Sink, error) {
+	switch {
+	case target == "stdout":
+		return &WriterSink{W: os.Stdout}, nil
+	case strings.HasPrefix(target, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(target, "file:"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &WriterSink{W: f}, nil
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return NewWebhookSink(target), nil
+	}
+	return nil, fmt.Errorf("unknown events sink %q, must be stdout, file:<path> or an http(s) URL", target)
+}
+
+//WriterSink writes the events to W as json lines, for local development and tests
+type WriterSink struct {
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+//Publish writes the event in a line
+func (s *WriterSink) Publish(ctx context.Context, e repository.Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.W.Write(append(line, '\n'))
+	return err
+}
+
+//WebhookSink posts the events to a URL
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+//NewWebhookSink returns the sink of the URL
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+//Publish posts the json of the event, with its id and type in the EventIDHeader and EventTypeHeader headers.
+//The event is delivered when the response has a 2xx status.
+func (s *WebhookSink) Publish(ctx context.Context, e repository.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, strconv.FormatInt(e.ID, 10))
+	req.Header.Set(EventTypeHeader, string(e.Type))
+	resp, err := s.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook %s returned the status %d", s.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+//Sinks publishes the events to each of its sinks in turn. It stops at the first error,
+//so an event is published again to the sinks before the one that failed too.
+type Sinks []Sink
+
+//Publish publishes the event to each sink
+func (ss Sinks) Publish(ctx context.Context, e repository.Event) error {
+	for _, s := range ss {
+		if err := s.Publish(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
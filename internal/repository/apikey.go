@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/go-pg/pg"
+	"github.com/plusspeed/payments-api/internal/model"
+)
+
+//ErrKeyNotFound is returned when no api key is returned
+var ErrKeyNotFound = errors.New("api key not found")
+
+//KeyStore contains the DB operations for an APIKey.
+//Like the payments, the keys are scoped to the organisations of the Scope of the context.
+//Repository stores the keys in postgres and Memory in memory.
+type KeyStore interface {
+	CreateKey(ctx context.Context, k *model.APIKey) error
+	GetKey(ctx context.Context, id string) (*model.APIKey, error)
+	ListKeys(ctx context.Context) ([]model.APIKey, error)
+	UpdateKey(ctx context.Context, k *model.APIKey) error
+}
+
+//CreateKey inserts a model.APIKey
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a key with the same id exists
+func (d *Repository) CreateKey(ctx context.Context, k *model.APIKey) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		if !s.Allows(k.OrganisationID) {
+			return ErrOutOfScope
+		}
+		err := tx.Insert(k)
+		if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == uniqueViolation {
+			return ErrAlreadyExists
+		}
+		return err
+	})
+}
+
+//GetKey returns a model.APIKey of an organisation of the scope of ctx, revoked or not
+//ErrKeyNotFound if not found
+func (d *Repository) GetKey(ctx context.Context, id string) (*model.APIKey, error) {
+	k := &model.APIKey{ID: id}
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(k).WherePK(), s).Select()
+	})
+	if err == pg.ErrNoRows {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+//ListKeys returns the keys of the organisations of the scope of ctx ordered by creation, revoked or not
+func (d *Repository) ListKeys(ctx context.Context) ([]model.APIKey, error) {
+	var ks []model.APIKey
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(&ks), s).Order("created_at ASC", "id ASC").Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+//UpdateKey stores the secret, the rotation and the revocation of a key of an organisation of the scope of ctx.
+//The other fields of a key do not change.
+//ErrKeyNotFound if not found
+func (d *Repository) UpdateKey(ctx context.Context, k *model.APIKey) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		res, err := scopeQuery(tx.Model(k).Column("salt", "hash", "rotated_at", "revoked_at").WherePK(), s).Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return ErrKeyNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"github.com/go-pg/pg"
+	"github.com/plusspeed/payments-api/internal/model"
+	"time"
+)
+
+//AuditAction is the change of a payment recorded by an AuditEntry
+type AuditAction string
+
+//Actions of the audit entries
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditStatus  AuditAction = "status"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+	AuditPurge   AuditAction = "purge"
+)
+
+//Actor is who changes the payments, recorded in their audit entries
+type Actor struct {
+	//Subject identifies the caller, empty if it is not known
+	Subject string
+	//RequestID identifies the request that made the change
+	RequestID string
+}
+
+type actorKey struct{}
+
+//WithActor returns a copy of ctx whose changes of the payments are recorded as made by the actor
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+//ActorFrom returns the actor of the context, the zero Actor if it has none
+func ActorFrom(ctx context.Context) Actor {
+	a, _ := ctx.Value(actorKey{}).(Actor)
+	return a
+}
+
+//AuditEntry records a change of a payment with the payment before and after it.
+//The entries are append-only, they are written in the transaction of the change and never updated.
+type AuditEntry struct {
+	tableName struct{} `sql:"payment_audit"`
+
+	//ID is the sequence of the entry, the entries of a payment are in the order of their ids
+	ID             int64       `json:"id"`
+	PaymentID      string      `json:"payment_id" sql:",notnull"`
+	OrganisationID string      `json:"organisation_id" sql:",notnull"`
+	Action         AuditAction `json:"action" sql:",notnull"`
+	Actor          string      `json:"actor"`
+	RequestID      string      `json:"request_id"`
+	At             time.Time   `json:"at" sql:",notnull"`
+	//Before is nil for a create. Both are nil for a purge, its entry only has the ids of the payment removed,
+	//the payment is still in the entries before it
+	Before *model.Payment `json:"before"`
+	After  *model.Payment `json:"after"`
+}
+
+//AuditLog returns the changes of the payments.
+//Repository stores them in postgres and Memory in memory.
+type AuditLog interface {
+	//History returns the entries of the payment of an organisation of the scope of ctx in order, ErrNotFound if there are none
+	History(ctx context.Context, paymentID string) ([]AuditEntry, error)
+}
+
+//newAuditEntry returns the entry of the change of the payment from before to after by the actor of ctx.
+//An update that deletes or restores the payment, or changes its status, is recorded as such.
+func newAuditEntry(ctx context.Context, before, after *model.Payment) *AuditEntry {
+	actor := ActorFrom(ctx)
+	e := &AuditEntry{Actor: actor.Subject, RequestID: actor.RequestID, At: time.Now().UTC(), Before: before, After: after}
+	switch {
+	case before == nil:
+		e.Action = AuditCreate
+	case after == nil:
+		e.Action, e.PaymentID, e.OrganisationID, e.Before = AuditPurge, before.ID, before.OrganisationID, nil
+		return e
+	case !before.Deleted() && after.Deleted():
+		e.Action = AuditDelete
+	case before.Deleted() && !after.Deleted():
+		e.Action = AuditRestore
+	case before.Status != after.Status:
+		e.Action = AuditStatus
+	default:
+		e.Action = AuditUpdate
+	}
+	e.PaymentID, e.OrganisationID = after.ID, after.OrganisationID
+	return e
+}
+
+//History returns the audit entries of the payment in order
+//ErrNotFound if there are none in the scope of ctx
+func (d *Repository) History(ctx context.Context, paymentID string) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(&entries).Where("payment_id = ?", paymentID), s).Order("id ASC").Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"github.com/go-pg/pg"
+)
+
+//ChangeFeed returns the events of the changes of the payments in the order they were committed, to follow them from a cursor.
+//Unlike the list of the payments, a change is never inserted before the cursor of a reader.
+//Repository reads them from the outbox in postgres and Memory in memory.
+type ChangeFeed interface {
+	//Changes returns up to limit events of the scope of ctx with a position greater than after, ordered by position.
+	//An after of 0 starts at the first event.
+	Changes(ctx context.Context, after int64, limit int) ([]Event, error)
+}
+
+//Changes reads the events of the outbox, published or not, that were given a position by the publisher.
+//The events of the transactions still running, and the ones committed since the last pass of the publisher, are not read yet.
+func (d *Repository) Changes(ctx context.Context, after int64, limit int) ([]Event, error) {
+	var events []Event
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(&events).Where("position > ?", after), s).Order("position ASC").Limit(limit).Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
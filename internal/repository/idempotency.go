@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/go-pg/pg"
+	"net/http"
+	"time"
+)
+
+//ErrIdempotencyNotFound is returned when no response is stored for an idempotency key, or it expired
+var ErrIdempotencyNotFound = errors.New("idempotent response not found")
+
+//IdempotentResponse is the response of a request with an idempotency key, replayed to the retries of the request until it expires
+type IdempotentResponse struct {
+	tableName struct{} `sql:"idempotency_keys"`
+
+	//Key identifies the caller and the key of its request
+	Key string `sql:",pk"`
+	//RequestHash is the hash of the request, a request with the same key and another hash is not a retry
+	RequestHash []byte `sql:",notnull"`
+	//Status is 0 while the request runs, the response is not stored yet
+	Status    int `sql:",notnull"`
+	Header    http.Header
+	Body      []byte
+	CreatedAt time.Time `sql:",notnull"`
+	ExpiresAt time.Time `sql:",notnull"`
+}
+
+//Pending returns true if the request of the key is running and its response is not stored yet
+func (r *IdempotentResponse) Pending() bool {
+	return r.Status == 0
+}
+
+//IdempotencyStore stores the responses of the requests with an idempotency key.
+//A request claims its key with a pending marker, so no connection is held while it runs.
+//Repository stores them in postgres and Memory in memory.
+type IdempotencyStore interface {
+	//ClaimIdempotencyKey stores the pending marker r, unless a response or a marker that did not expire is stored for its key.
+	//Returns the one stored, or nil if r was stored and the caller runs the request.
+	ClaimIdempotencyKey(ctx context.Context, r *IdempotentResponse) (*IdempotentResponse, error)
+	//GetIdempotent returns the response or the marker stored for the key, ErrIdempotencyNotFound if there is none or it expired
+	GetIdempotent(ctx context.Context, key string) (*IdempotentResponse, error)
+	//SaveIdempotent stores the response, replacing the marker or the expired response of the same key
+	SaveIdempotent(ctx context.Context, r *IdempotentResponse) error
+	//ReleaseIdempotencyKey deletes the pending marker of the key, so a retry runs the request again
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
+
+//ClaimIdempotencyKey inserts the marker with ON CONFLICT DO NOTHING in a short transaction,
+//so the requests with the same key are serialised across the instances of the app by the primary key.
+func (d *Repository) ClaimIdempotencyKey(ctx context.Context, r *IdempotentResponse) (*IdempotentResponse, error) {
+	var stored *IdempotentResponse
+	err := d.withContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE key = ? AND expires_at <= ?", r.Key, time.Now()); err != nil {
+			return err
+		}
+		res, err := tx.Model(r).OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() > 0 {
+			return nil
+		}
+		stored = &IdempotentResponse{}
+		return tx.Model(stored).Where("key = ?", r.Key).Select()
+	})
+	if err == pg.ErrNoRows {
+		//the marker was released meanwhile, the caller claims the key again
+		return &IdempotentResponse{Key: r.Key, RequestHash: r.RequestHash}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+//GetIdempotent returns the response stored for the key
+//ErrIdempotencyNotFound if there is none or it expired
+func (d *Repository) GetIdempotent(ctx context.Context, key string) (*IdempotentResponse, error) {
+	r := &IdempotentResponse{}
+	err := d.withContext(ctx).Model(r).Where("key = ?", key).Where("expires_at > ?", time.Now()).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrIdempotencyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//SaveIdempotent stores the response and deletes the expired ones
+func (d *Repository) SaveIdempotent(ctx context.Context, r *IdempotentResponse) error {
+	return d.withContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE key = ? OR expires_at <= ?", r.Key, time.Now()); err != nil {
+			return err
+		}
+		return tx.Insert(r)
+	})
+}
+
+//ReleaseIdempotencyKey deletes the marker of the key, the stored response is kept
+func (d *Repository) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := d.withContext(ctx).Exec("DELETE FROM idempotency_keys WHERE key = ? AND status = 0", key)
+	return err
+}
@@ -0,0 +1,781 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"sort"
+	"sync"
+	"time"
+)
+
+//ErrAlreadyExists is returned by Create and CreateKey when a payment or a key with the same id exists
+var ErrAlreadyExists = errors.New("payment already exists")
+
+//Memory keeps the payments, their audit and events, the api keys, the webhooks and the idempotent responses in memory
+//and implements the PaymentTransaction, AuditLog, Outbox, KeyStore, WebhookStore and IdempotencyStore interfaces.
+//It is safe for concurrent use. The payments are lost when the process stops,
+//it is meant for local development and tests.
+type Memory struct {
+	mu         sync.RWMutex
+	payments   map[string]*model.Payment
+	audit      []*AuditEntry
+	events     []*Event
+	keys       map[string]*model.APIKey
+	webhooks   map[string]*model.Subscription
+	deliveries map[string]*model.Delivery
+	idempotent map[string]*IdempotentResponse
+	//publishing is held by PublishEvents, so the events are published in order by one caller at a time
+	publishing sync.Mutex
+}
+
+//NewMemory returns an empty Memory
+func NewMemory() *Memory {
+	return &Memory{
+		payments:   map[string]*model.Payment{},
+		keys:       map[string]*model.APIKey{},
+		webhooks:   map[string]*model.Subscription{},
+		deliveries: map[string]*model.Delivery{},
+		idempotent: map[string]*IdempotentResponse{},
+	}
+}
+
+//Ping returns the error of the context, the memory is always available
+func (m *Memory) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+//Get returns a copy of the payment of an organisation of the scope of ctx, also when it is deleted
+//ErrNotFound if not found
+func (m *Memory) Get(ctx context.Context, id string) (*model.Payment, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	payment, ok := m.find(s, id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clonePayment(payment), nil
+}
+
+//Create stores a copy of the payment
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a payment with the same id exists
+func (m *Memory) Create(ctx context.Context, payment *model.Payment) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Allows(payment.OrganisationID) {
+		return ErrOutOfScope
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.payments[payment.ID]; ok {
+		return ErrAlreadyExists
+	}
+	m.payments[payment.ID] = clonePayment(payment)
+	m.recordChange(newAuditEntry(ctx, nil, payment))
+	return nil
+}
+
+//Update replaces the payment only if its version matches the stored one, like Repository.Update.
+//ErrNotFound if not found or deleted, ErrOutOfScope if the new organisation is not in the scope,
+//*VersionConflictError if the version does not match
+func (m *Memory) Update(ctx context.Context, payment *model.Payment) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Allows(payment.OrganisationID) {
+		return ErrOutOfScope
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.find(s, payment.ID)
+	if !ok || stored.Deleted() {
+		return ErrNotFound
+	}
+	if stored.Version != payment.Version {
+		return &VersionConflictError{Current: stored.Version}
+	}
+	payment.Version++
+	m.payments[payment.ID] = clonePayment(payment)
+	m.recordChange(newAuditEntry(ctx, stored, payment))
+	return nil
+}
+
+//Delete marks the payment of an organisation of the scope of ctx as deleted by the actor of ctx, like Repository.Delete
+//ErrNotFound if not found or already deleted, *VersionConflictError if the version is not AnyVersion and does not match
+func (m *Memory) Delete(ctx context.Context, id string, version int) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.find(s, id)
+	if !ok || stored.Deleted() {
+		return ErrNotFound
+	}
+	if version != AnyVersion && stored.Version != version {
+		return &VersionConflictError{Current: stored.Version}
+	}
+	now := time.Now().UTC()
+	deleted := clonePayment(stored)
+	deleted.Version++
+	deleted.DeletedAt, deleted.DeletedBy = &now, ActorFrom(ctx).Subject
+	m.payments[id] = deleted
+	m.recordChange(newAuditEntry(ctx, stored, deleted))
+	return nil
+}
+
+//Restore clears the deletion of the payment of an organisation of the scope of ctx and returns a copy of it, like Repository.Restore
+//ErrNotFound if not found, ErrNotDeleted if it is not deleted
+func (m *Memory) Restore(ctx context.Context, id string) (*model.Payment, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.find(s, id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !stored.Deleted() {
+		return nil, ErrNotDeleted
+	}
+	restored := clonePayment(stored)
+	restored.Version++
+	restored.DeletedAt, restored.DeletedBy = nil, ""
+	m.payments[id] = restored
+	m.recordChange(newAuditEntry(ctx, stored, restored))
+	return clonePayment(restored), nil
+}
+
+//Purge removes the payments of the scope of ctx deleted before the time, like Repository.Purge
+func (m *Memory) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for id, p := range m.payments {
+		if s.Allows(p.OrganisationID) && p.Deleted() && p.DeletedAt.Before(deletedBefore) {
+			delete(m.payments, id)
+			m.recordChange(newAuditEntry(ctx, p, nil))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+//History returns copies of the audit entries of the payment in order, like Repository.History
+//ErrNotFound if there are none in the scope of ctx
+func (m *Memory) History(ctx context.Context, paymentID string) ([]AuditEntry, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []AuditEntry
+	for _, e := range m.audit {
+		if e.PaymentID == paymentID && s.Allows(e.OrganisationID) {
+			entries = append(entries, *cloneAuditEntry(e))
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+//recordChange stores a copy of the audit entry and its event with the next ids.
+//The caller must hold the lock.
+func (m *Memory) recordChange(e *AuditEntry) {
+	e = cloneAuditEntry(e)
+	e.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, e)
+	event := newEvent(e)
+	event.ID = int64(len(m.events) + 1)
+	//the changes are made under the lock, they are committed in the order of their ids
+	event.Position = event.ID
+	m.events = append(m.events, event)
+}
+
+//Changes returns copies of the events of the scope of ctx after the position, like Repository.Changes
+func (m *Memory) Changes(ctx context.Context, after int64, limit int) ([]Event, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []Event
+	for _, e := range m.events {
+		if len(events) == limit {
+			break
+		}
+		if e.Position > after && s.Allows(e.OrganisationID) {
+			event := *e
+			if event.Payment != nil {
+				event.Payment = clonePayment(event.Payment)
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+//PublishEvents passes copies of the events to publish like Repository.PublishEvents.
+//The lock is not held while publish runs, the changes of the payments are not blocked by a slow delivery.
+func (m *Memory) PublishEvents(ctx context.Context, limit int, publish func(Event) error) (int, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return 0, err
+	}
+	m.publishing.Lock()
+	defer m.publishing.Unlock()
+
+	m.mu.RLock()
+	var pending []*Event
+	for _, e := range m.events {
+		if len(pending) == limit {
+			break
+		}
+		if e.PublishedAt == nil && s.Allows(e.OrganisationID) {
+			pending = append(pending, e)
+		}
+	}
+	m.mu.RUnlock()
+
+	for i, e := range pending {
+		m.mu.RLock()
+		event := *e
+		if event.Payment != nil {
+			event.Payment = clonePayment(event.Payment)
+		}
+		m.mu.RUnlock()
+		publishErr := publish(event)
+
+		m.mu.Lock()
+		if publishErr != nil {
+			e.Attempts++
+			e.LastError = publishErr.Error()
+		} else {
+			now := time.Now().UTC()
+			e.PublishedAt = &now
+		}
+		m.mu.Unlock()
+		if publishErr != nil {
+			return i, publishErr
+		}
+	}
+	return len(pending), nil
+}
+
+//List returns the page of the payments of the organisations of the scope of ctx selected by the filter of the query,
+//in the order of its sort, like Repository.List
+//ErrInvalidSort if the sort field is not in the whitelist, ErrNotFound if there are no payments in the page
+func (m *Memory) List(ctx context.Context, q ListQuery) ([]model.Payment, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !q.Sort.Valid() {
+		return nil, ErrInvalidSort
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	desc := q.Sort.Desc
+	backward := q.Cursor != nil && q.Cursor.Backward
+	if backward {
+		desc = !desc
+	}
+	//less returns true if a is before b in the order of the page
+	less := func(a, b *model.Payment) bool {
+		if desc {
+			return q.Sort.compare(a, b) > 0
+		}
+		return q.Sort.compare(a, b) < 0
+	}
+
+	var selected []*model.Payment
+	for _, p := range m.payments {
+		if !s.Allows(p.OrganisationID) || !q.Filter.Match(p) {
+			continue
+		}
+		if q.Cursor != nil && q.Cursor.ID != "" && !q.Sort.afterCursor(*q.Cursor, desc, p) {
+			continue
+		}
+		selected = append(selected, p)
+	}
+	sort.Slice(selected, func(i, j int) bool { return less(selected[i], selected[j]) })
+
+	offset := 0
+	if q.Cursor == nil && q.Offset > 0 {
+		offset = q.Offset
+	}
+	var ts []model.Payment
+	for i := offset; i < len(selected) && len(ts) < q.Limit; i++ {
+		ts = append(ts, *clonePayment(selected[i]))
+	}
+	if len(ts) == 0 {
+		return nil, ErrNotFound
+	}
+	if backward {
+		reverse(ts)
+	}
+	return ts, nil
+}
+
+//CreateKey stores a copy of the key
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a key with the same id exists
+func (m *Memory) CreateKey(ctx context.Context, k *model.APIKey) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Allows(k.OrganisationID) {
+		return ErrOutOfScope
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.keys[k.ID]; ok {
+		return ErrAlreadyExists
+	}
+	m.keys[k.ID] = cloneKey(k)
+	return nil
+}
+
+//GetKey returns a copy of the key of an organisation of the scope of ctx, revoked or not
+//ErrKeyNotFound if not found
+func (m *Memory) GetKey(ctx context.Context, id string) (*model.APIKey, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.keys[id]
+	if !ok || !s.Allows(k.OrganisationID) {
+		return nil, ErrKeyNotFound
+	}
+	return cloneKey(k), nil
+}
+
+//ListKeys returns the keys of the organisations of the scope of ctx ordered by creation, revoked or not
+func (m *Memory) ListKeys(ctx context.Context) ([]model.APIKey, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ks []model.APIKey
+	for _, k := range m.keys {
+		if s.Allows(k.OrganisationID) {
+			ks = append(ks, *cloneKey(k))
+		}
+	}
+	sort.Slice(ks, func(i, j int) bool {
+		if !ks[i].CreatedAt.Equal(ks[j].CreatedAt) {
+			return ks[i].CreatedAt.Before(ks[j].CreatedAt)
+		}
+		return ks[i].ID < ks[j].ID
+	})
+	return ks, nil
+}
+
+//UpdateKey stores the secret, the rotation and the revocation of a key of an organisation of the scope of ctx, like Repository.UpdateKey
+//ErrKeyNotFound if not found
+func (m *Memory) UpdateKey(ctx context.Context, k *model.APIKey) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.keys[k.ID]
+	if !ok || !s.Allows(stored.OrganisationID) {
+		return ErrKeyNotFound
+	}
+	updated := cloneKey(stored)
+	updated.Salt, updated.Hash = k.Salt, k.Hash
+	updated.RotatedAt, updated.RevokedAt = k.RotatedAt, k.RevokedAt
+	m.keys[k.ID] = cloneKey(updated)
+	return nil
+}
+
+//CreateSubscription stores a copy of the subscription
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a subscription with the same id exists
+func (m *Memory) CreateSubscription(ctx context.Context, sub *model.Subscription) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	if !s.Allows(sub.OrganisationID) {
+		return ErrOutOfScope
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[sub.ID]; ok {
+		return ErrAlreadyExists
+	}
+	m.webhooks[sub.ID] = cloneSubscription(sub)
+	return nil
+}
+
+//GetSubscription returns a copy of the subscription of an organisation of the scope of ctx
+//ErrSubscriptionNotFound if not found
+func (m *Memory) GetSubscription(ctx context.Context, id string) (*model.Subscription, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sub, ok := m.webhooks[id]
+	if !ok || !s.Allows(sub.OrganisationID) {
+		return nil, ErrSubscriptionNotFound
+	}
+	return cloneSubscription(sub), nil
+}
+
+//ListSubscriptions returns the subscriptions of the organisations of the scope of ctx ordered by creation
+func (m *Memory) ListSubscriptions(ctx context.Context) ([]model.Subscription, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subs []model.Subscription
+	for _, sub := range m.webhooks {
+		if s.Allows(sub.OrganisationID) {
+			subs = append(subs, *cloneSubscription(sub))
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs, nil
+}
+
+//DeleteSubscription deletes the subscription of an organisation of the scope of ctx and its deliveries
+//ErrSubscriptionNotFound if not found
+func (m *Memory) DeleteSubscription(ctx context.Context, id string) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.webhooks[id]
+	if !ok || !s.Allows(sub.OrganisationID) {
+		return ErrSubscriptionNotFound
+	}
+	delete(m.webhooks, id)
+	for deliveryID, d := range m.deliveries {
+		if d.SubscriptionID == id {
+			delete(m.deliveries, deliveryID)
+		}
+	}
+	return nil
+}
+
+//CreateDeliveries stores copies of the deliveries, like Repository.CreateDeliveries
+//ErrOutOfScope if the organisation of a delivery is not in the scope of ctx
+func (m *Memory) CreateDeliveries(ctx context.Context, ds []model.Delivery) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		if !s.Allows(d.OrganisationID) {
+			return ErrOutOfScope
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range ds {
+		if m.findDelivery(ds[i].SubscriptionID, ds[i].EventID) {
+			continue
+		}
+		m.deliveries[ds[i].ID] = cloneDelivery(&ds[i])
+	}
+	return nil
+}
+
+//findDelivery returns true if a delivery of the event to the subscription is stored.
+//The caller must hold the lock.
+func (m *Memory) findDelivery(subscriptionID string, eventID int64) bool {
+	for _, d := range m.deliveries {
+		if d.SubscriptionID == subscriptionID && d.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+//ClaimDeliveries returns copies of the pending deliveries due at now and postpones their next attempt, like Repository.ClaimDeliveries
+func (m *Memory) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Delivery, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []model.Delivery
+	for _, d := range m.deliveries {
+		if d.Status == model.DeliveryPending && !d.NextAttemptAt.After(now) && s.Allows(d.OrganisationID) {
+			due = append(due, *d)
+		}
+	}
+	sortDeliveries(due)
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		stored := m.deliveries[due[i].ID]
+		stored.NextAttemptAt = now.Add(lease)
+		due[i] = *cloneDelivery(stored)
+	}
+	return due, nil
+}
+
+//GetDelivery returns a copy of the delivery of an organisation of the scope of ctx
+//ErrDeliveryNotFound if not found
+func (m *Memory) GetDelivery(ctx context.Context, id string) (*model.Delivery, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d, ok := m.deliveries[id]
+	if !ok || !s.Allows(d.OrganisationID) {
+		return nil, ErrDeliveryNotFound
+	}
+	return cloneDelivery(d), nil
+}
+
+//ListDeliveries returns the deliveries with the status of the organisations of the scope of ctx ordered by creation
+func (m *Memory) ListDeliveries(ctx context.Context, status model.DeliveryStatus) ([]model.Delivery, error) {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ds []model.Delivery
+	for _, d := range m.deliveries {
+		if d.Status == status && s.Allows(d.OrganisationID) {
+			ds = append(ds, *cloneDelivery(d))
+		}
+	}
+	sort.Slice(ds, func(i, j int) bool {
+		if !ds[i].CreatedAt.Equal(ds[j].CreatedAt) {
+			return ds[i].CreatedAt.Before(ds[j].CreatedAt)
+		}
+		return ds[i].ID < ds[j].ID
+	})
+	return ds, nil
+}
+
+//UpdateDelivery stores the status, the attempts, the next attempt, the error and the delivery time of a delivery, like Repository.UpdateDelivery
+//ErrDeliveryNotFound if not found
+func (m *Memory) UpdateDelivery(ctx context.Context, d *model.Delivery) error {
+	s, err := memoryScope(ctx)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.deliveries[d.ID]
+	if !ok || !s.Allows(stored.OrganisationID) {
+		return ErrDeliveryNotFound
+	}
+	updated := cloneDelivery(stored)
+	updated.Status, updated.Attempts, updated.NextAttemptAt = d.Status, d.Attempts, d.NextAttemptAt
+	updated.LastError, updated.DeliveredAt = d.LastError, d.DeliveredAt
+	m.deliveries[d.ID] = cloneDelivery(updated)
+	return nil
+}
+
+//ClaimIdempotencyKey stores a copy of the marker unless a response or a marker that did not expire is stored for its key
+func (m *Memory) ClaimIdempotencyKey(ctx context.Context, r *IdempotentResponse) (*IdempotentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.idempotent[r.Key]; ok && time.Now().Before(stored.ExpiresAt) {
+		return cloneIdempotent(stored), nil
+	}
+	m.idempotent[r.Key] = cloneIdempotent(r)
+	return nil, nil
+}
+
+//GetIdempotent returns a copy of the response stored for the key
+//ErrIdempotencyNotFound if there is none or it expired
+func (m *Memory) GetIdempotent(ctx context.Context, key string) (*IdempotentResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.idempotent[key]
+	if !ok || !time.Now().Before(r.ExpiresAt) {
+		return nil, ErrIdempotencyNotFound
+	}
+	return cloneIdempotent(r), nil
+}
+
+//SaveIdempotent stores a copy of the response and deletes the expired ones
+func (m *Memory) SaveIdempotent(ctx context.Context, r *IdempotentResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for key, stored := range m.idempotent {
+		if !now.Before(stored.ExpiresAt) {
+			delete(m.idempotent, key)
+		}
+	}
+	m.idempotent[r.Key] = cloneIdempotent(r)
+	return nil
+}
+
+//ReleaseIdempotencyKey deletes the marker of the key, the stored response is kept
+func (m *Memory) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.idempotent[key]; ok && r.Pending() {
+		delete(m.idempotent, key)
+	}
+	return nil
+}
+
+//memoryScope returns the scope of ctx, or the error of ctx if it is done
+func memoryScope(ctx context.Context) (Scope, error) {
+	if err := ctx.Err(); err != nil {
+		return Scope{}, err
+	}
+	return ScopeFrom(ctx)
+}
+
+//find returns the payment with the id if its organisation is in the scope.
+//The caller must hold the lock.
+func (m *Memory) find(s Scope, id string) (*model.Payment, bool) {
+	payment, ok := m.payments[id]
+	if !ok || !s.Allows(payment.OrganisationID) {
+		return nil, false
+	}
+	return payment, true
+}
+
+//cloneKey copies the slices of the key so the stored keys can not be modified by the callers
+func cloneKey(k *model.APIKey) *model.APIKey {
+	c := *k
+	c.Scopes = append(c.Scopes[:0:0], c.Scopes...)
+	c.Salt = append(c.Salt[:0:0], c.Salt...)
+	c.Hash = append(c.Hash[:0:0], c.Hash...)
+	for _, t := range []**time.Time{&c.RotatedAt, &c.RevokedAt} {
+		if *t != nil {
+			copied := **t
+			*t = &copied
+		}
+	}
+	return &c
+}
+
+//clonePayment copies the slices of the payment so the stored payments can not be modified by the callers
+func clonePayment(p *model.Payment) *model.Payment {
+	c := *p
+	charges := &c.Attributes.ChargesInformation
+	charges.SenderCharges = append(charges.SenderCharges[:0:0], charges.SenderCharges...)
+	c.StatusHistory = append(c.StatusHistory[:0:0], c.StatusHistory...)
+	c.UpdatedBy = append(c.UpdatedBy[:0:0], c.UpdatedBy...)
+	c.Approvals = append(c.Approvals[:0:0], c.Approvals...)
+	if c.DeletedAt != nil {
+		deletedAt := *c.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	return &c
+}
+
+func cloneSubscription(sub *model.Subscription) *model.Subscription {
+	c := *sub
+	c.EventTypes = append(c.EventTypes[:0:0], c.EventTypes...)
+	return &c
+}
+
+func cloneDelivery(d *model.Delivery) *model.Delivery {
+	c := *d
+	c.Payload = append(c.Payload[:0:0], c.Payload...)
+	if c.DeliveredAt != nil {
+		deliveredAt := *c.DeliveredAt
+		c.DeliveredAt = &deliveredAt
+	}
+	return &c
+}
+
+func cloneAuditEntry(e *AuditEntry) *AuditEntry {
+	c := *e
+	if c.Before != nil {
+		c.Before = clonePayment(c.Before)
+	}
+	if c.After != nil {
+		c.After = clonePayment(c.After)
+	}
+	return &c
+}
+
+func cloneIdempotent(r *IdempotentResponse) *IdempotentResponse {
+	c := *r
+	c.RequestHash = append(c.RequestHash[:0:0], c.RequestHash...)
+	c.Body = append(c.Body[:0:0], c.Body...)
+	c.Header = r.Header.Clone()
+	return &c
+}
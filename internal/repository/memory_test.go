@@ -0,0 +1,451 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemory_Create_UPDATE_GET_DELETE(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	m := NewMemory()
+
+	err := m.Create(ctx, &model.Payment{ID: "1", OrganisationID: "1"})
+	assert.Nil(t, err)
+	err = m.Create(ctx, &model.Payment{ID: "1"})
+	assert.Equal(t, ErrAlreadyExists, err)
+
+	p1, err := m.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", p1.OrganisationID)
+
+	p1.OrganisationID = "2"
+	err = m.Update(ctx, p1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, p1.Version)
+
+	p2, err := m.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "2", p2.OrganisationID)
+
+	p2.Version = 0
+	err = m.Update(ctx, p2)
+	assert.Equal(t, &VersionConflictError{Current: 1}, err)
+
+	assert.Equal(t, &VersionConflictError{Current: 1}, m.Delete(ctx, "1", 0))
+	err = m.Delete(ctx, "1", 1)
+	assert.Nil(t, err)
+	p3, err := m.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.True(t, p3.Deleted(), "the deleted payment should be kept")
+	assert.Equal(t, 2, p3.Version)
+	assert.Equal(t, ErrNotFound, m.Delete(ctx, "1", AnyVersion))
+	assert.Equal(t, ErrNotFound, m.Update(ctx, p3))
+	_, err = m.List(ctx, ListQuery{Sort: DefaultSort, Limit: 10})
+	assert.Equal(t, ErrNotFound, err, "the deleted payment should not be listed")
+	ps, err := m.List(ctx, ListQuery{Filter: Filter{IncludeDeleted: true}, Sort: DefaultSort, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ps))
+}
+
+func TestMemory_RestoreAndPurge(t *testing.T) {
+	ctx := WithActor(WithScope(context.Background(), Scope{Organisations: []string{"1"}}), Actor{Subject: "alice"})
+	m := NewMemory()
+
+	assert.Nil(t, m.Create(ctx, &model.Payment{ID: "1", OrganisationID: "1"}))
+	assert.Nil(t, m.Create(ctx, &model.Payment{ID: "2", OrganisationID: "1"}))
+	_, err := m.Restore(ctx, "1")
+	assert.Equal(t, ErrNotDeleted, err)
+	_, err = m.Restore(ctx, "3")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, m.Delete(ctx, "1", AnyVersion))
+	deleted, _ := m.Get(ctx, "1")
+	assert.Equal(t, "alice", deleted.DeletedBy)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	restored, err := m.Restore(ctx, "1")
+	assert.Nil(t, err)
+	assert.False(t, restored.Deleted())
+	assert.Equal(t, 2, restored.Version)
+	assert.Nil(t, m.Update(ctx, restored))
+
+	assert.Nil(t, m.Delete(ctx, "1", AnyVersion))
+	assert.Nil(t, m.Delete(ctx, "2", AnyVersion))
+	n, err := m.Purge(ctx, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "the payments deleted after the time should be kept")
+	n, err = m.Purge(WithScope(context.Background(), Scope{Organisations: []string{"2"}}), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "the payments of another organisation should be kept")
+	n, err = m.Purge(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	_, err = m.Get(ctx, "1")
+	assert.Equal(t, ErrNotFound, err)
+
+	entries, _ := m.History(ctx, "1")
+	actions := []AuditAction{AuditCreate, AuditDelete, AuditRestore, AuditUpdate, AuditDelete, AuditPurge}
+	if assert.Equal(t, len(actions), len(entries)) {
+		for i, e := range entries {
+			assert.Equal(t, actions[i], e.Action)
+		}
+		assert.Nil(t, entries[5].Before, "the purge should not copy the payment removed")
+		assert.Nil(t, entries[5].After)
+		assert.Equal(t, "1", entries[5].PaymentID)
+	}
+}
+
+func TestMemory_History(t *testing.T) {
+	ctx := WithActor(WithScope(context.Background(), Scope{Organisations: []string{"1"}}), Actor{Subject: "alice", RequestID: "r1"})
+	m := NewMemory()
+
+	_, err := m.History(ctx, "1")
+	assert.Equal(t, ErrNotFound, err)
+
+	p := &model.Payment{ID: "1", OrganisationID: "1", Status: model.StatusDraft}
+	assert.Nil(t, m.Create(ctx, p))
+	p.Status = model.StatusSubmitted
+	assert.Nil(t, m.Update(ctx, p))
+	p.Attributes.Reference = "changed"
+	assert.Nil(t, m.Update(ctx, p))
+	assert.Nil(t, m.Delete(ctx, "1", AnyVersion))
+
+	entries, err := m.History(ctx, "1")
+	assert.Nil(t, err)
+	if assert.Equal(t, 4, len(entries)) {
+		actions := []AuditAction{AuditCreate, AuditStatus, AuditUpdate, AuditDelete}
+		for i, e := range entries {
+			assert.Equal(t, int64(i+1), e.ID)
+			assert.Equal(t, actions[i], e.Action)
+			assert.Equal(t, "alice", e.Actor)
+			assert.Equal(t, "r1", e.RequestID)
+			assert.False(t, e.At.IsZero())
+		}
+		assert.Nil(t, entries[0].Before)
+		assert.Equal(t, model.StatusDraft, entries[1].Before.Status)
+		assert.Equal(t, model.StatusSubmitted, entries[1].After.Status)
+		assert.Equal(t, "", entries[2].Before.Attributes.Reference)
+		assert.Equal(t, "changed", entries[2].After.Attributes.Reference)
+		assert.Equal(t, 2, entries[3].Before.Version)
+		assert.True(t, entries[3].After.Deleted())
+	}
+
+	//the entries are copies
+	entries[2].After.Attributes.Reference = "other"
+	entries, _ = m.History(ctx, "1")
+	assert.Equal(t, "changed", entries[2].After.Attributes.Reference)
+
+	_, err = m.History(WithScope(context.Background(), Scope{Organisations: []string{"2"}}), "1")
+	assert.Equal(t, ErrNotFound, err, "the history of another organisation should not be found")
+}
+
+func TestMemory_CopiesPayments(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	m := NewMemory()
+
+	p := &model.Payment{ID: "1", StatusHistory: []model.StatusTransition{{To: model.StatusDraft}}}
+	assert.Nil(t, m.Create(ctx, p))
+	p.StatusHistory[0].To = model.StatusSettled
+
+	stored, err := m.Get(ctx, "1")
+	assert.Nil(t, err)
+	assert.Equal(t, model.StatusDraft, stored.StatusHistory[0].To, "the stored payment should not change with the caller's")
+}
+
+func TestMemory_List(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	m := NewMemory()
+
+	_, err := m.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 1})
+	assert.Equal(t, ErrNotFound, err)
+
+	for _, id := range []string{"1", "3", "2"} {
+		assert.Nil(t, m.Create(ctx, &model.Payment{ID: id}))
+	}
+	ts, err := m.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"3", "2"}, ids(ts))
+
+	ts, err = m.List(ctx, ListQuery{Sort: DefaultSort, Offset: 2, Limit: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1"}, ids(ts))
+
+	_, err = m.List(ctx, ListQuery{Sort: DefaultSort, Offset: 3, Limit: 5})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemory_Scope(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2", "3"}})
+	m := NewMemory()
+
+	assert.Equal(t, ErrOutOfScope, m.Create(other, &model.Payment{ID: "1", OrganisationID: "1"}))
+	assert.Nil(t, m.Create(ctx, &model.Payment{ID: "1", OrganisationID: "1"}))
+	assert.Nil(t, m.Create(other, &model.Payment{ID: "2", OrganisationID: "2"}))
+
+	//the payments of the other organisations are not found
+	_, err := m.Get(other, "1")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, m.Update(other, &model.Payment{ID: "1", OrganisationID: "2"}))
+	assert.Equal(t, ErrNotFound, m.Delete(other, "1", AnyVersion))
+	assert.Equal(t, ErrOutOfScope, m.Update(ctx, &model.Payment{ID: "1", OrganisationID: "2"}))
+	ts, err := m.List(other, ListQuery{Sort: DefaultSort, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2"}, ids(ts))
+
+	_, err = m.Get(context.Background(), "1")
+	assert.Equal(t, ErrNoScope, err, "the access should be denied without a scope")
+	_, err = m.Get(WithScope(context.Background(), Scope{}), "1")
+	assert.Equal(t, ErrNoScope, err, "the access should be denied with an empty scope")
+
+	ts, err = m.List(WithSystemScope(context.Background()), ListQuery{Sort: DefaultSort, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2", "1"}, ids(ts))
+}
+
+func TestMemory_Keys(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	m := NewMemory()
+
+	k := &model.APIKey{ID: "k1", OrganisationID: "1", Scopes: []string{"read"}, Hash: []byte("hash"), CreatedAt: time.Unix(2, 0)}
+	assert.Equal(t, ErrOutOfScope, m.CreateKey(other, k))
+	assert.Nil(t, m.CreateKey(ctx, k))
+	assert.Equal(t, ErrAlreadyExists, m.CreateKey(ctx, k))
+	assert.Nil(t, m.CreateKey(ctx, &model.APIKey{ID: "k0", OrganisationID: "1", CreatedAt: time.Unix(1, 0)}))
+	k.Scopes[0] = "admin"
+
+	stored, err := m.GetKey(ctx, "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"read"}, stored.Scopes, "the stored key should not change with the caller's")
+	_, err = m.GetKey(other, "k1")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ks, err := m.ListKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ks))
+	assert.Equal(t, "k0", ks[0].ID)
+	ks, err = m.ListKeys(other)
+	assert.Nil(t, err)
+	assert.Empty(t, ks)
+
+	//only the secret, the rotation and the revocation are updated
+	now := time.Now()
+	stored.Name, stored.Hash, stored.RevokedAt = "renamed", []byte("rotated"), &now
+	assert.Equal(t, ErrKeyNotFound, m.UpdateKey(other, stored))
+	assert.Nil(t, m.UpdateKey(ctx, stored))
+	updated, err := m.GetKey(ctx, "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, "", updated.Name)
+	assert.Equal(t, []byte("rotated"), updated.Hash)
+	assert.True(t, updated.Revoked())
+}
+
+func TestMemory_Changes(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	m := NewMemory()
+
+	p := &model.Payment{ID: "1", OrganisationID: "1"}
+	assert.Nil(t, m.Create(ctx, p))
+	assert.Nil(t, m.Create(other, &model.Payment{ID: "2", OrganisationID: "2"}))
+	assert.Nil(t, m.Update(ctx, p))
+	p.Attributes.Reference = "changed"
+
+	changes, err := m.Changes(ctx, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(changes)) {
+		assert.Equal(t, int64(1), changes[0].ID)
+		assert.Equal(t, int64(3), changes[1].ID)
+		assert.Equal(t, EventUpdated, changes[1].Type)
+		assert.Equal(t, "", changes[1].Payment.Attributes.Reference, "the events should not change with the caller's payment")
+	}
+	changes, err = m.Changes(ctx, 1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(changes))
+	changes, err = m.Changes(ctx, 0, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(changes))
+	changes, err = m.Changes(other, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(changes))
+}
+
+func TestMemory_Webhooks(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	system := WithSystemScope(context.Background())
+	m := NewMemory()
+
+	sub := &model.Subscription{ID: "s1", OrganisationID: "1", URL: "http://example.com", EventTypes: []string{"payment.created"}, Secret: "secret"}
+	assert.Equal(t, ErrOutOfScope, m.CreateSubscription(other, sub))
+	assert.Nil(t, m.CreateSubscription(ctx, sub))
+	assert.Equal(t, ErrAlreadyExists, m.CreateSubscription(ctx, sub))
+	sub.EventTypes[0] = "payment.deleted"
+	stored, err := m.GetSubscription(ctx, "s1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"payment.created"}, stored.EventTypes, "the stored subscription should not change with the caller's")
+	_, err = m.GetSubscription(other, "s1")
+	assert.Equal(t, ErrSubscriptionNotFound, err)
+
+	now := time.Now().UTC()
+	ds := []model.Delivery{
+		{ID: "d2", SubscriptionID: "s1", OrganisationID: "1", EventID: 2, Status: model.DeliveryPending, NextAttemptAt: now},
+		{ID: "d1", SubscriptionID: "s1", OrganisationID: "1", EventID: 1, Status: model.DeliveryPending, NextAttemptAt: now},
+		{ID: "d3", SubscriptionID: "s1", OrganisationID: "1", EventID: 3, Status: model.DeliveryPending, NextAttemptAt: now.Add(time.Hour)},
+	}
+	assert.Equal(t, ErrOutOfScope, m.CreateDeliveries(other, ds))
+	assert.Nil(t, m.CreateDeliveries(ctx, ds))
+	//a delivery of the same subscription and event is skipped
+	assert.Nil(t, m.CreateDeliveries(ctx, []model.Delivery{{ID: "d4", SubscriptionID: "s1", OrganisationID: "1", EventID: 1, Status: model.DeliveryPending}}))
+	_, err = m.GetDelivery(ctx, "d4")
+	assert.Equal(t, ErrDeliveryNotFound, err)
+
+	//the deliveries due are claimed in the order of their events, and not again until the lease expires
+	claimed, err := m.ClaimDeliveries(system, now, time.Minute, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(claimed)) {
+		assert.Equal(t, "d1", claimed[0].ID)
+		assert.Equal(t, "d2", claimed[1].ID)
+	}
+	claimed, err = m.ClaimDeliveries(system, now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, claimed)
+	claimed, err = m.ClaimDeliveries(system, now.Add(2*time.Hour), time.Minute, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(claimed))
+
+	d, err := m.GetDelivery(ctx, "d1")
+	assert.Nil(t, err)
+	d.Status, d.Attempts, d.LastError = model.DeliveryDead, 8, "unavailable"
+	assert.Equal(t, ErrDeliveryNotFound, m.UpdateDelivery(other, d))
+	assert.Nil(t, m.UpdateDelivery(ctx, d))
+	dead, err := m.ListDeliveries(ctx, model.DeliveryDead)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(dead)) {
+		assert.Equal(t, 8, dead[0].Attempts)
+		assert.Equal(t, "unavailable", dead[0].LastError)
+	}
+	dead, err = m.ListDeliveries(other, model.DeliveryDead)
+	assert.Nil(t, err)
+	assert.Empty(t, dead)
+
+	//the deliveries are deleted with their subscription
+	assert.Equal(t, ErrSubscriptionNotFound, m.DeleteSubscription(other, "s1"))
+	assert.Nil(t, m.DeleteSubscription(ctx, "s1"))
+	subs, err := m.ListSubscriptions(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, subs)
+	_, err = m.GetDelivery(ctx, "d1")
+	assert.Equal(t, ErrDeliveryNotFound, err)
+}
+
+func TestMemory_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	_, err := m.GetIdempotent(ctx, "k1")
+	assert.Equal(t, ErrIdempotencyNotFound, err)
+
+	r := &IdempotentResponse{Key: "k1", RequestHash: []byte("hash"), Status: 201, Header: http.Header{"Etag": {"1"}}, Body: []byte("body"), ExpiresAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, m.SaveIdempotent(ctx, r))
+	r.Body[0], r.Header["Etag"][0] = 'B', "2"
+	stored, err := m.GetIdempotent(ctx, "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("body"), stored.Body, "the stored response should not change with the caller's")
+	assert.Equal(t, "1", stored.Header.Get("Etag"))
+
+	r.ExpiresAt = time.Now().Add(-time.Second)
+	assert.Nil(t, m.SaveIdempotent(ctx, r))
+	_, err = m.GetIdempotent(ctx, "k1")
+	assert.Equal(t, ErrIdempotencyNotFound, err)
+}
+
+func TestMemory_ClaimIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+
+	claim := &IdempotentResponse{Key: "k1", RequestHash: []byte("hash"), ExpiresAt: time.Now().Add(time.Hour)}
+	stored, err := m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, stored, "the first request should claim the key")
+
+	//the other requests get the marker until the response is stored
+	stored, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.True(t, stored.Pending())
+	r := &IdempotentResponse{Key: "k1", RequestHash: []byte("hash"), Status: 201, ExpiresAt: time.Now().Add(time.Hour)}
+	assert.Nil(t, m.SaveIdempotent(ctx, r))
+	assert.Nil(t, m.ReleaseIdempotencyKey(ctx, "k1"), "a stored response should not be released")
+	stored, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Equal(t, 201, stored.Status)
+
+	//a released or expired marker can be claimed again
+	claim.Key = "k2"
+	_, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, m.ReleaseIdempotencyKey(ctx, "k2"))
+	stored, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	claim.Key, claim.ExpiresAt = "k3", time.Now().Add(-time.Second)
+	_, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	stored, err = m.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+}
+
+func TestMemory_CancelledContext(t *testing.T) {
+	m := NewMemory()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, m.Create(ctx, &model.Payment{ID: "1"}))
+	assert.Equal(t, context.Canceled, m.Ping(ctx))
+	assert.Nil(t, m.Ping(context.Background()))
+}
+
+func TestMemory_Concurrent(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	m := NewMemory()
+	assert.Nil(t, m.Create(ctx, &model.Payment{ID: "shared"}))
+
+	var wg sync.WaitGroup
+	conflicts := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(t, m.Create(ctx, &model.Payment{ID: fmt.Sprint(i)}))
+			if err := m.Update(ctx, &model.Payment{ID: "shared"}); err != nil {
+				conflicts <- err
+			}
+			_, _ = m.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 100})
+		}(i)
+	}
+	wg.Wait()
+	close(conflicts)
+
+	//only one of the updates of version 0 can succeed
+	assert.Equal(t, 9, len(conflicts))
+	shared, err := m.Get(ctx, "shared")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, shared.Version)
+}
+
+func ids(ts []model.Payment) []string {
+	var ids []string
+	for _, t := range ts {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//migrationFiles contains the migrations, named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationPattern = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+//migrationLock is the key of the postgres advisory lock held while a migration runs
+const migrationLock = 7303
+
+//Migration is a versioned change of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+//MigrationState is a migration and when it was applied
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+//SchemaBehindError is returned by CheckSchema when there are migrations not applied
+type SchemaBehindError struct {
+	Current int
+	Latest  int
+}
+
+func (e *SchemaBehindError) Error() string {
+	return fmt.Sprintf("the schema is at version %d and the latest migration is %d", e.Current, e.Latest)
+}
+
+//Migrations returns the migrations embedded in the binary ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		sql, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+//MigrateUp applies the migrations that are not in the schema_migrations table, in order.
+//Each migration runs in its own transaction. Returns the migrations applied.
+func (d *Repository) MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	for _, m := range migrations {
+		m := m
+		ran := false
+		err := d.inMigration(ctx, func(tx *pg.Tx, current map[int]time.Time) error {
+			if _, ok := current[m.Version]; ok {
+				return nil
+			}
+			if _, err := tx.Exec(m.Up); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+			}
+			_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+			ran = err == nil
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		if ran {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+//MigrateDown reverts the last steps migrations applied, the latest first. Returns the migrations reverted.
+func (d *Repository) MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := 0; i < steps; i++ {
+		var m *Migration
+		err := d.inMigration(ctx, func(tx *pg.Tx, current map[int]time.Time) error {
+			latest := latestVersion(current)
+			if latest == 0 {
+				return nil
+			}
+			for j := range migrations {
+				if migrations[j].Version == latest {
+					m = &migrations[j]
+				}
+			}
+			if m == nil {
+				return fmt.Errorf("migration %d is applied but not known by this version of the app", latest)
+			}
+			if _, err := tx.Exec(m.Down); err != nil {
+				return fmt.Errorf("migration %d_%s: %v", m.Version, m.Name, err)
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, err
+		}
+		if m == nil {
+			break
+		}
+		reverted = append(reverted, *m)
+	}
+	return reverted, nil
+}
+
+//MigrationStatus returns every migration and if it was applied
+func (d *Repository) MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	current, err := d.appliedMigrations(d.withContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		at, ok := current[m.Version]
+		states[i] = MigrationState{Migration: m, Applied: ok, AppliedAt: at}
+	}
+	return states, nil
+}
+
+//CheckSchema returns a *SchemaBehindError if any of the migrations was not applied
+func (d *Repository) CheckSchema(ctx context.Context) error {
+	states, err := d.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	current := map[int]time.Time{}
+	latest := 0
+	behind := false
+	for _, s := range states {
+		latest = s.Version
+		if s.Applied {
+			current[s.Version] = s.AppliedAt
+			continue
+		}
+		behind = true
+	}
+	if behind {
+		return &SchemaBehindError{Current: latestVersion(current), Latest: latest}
+	}
+	return nil
+}
+
+//inMigration runs fn in a transaction that holds the migration lock, with the versions applied and when
+func (d *Repository) inMigration(ctx context.Context, fn func(tx *pg.Tx, current map[int]time.Time) error) error {
+	return d.withContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		//another instance of the app may be migrating at the same time
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLock); err != nil {
+			return err
+		}
+		current, err := d.appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+		return fn(tx, current)
+	})
+}
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    bigint      PRIMARY KEY,
+    name       text        NOT NULL,
+    applied_at timestamptz NOT NULL DEFAULT now()
+)`
+
+//querier is implemented by pg.DB and pg.Tx
+type querier interface {
+	Exec(query interface{}, params ...interface{}) (orm.Result, error)
+	Query(model, query interface{}, params ...interface{}) (orm.Result, error)
+}
+
+//appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Version   int
+	AppliedAt time.Time
+}
+
+//appliedMigrations returns the versions in the schema_migrations table and when they were applied
+func (d *Repository) appliedMigrations(q querier) (map[int]time.Time, error) {
+	if _, err := q.Exec(createMigrationsTable); err != nil {
+		return nil, err
+	}
+	var rows []appliedMigration
+	if _, err := q.Query(&rows, "SELECT version, applied_at FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	current := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		current[r.Version] = r.AppliedAt
+	}
+	return current, nil
+}
+
+func latestVersion(current map[int]time.Time) int {
+	latest := 0
+	for v := range current {
+		if v > latest {
+			latest = v
+		}
+	}
+	return latest
+}
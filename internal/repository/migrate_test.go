@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	assert.Nil(t, err)
+	assert.True(t, len(migrations) >= 2)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "the versions should be consecutive")
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+	assert.Equal(t, "create_payments", migrations[0].Name)
+}
+
+func TestDatabase_Migrate(t *testing.T) {
+	ctx := context.Background()
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	migrations, _ := Migrations()
+
+	_, err := dbTest.MigrateUp(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, dbTest.CheckSchema(ctx))
+
+	reverted, err := dbTest.MigrateDown(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []Migration{migrations[len(migrations)-1]}, reverted)
+	err = dbTest.CheckSchema(ctx)
+	assert.Equal(t, &SchemaBehindError{Current: len(migrations) - 1, Latest: len(migrations)}, err)
+
+	states, err := dbTest.MigrationStatus(ctx)
+	assert.Nil(t, err)
+	assert.True(t, states[0].Applied)
+	assert.False(t, states[len(states)-1].Applied)
+
+	applied, err := dbTest.MigrateUp(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []Migration{migrations[len(migrations)-1]}, applied)
+
+	applied, err = dbTest.MigrateUp(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, applied, "the migrations applied should not run again")
+	assert.Nil(t, dbTest.CheckSchema(ctx))
+}
@@ -0,0 +1,9 @@
+-- the table may exist if it was created by the CreateTable of the versions without migrations
+CREATE TABLE IF NOT EXISTS payments (
+    type            text   NOT NULL,
+    id              text   NOT NULL,
+    version         bigint NOT NULL,
+    organisation_id text   NOT NULL,
+    attributes      jsonb  NOT NULL,
+    PRIMARY KEY (id)
+);
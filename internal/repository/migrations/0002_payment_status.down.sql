@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    DROP COLUMN status,
+    DROP COLUMN status_history;
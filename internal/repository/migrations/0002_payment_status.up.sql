@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    ADD COLUMN IF NOT EXISTS status         text NOT NULL DEFAULT 'draft',
+    ADD COLUMN IF NOT EXISTS status_history jsonb;
@@ -0,0 +1,2 @@
+DROP INDEX payments_processing_date_idx;
+DROP INDEX payments_organisation_id_idx;
@@ -0,0 +1,3 @@
+-- indexes of the filters and the sorts of the payments listing most used
+CREATE INDEX payments_organisation_id_idx ON payments (organisation_id, id);
+CREATE INDEX payments_processing_date_idx ON payments ((attributes->>'processing_date'), id);
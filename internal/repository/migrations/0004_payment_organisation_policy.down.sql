@@ -0,0 +1,3 @@
+DROP POLICY payments_organisation ON payments;
+ALTER TABLE payments NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE payments DISABLE ROW LEVEL SECURITY;
@@ -0,0 +1,14 @@
+-- row level security scopes every query to the organisations set by the repository in the transaction,
+-- it backs up the conditions of the queries. FORCE applies the policy to the owner of the table too,
+-- only the superusers and the roles with BYPASSRLS are not checked.
+ALTER TABLE payments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payments FORCE ROW LEVEL SECURITY;
+CREATE POLICY payments_organisation ON payments
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
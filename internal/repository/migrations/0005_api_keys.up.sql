@@ -0,0 +1,26 @@
+-- the keys only keep the salted hash of their secret
+CREATE TABLE api_keys (
+    id              text        PRIMARY KEY,
+    organisation_id text        NOT NULL,
+    name            text        NOT NULL,
+    scopes          text[]      NOT NULL,
+    salt            bytea       NOT NULL,
+    hash            bytea       NOT NULL,
+    created_at      timestamptz NOT NULL,
+    rotated_at      timestamptz,
+    revoked_at      timestamptz
+);
+CREATE INDEX api_keys_organisation_id_idx ON api_keys (organisation_id);
+
+-- the same policy as the payments, see 0004_payment_organisation_policy
+ALTER TABLE api_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE api_keys FORCE ROW LEVEL SECURITY;
+CREATE POLICY api_keys_organisation ON api_keys
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
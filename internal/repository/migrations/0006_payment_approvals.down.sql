@@ -0,0 +1,3 @@
+ALTER TABLE payments
+    DROP COLUMN created_by,
+    DROP COLUMN approvals;
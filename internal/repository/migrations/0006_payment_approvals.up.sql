@@ -0,0 +1,4 @@
+-- the maker of a payment and the decisions of its approvers, null for the payments made before
+ALTER TABLE payments
+    ADD COLUMN created_by text,
+    ADD COLUMN approvals  jsonb;
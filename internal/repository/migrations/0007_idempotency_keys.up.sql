@@ -0,0 +1,12 @@
+-- the responses of the requests with an Idempotency-Key header, the key is prefixed by the caller
+CREATE TABLE idempotency_keys (
+    key          text        NOT NULL,
+    request_hash bytea       NOT NULL,
+    status       integer     NOT NULL,
+    header       jsonb,
+    body         bytea,
+    created_at   timestamptz NOT NULL,
+    expires_at   timestamptz NOT NULL,
+    PRIMARY KEY (key)
+);
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,2 @@
+DROP TABLE payment_audit;
+DROP FUNCTION payment_audit_append_only();
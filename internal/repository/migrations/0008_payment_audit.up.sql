@@ -0,0 +1,35 @@
+-- the history of the changes of the payments, written in the transaction of the change
+CREATE TABLE payment_audit (
+    id              bigserial   PRIMARY KEY,
+    payment_id      text        NOT NULL,
+    organisation_id text        NOT NULL,
+    action          text        NOT NULL,
+    actor           text,
+    request_id      text,
+    at              timestamptz NOT NULL,
+    before          jsonb,
+    after           jsonb
+);
+CREATE INDEX payment_audit_payment_id_idx ON payment_audit (payment_id, id);
+
+-- the entries are append-only, they can not be changed or deleted once written
+CREATE FUNCTION payment_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'payment_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER payment_audit_append_only BEFORE UPDATE OR DELETE ON payment_audit
+    FOR EACH ROW EXECUTE PROCEDURE payment_audit_append_only();
+
+-- the same policy as the payments, see 0004_payment_organisation_policy
+ALTER TABLE payment_audit ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payment_audit FORCE ROW LEVEL SECURITY;
+CREATE POLICY payment_audit_organisation ON payment_audit
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
@@ -0,0 +1,4 @@
+DROP INDEX payments_deleted_at_idx;
+ALTER TABLE payments
+    DROP COLUMN deleted_at,
+    DROP COLUMN deleted_by;
@@ -0,0 +1,5 @@
+-- the deletion of a payment, kept until it is purged, null for the payments not deleted
+ALTER TABLE payments
+    ADD COLUMN deleted_at timestamptz,
+    ADD COLUMN deleted_by text;
+CREATE INDEX payments_deleted_at_idx ON payments (deleted_at) WHERE deleted_at IS NOT NULL;
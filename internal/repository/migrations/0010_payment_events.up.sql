@@ -0,0 +1,26 @@
+-- the outbox of the domain events of the payments, written in the transaction of the change and published in the order of their ids
+CREATE TABLE payment_events (
+    id              bigserial   PRIMARY KEY,
+    type            text        NOT NULL,
+    payment_id      text        NOT NULL,
+    organisation_id text        NOT NULL,
+    occurred_at     timestamptz NOT NULL,
+    payment         jsonb,
+    published_at    timestamptz,
+    attempts        integer     NOT NULL DEFAULT 0,
+    last_error      text
+);
+CREATE INDEX payment_events_pending_idx ON payment_events (id) WHERE published_at IS NULL;
+
+-- the same policy as the payments, see 0004_payment_organisation_policy
+ALTER TABLE payment_events ENABLE ROW LEVEL SECURITY;
+ALTER TABLE payment_events FORCE ROW LEVEL SECURITY;
+CREATE POLICY payment_events_organisation ON payment_events
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
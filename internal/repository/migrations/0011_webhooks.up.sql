@@ -0,0 +1,53 @@
+-- the webhooks of the organisations, the secret signs the deliveries
+CREATE TABLE webhook_subscriptions (
+    id              text        PRIMARY KEY,
+    organisation_id text        NOT NULL,
+    url             text        NOT NULL,
+    event_types     text[],
+    secret          text        NOT NULL,
+    created_at      timestamptz NOT NULL
+);
+CREATE INDEX webhook_subscriptions_organisation_id_idx ON webhook_subscriptions (organisation_id);
+
+-- the events to post to the webhooks with their attempts, the dead ones are kept until they are replayed
+CREATE TABLE webhook_deliveries (
+    id              text        PRIMARY KEY,
+    subscription_id text        NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    organisation_id text        NOT NULL,
+    event_id        bigint      NOT NULL,
+    event_type      text        NOT NULL,
+    status          text        NOT NULL,
+    payload         bytea       NOT NULL,
+    attempts        integer     NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    last_error      text,
+    created_at      timestamptz NOT NULL,
+    delivered_at    timestamptz,
+    UNIQUE (subscription_id, event_id)
+);
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_status_idx ON webhook_deliveries (organisation_id, status, created_at);
+
+-- the same policy as the payments, see 0004_payment_organisation_policy
+ALTER TABLE webhook_subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_subscriptions FORCE ROW LEVEL SECURITY;
+CREATE POLICY webhook_subscriptions_organisation ON webhook_subscriptions
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_deliveries FORCE ROW LEVEL SECURITY;
+CREATE POLICY webhook_deliveries_organisation ON webhook_deliveries
+    USING (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    )
+    WITH CHECK (
+        current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+    );
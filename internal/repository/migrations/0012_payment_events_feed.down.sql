@@ -0,0 +1 @@
+DROP INDEX payment_events_organisation_idx;
@@ -0,0 +1,2 @@
+-- the change feed reads the events of an organisation after a cursor, in the order of their ids
+CREATE INDEX payment_events_organisation_idx ON payment_events (organisation_id, id);
@@ -0,0 +1,2 @@
+-- the publisher claims the events until claimed_until, commits, then publishes them outside of a transaction
+ALTER TABLE payment_events ADD COLUMN claimed_until timestamptz;
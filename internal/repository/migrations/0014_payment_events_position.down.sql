@@ -0,0 +1,5 @@
+DROP INDEX payment_events_organisation_idx;
+CREATE INDEX payment_events_organisation_idx ON payment_events (organisation_id, id);
+DROP INDEX payment_events_unpositioned_idx;
+DROP INDEX payment_events_position_idx;
+ALTER TABLE payment_events DROP COLUMN position;
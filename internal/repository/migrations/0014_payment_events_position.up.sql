@@ -0,0 +1,11 @@
+-- the position of an event in the change feed, given by the publisher in the order the events become visible.
+-- The ids are given in the order of the inserts, not of the commits, so the feed can not be read in the order of the ids.
+ALTER TABLE payment_events ADD COLUMN position bigint;
+-- the policy hides the events of the organisations from the migration too, see 0010_payment_events
+SELECT set_config('app.system', 'on', true);
+-- the cursors given before the positions have the ids of the events, they keep their place in the feed
+UPDATE payment_events SET position = id;
+CREATE UNIQUE INDEX payment_events_position_idx ON payment_events (position);
+CREATE INDEX payment_events_unpositioned_idx ON payment_events (id) WHERE position IS NULL;
+DROP INDEX payment_events_organisation_idx;
+CREATE INDEX payment_events_organisation_idx ON payment_events (organisation_id, position);
@@ -0,0 +1,3 @@
+-- the makers that changed a payment after its creation, null for the payments not changed
+ALTER TABLE payments
+    ADD COLUMN updated_by jsonb;
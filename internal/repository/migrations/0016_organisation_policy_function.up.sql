@@ -0,0 +1,31 @@
+-- the tenant predicate of the row level security policies, in one place: the rows of the organisations
+-- set by the repository in app.organisations, or every row when app.system is on.
+-- A sql function that is STABLE is inlined by the planner, the policies keep using the indexes.
+CREATE FUNCTION app_allows(organisation_id text) RETURNS boolean AS $$
+    SELECT current_setting('app.system', true) = 'on'
+        OR organisation_id = ANY (NULLIF(current_setting('app.organisations', true), '')::text[])
+$$ LANGUAGE sql STABLE;
+
+ALTER POLICY payments_organisation ON payments
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
+
+ALTER POLICY api_keys_organisation ON api_keys
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
+
+ALTER POLICY payment_audit_organisation ON payment_audit
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
+
+ALTER POLICY payment_events_organisation ON payment_events
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
+
+ALTER POLICY webhook_subscriptions_organisation ON webhook_subscriptions
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
+
+ALTER POLICY webhook_deliveries_organisation ON webhook_deliveries
+    USING (app_allows(organisation_id))
+    WITH CHECK (app_allows(organisation_id));
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"github.com/go-pg/pg"
+	"github.com/plusspeed/payments-api/internal/model"
+	"sort"
+	"time"
+)
+
+//EventType is the kind of change of a payment notified by an Event
+type EventType string
+
+//Types of the events, one for each AuditAction
+const (
+	EventCreated       EventType = "payment.created"
+	EventUpdated       EventType = "payment.updated"
+	EventStatusChanged EventType = "payment.status_changed"
+	EventDeleted       EventType = "payment.deleted"
+	EventRestored      EventType = "payment.restored"
+	EventPurged        EventType = "payment.purged"
+)
+
+//eventTypes maps the actions of the audit to the types of their events
+var eventTypes = map[AuditAction]EventType{
+	AuditCreate:  EventCreated,
+	AuditUpdate:  EventUpdated,
+	AuditStatus:  EventStatusChanged,
+	AuditDelete:  EventDeleted,
+	AuditRestore: EventRestored,
+	AuditPurge:   EventPurged,
+}
+
+//ValidEventType returns true if t is the type of an event
+func ValidEventType(t string) bool {
+	for _, et := range eventTypes {
+		if string(et) == t {
+			return true
+		}
+	}
+	return false
+}
+
+//eventsLock is the key of the postgres advisory lock held by the publisher of the events while it claims them,
+//so the events are published in order by one instance of the app at a time
+const eventsLock = 7305
+
+//eventsLease is how long the events claimed by a publisher are not claimed by another one.
+//When the publisher stops before it marks them, they are published again once the lease expires.
+const eventsLease = time.Minute
+
+//Event is a domain event of a payment in the outbox, written in the transaction of the change it notifies.
+//The events are published in the order of their ids, at least once.
+type Event struct {
+	tableName struct{} `sql:"payment_events"`
+
+	ID             int64     `json:"id"`
+	Type           EventType `json:"type" sql:",notnull"`
+	PaymentID      string    `json:"payment_id" sql:",notnull"`
+	OrganisationID string    `json:"organisation_id" sql:",notnull"`
+	OccurredAt     time.Time `json:"occurred_at" sql:",notnull"`
+	//Payment is the payment after the change, nil for a purge
+	Payment *model.Payment `json:"payment"`
+	//PublishedAt is set once the event is delivered, Attempts and LastError count the deliveries that failed
+	PublishedAt *time.Time `json:"-"`
+	Attempts    int        `json:"-" sql:",notnull"`
+	LastError   string     `json:"-"`
+	//ClaimedUntil is the end of the lease of the publisher that claimed the event
+	ClaimedUntil *time.Time `json:"-"`
+	//Position is the place of the event in the change feed, given once the event is committed
+	Position int64 `json:"-"`
+}
+
+//Outbox returns the events of the changes of the payments to publish.
+//Repository stores them in postgres and Memory in memory.
+type Outbox interface {
+	//PublishEvents passes up to limit events not published yet of the scope of ctx to publish, in order,
+	//and marks published the ones it delivered. It stops at the first error of publish and returns it,
+	//the event is passed again on the next call.
+	//Returns the number of events published.
+	PublishEvents(ctx context.Context, limit int, publish func(Event) error) (int, error)
+}
+
+//newEvent returns the event of the change recorded by the audit entry
+func newEvent(e *AuditEntry) *Event {
+	return &Event{
+		Type:           eventTypes[e.Action],
+		PaymentID:      e.PaymentID,
+		OrganisationID: e.OrganisationID,
+		OccurredAt:     e.At,
+		Payment:        e.After,
+	}
+}
+
+//recordChange inserts the audit entry and the event of a change in the transaction of the change.
+//The event has no position in the change feed yet, the publisher gives it one once it is committed.
+func recordChange(tx *pg.Tx, e *AuditEntry) error {
+	if err := tx.Insert(e); err != nil {
+		return err
+	}
+	return tx.Insert(newEvent(e))
+}
+
+//PublishEvents claims the events in a first transaction and commits, publishes them outside of a transaction,
+//then marks them in a second one, so the changes of the payments are not blocked by a slow delivery.
+//Returns 0 without publishing if another instance holds the lock or the lease of the events it claimed.
+func (d *Repository) PublishEvents(ctx context.Context, limit int, publish func(Event) error) (int, error) {
+	events, err := d.claimEvents(ctx, limit)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	published := 0
+	var publishErr error
+	for _, e := range events {
+		if publishErr = publish(e); publishErr != nil {
+			break
+		}
+		published++
+	}
+
+	err = d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		ids := make([]int64, len(events))
+		for i, e := range events {
+			ids[i] = e.ID
+		}
+		if published > 0 {
+			_, err := tx.Model((*Event)(nil)).Set("published_at = ?", time.Now().UTC()).Set("claimed_until = NULL").
+				Where("id IN (?)", pg.In(ids[:published])).Update()
+			if err != nil {
+				return err
+			}
+		}
+		if publishErr == nil {
+			return nil
+		}
+		//the failed event and the ones after it are claimed again, in order, on the next call
+		_, err := tx.Model((*Event)(nil)).Set("attempts = attempts + 1").Set("last_error = ?", publishErr.Error()).
+			Where("id = ?", ids[published]).Update()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Model((*Event)(nil)).Set("claimed_until = NULL").Where("id IN (?)", pg.In(ids[published:])).Update()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return published, publishErr
+}
+
+//assignPositions gives the next positions of the change feed to the events committed since the last call, in the order of their ids.
+//It runs under the lock of the publisher, one call at a time, so an event committed after another one with a greater id
+//still gets a greater position, and a reader of the feed past a position never misses an event.
+//The events of every organisation must be visible to the transaction.
+func assignPositions(tx *pg.Tx) error {
+	_, err := tx.Exec(`UPDATE payment_events e SET position = last.position + n.rank
+		FROM (SELECT id, row_number() OVER (ORDER BY id) AS rank FROM payment_events WHERE position IS NULL) n,
+			(SELECT coalesce(max(position), 0) AS position FROM payment_events) last
+		WHERE e.id = n.id`)
+	return err
+}
+
+//claimEvents returns up to limit events not published yet of the scope of ctx in order, and leases them to the caller until eventsLease.
+//Returns none if another instance holds the lock, or if events claimed by another publisher are still leased,
+//so the events after them are not published before them.
+//With the system scope it gives their positions in the change feed to the events committed since the last call.
+func (d *Repository) claimEvents(ctx context.Context, limit int) ([]Event, error) {
+	var events []Event
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		var locked bool
+		if _, err := tx.QueryOne(pg.Scan(&locked), "SELECT pg_try_advisory_xact_lock(?)", eventsLock); err != nil || !locked {
+			return err
+		}
+		if s.System {
+			if err := assignPositions(tx); err != nil {
+				return err
+			}
+		}
+		now := time.Now().UTC()
+		leased, err := scopeQuery(tx.Model((*Event)(nil)).Where("published_at IS NULL").Where("claimed_until > ?", now), s).Count()
+		if err != nil || leased > 0 {
+			return err
+		}
+		pending := scopeQuery(tx.Model((*Event)(nil)).Column("id").Where("published_at IS NULL"), s).Order("id ASC").Limit(limit)
+		_, err = tx.Model(&events).Set("claimed_until = ?", now.Add(eventsLease)).Where("id IN (?)", pending).Returning("*").Update()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"errors"
+	"github.com/plusspeed/payments-api/internal/model"
+	"strings"
+)
+
+//ErrInvalidSort is returned when the field of a Sort is not in the whitelist
+var ErrInvalidSort = errors.New("payments can not be sorted by the field")
+
+//SortField is a field the payments can be sorted by
+type SortField string
+
+//Fields of the sort whitelist
+const (
+	SortID             SortField = "id"
+	SortProcessingDate SortField = "processing_date"
+	SortAmount         SortField = "amount"
+	SortCurrency       SortField = "currency"
+	SortReference      SortField = "reference"
+)
+
+//sortColumns contains the sql expression of each field of the whitelist.
+//Only these expressions are added to the queries, the values are always bound as parameters.
+var sortColumns = map[SortField]string{
+	SortID:             "id",
+	SortProcessingDate: "attributes->>'processing_date'",
+	SortAmount:         "NULLIF(attributes->>'amount', '')::numeric",
+	SortCurrency:       "attributes->>'currency'",
+	SortReference:      "attributes->>'reference'",
+}
+
+//Sort is the order of a list of payments. Payments with the same value are ordered by id in the same direction.
+//The zero value orders by id asc.
+type Sort struct {
+	Field SortField
+	Desc  bool
+}
+
+//DefaultSort orders the payments by id desc
+var DefaultSort = Sort{Field: SortID, Desc: true}
+
+//Valid returns true if the field is in the whitelist
+func (s Sort) Valid() bool {
+	_, ok := sortColumns[s.field()]
+	return ok
+}
+
+func (s Sort) field() SortField {
+	if s.Field == "" {
+		return SortID
+	}
+	return s.Field
+}
+
+//Key returns the value of the sort field of the payment, kept in a Cursor
+func (s Sort) Key(p *model.Payment) string {
+	a := p.Attributes
+	switch s.field() {
+	case SortProcessingDate:
+		return a.ProcessingDate
+	case SortAmount:
+		return a.Amount.String()
+	case SortCurrency:
+		return a.Currency
+	case SortReference:
+		return a.Reference
+	}
+	return p.ID
+}
+
+//compare compares the payments by the sort field, then by id, in ascending order
+func (s Sort) compare(a, b *model.Payment) int {
+	if c := s.compareKey(s.Key(a), s.Key(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+//afterCursor returns true if the payment is after the cursor in the order, desc or not, like the seek condition of Repository.List
+func (s Sort) afterCursor(c Cursor, desc bool, p *model.Payment) bool {
+	cmp := s.compareKey(s.Key(p), c.Key)
+	if cmp == 0 {
+		cmp = strings.Compare(p.ID, c.ID)
+	}
+	if desc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+//compareKey compares two values of the sort field in ascending order
+func (s Sort) compareKey(a, b string) int {
+	if s.field() != SortAmount {
+		return strings.Compare(a, b)
+	}
+	//like NULLIF in postgres, the payments without an amount are not comparable and go last
+	x, errX := model.ParseDecimal(a)
+	y, errY := model.ParseDecimal(b)
+	switch {
+	case errX != nil && errY != nil:
+		return 0
+	case errX != nil:
+		return 1
+	case errY != nil:
+		return -1
+	}
+	return x.Cmp(y)
+}
+
+//Filter selects the payments of a list. The empty fields do not filter.
+type Filter struct {
+	OrganisationID string
+	Currency       string
+	Scheme         string
+	PaymentType    string
+	//ProcessingDateFrom and ProcessingDateTo are inclusive dates in the format 2006-01-02
+	ProcessingDateFrom string
+	ProcessingDateTo   string
+	//AmountMin and AmountMax are inclusive, they do not filter when not set
+	AmountMin          model.Decimal
+	AmountMax          model.Decimal
+	DebtorAccount      string
+	BeneficiaryAccount string
+	ReferencePrefix    string
+	//IncludeDeleted selects the deleted payments too
+	IncludeDeleted bool
+}
+
+//Match returns true if the payment is selected by the filter
+func (f Filter) Match(p *model.Payment) bool {
+	a := p.Attributes
+	switch {
+	case f.OrganisationID != "" && p.OrganisationID != f.OrganisationID,
+		f.Currency != "" && a.Currency != f.Currency,
+		f.Scheme != "" && a.Scheme != f.Scheme,
+		f.PaymentType != "" && a.Type != f.PaymentType,
+		f.ProcessingDateFrom != "" && a.ProcessingDate < f.ProcessingDateFrom,
+		f.ProcessingDateTo != "" && a.ProcessingDate > f.ProcessingDateTo,
+		f.AmountMin.IsSet() && (!a.Amount.IsSet() || a.Amount.Cmp(f.AmountMin) < 0),
+		f.AmountMax.IsSet() && (!a.Amount.IsSet() || a.Amount.Cmp(f.AmountMax) > 0),
+		f.DebtorAccount != "" && a.DebtorParty.AccountNumber != f.DebtorAccount,
+		f.BeneficiaryAccount != "" && a.BeneficiaryParty.AccountNumber != f.BeneficiaryAccount,
+		f.ReferencePrefix != "" && !strings.HasPrefix(a.Reference, f.ReferencePrefix),
+		!f.IncludeDeleted && p.Deleted():
+		return false
+	}
+	return true
+}
+
+//ListQuery selects a page of payments.
+//The page starts after the Cursor when it is set, otherwise at the Offset.
+type ListQuery struct {
+	Filter Filter
+	Sort   Sort
+	Offset int
+	Cursor *Cursor
+	Limit  int
+}
+
+//escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilter_Match(t *testing.T) {
+	p := &model.Payment{ID: "1", OrganisationID: "org"}
+	p.Attributes.Currency = "GBP"
+	p.Attributes.Amount = model.MustParseDecimal("10.00")
+	p.Attributes.ProcessingDate = "2017-01-18"
+	p.Attributes.Reference = "rent jan"
+	p.Attributes.DebtorParty.AccountNumber = "GB29NWBK60161331926819"
+
+	assert.True(t, Filter{}.Match(p))
+	assert.True(t, Filter{OrganisationID: "org", Currency: "GBP", ReferencePrefix: "rent"}.Match(p))
+	assert.True(t, Filter{AmountMin: model.MustParseDecimal("10"), AmountMax: model.MustParseDecimal("10.0")}.Match(p))
+	assert.True(t, Filter{ProcessingDateFrom: "2017-01-18", ProcessingDateTo: "2017-01-18"}.Match(p))
+	assert.True(t, Filter{DebtorAccount: "GB29NWBK60161331926819"}.Match(p))
+
+	assert.False(t, Filter{OrganisationID: "other"}.Match(p))
+	assert.False(t, Filter{AmountMin: model.MustParseDecimal("10.01")}.Match(p))
+	assert.False(t, Filter{ProcessingDateFrom: "2017-01-19"}.Match(p))
+	assert.False(t, Filter{ReferencePrefix: "piano"}.Match(p))
+	assert.False(t, Filter{BeneficiaryAccount: "31926819"}.Match(p))
+}
+
+func TestSort(t *testing.T) {
+	assert.True(t, DefaultSort.Valid())
+	assert.True(t, Sort{Field: SortAmount}.Valid())
+	assert.False(t, Sort{Field: "organisation_id"}.Valid())
+	assert.False(t, Sort{Field: "id; DROP TABLE payments"}.Valid())
+
+	a := &model.Payment{ID: "a"}
+	a.Attributes.Amount = model.MustParseDecimal("9.5")
+	b := &model.Payment{ID: "b"}
+	b.Attributes.Amount = model.MustParseDecimal("10")
+	assert.Equal(t, -1, Sort{Field: SortAmount}.compare(a, b), "the amounts should be compared as numbers")
+	assert.Equal(t, 1, Sort{Field: SortCurrency}.compare(b, a), "the same values should be compared by id")
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `rent\_50\%\\`, escapeLike(`rent_50%\`))
+}
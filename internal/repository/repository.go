@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"github.com/plusspeed/payments-api/internal/model"
@@ -13,109 +15,394 @@ type Repository struct {
 	Database pg.DB
 }
 
-//PaymentTransaction contains all the DB operations for a Payment
+//PaymentTransaction contains all the DB operations for a Payment.
+//The queries are cancelled when the context is done.
+//Repository stores the payments in postgres and Memory in memory.
 type PaymentTransaction interface {
-	Get(id string) (*model.Payment, error)
-	Create(*model.Payment) error
-	Update(*model.Payment) error
-	Delete(id string) error
-	List(limit, start int) ([]model.Payment, error)
+	Ping(ctx context.Context) error
+	Get(ctx context.Context, id string) (*model.Payment, error)
+	Create(ctx context.Context, p *model.Payment) error
+	Update(ctx context.Context, p *model.Payment) error
+	//Delete deletes the payment if its version is version, or whatever its version with AnyVersion
+	Delete(ctx context.Context, id string, version int) error
+	Restore(ctx context.Context, id string) (*model.Payment, error)
+	List(ctx context.Context, q ListQuery) ([]model.Payment, error)
+}
+
+//Cursor is the position of a page of payments in the order of a Sort.
+//The zero value is the position before the first payment.
+type Cursor struct {
+	//ID is the id of the last payment of the previous page, or of the first payment of the next page when Backward
+	ID string
+	//Key is the value of the sort field of the payment with ID, returned by Sort.Key
+	Key string
+	//Backward selects the payments before the payment with ID instead of after it
+	Backward bool
 }
 
 //ErrNotFound is returned when no payment is returned
 var ErrNotFound = errors.New("payment not found")
 
-//New connects to a postgres sql db and creates Payment if does not exist.
+//ErrNotDeleted is returned by Restore when the payment is not deleted
+var ErrNotDeleted = errors.New("payment is not deleted")
+
+//AnyVersion is the version passed to Delete to delete a payment whatever its version
+const AnyVersion = -1
+
+//VersionConflictError is returned by Update and Delete when the version sent does not match the stored one
+type VersionConflictError struct {
+	Current int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("payment version conflict, current version is %d", e.Current)
+}
+
+//New connects to a postgres sql db.
+//The schema is not changed, it is created and updated by MigrateUp.
+//The queries have no timeout, the deadline is the one of the context passed to each method.
 func New(pgAddress, dbName, pgUsername, pgPassword string) *Repository {
 	db := pg.Connect(&pg.Options{
 		Addr:     pgAddress,
 		User:     pgUsername,
 		Password: pgPassword,
 		Database: dbName,
-	}).WithTimeout(5 * time.Second)
-
-	err := createSchema(db)
-	if err != nil {
-		panic(err)
-	}
+	})
 	return &Repository{Database: *db}
 }
 
-func createSchema(db *pg.DB) error {
-	for _, m := range []interface{}{(*model.Payment)(nil)} {
-		err := db.CreateTable(m, &orm.CreateTableOptions{
-			IfNotExists: true,
-		})
+//Ping checks the connection to the db
+func (d *Repository) Ping(ctx context.Context) error {
+	_, err := d.withContext(ctx).ExecOne("select 'It is running'")
+	return err
+}
+
+//withContext returns the db that runs the queries with the deadline and the cancellation of ctx
+func (d *Repository) withContext(ctx context.Context) *pg.DB {
+	return d.Database.WithContext(ctx)
+}
+
+//inScope runs fn in a transaction that sets the organisations of the scope of ctx for the row level security policy.
+//The queries of fn must also be scoped by scopeQuery, the policy only backs them up.
+//ErrNoScope if ctx has no scope
+func (d *Repository) inScope(ctx context.Context, fn func(tx *pg.Tx, s Scope) error) error {
+	s, err := ScopeFrom(ctx)
+	if err != nil {
+		return err
+	}
+	system := "off"
+	if s.System {
+		system = "on"
+	}
+	return d.withContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Exec("SELECT set_config('app.organisations', ?::text[]::text, true), set_config('app.system', ?, true)",
+			pg.Array(s.Organisations), system)
 		if err != nil {
 			return err
 		}
+		return fn(tx, s)
+	})
+}
+
+//scopeQuery selects only the payments of the organisations of the scope
+func scopeQuery(q *orm.Query, s Scope) *orm.Query {
+	if s.System {
+		return q
 	}
-	return nil
+	return q.WhereIn("organisation_id IN (?)", s.Organisations)
 }
 
-//Get returns a model.Payment
-//ErrNoRows if not found
-func (d *Repository) Get(id string) (*model.Payment, error) {
+//Get returns a model.Payment of an organisation of the scope of ctx, also when it is deleted
+//ErrNotFound if not found
+func (d *Repository) Get(ctx context.Context, id string) (*model.Payment, error) {
 	payment := &model.Payment{ID: id}
-	err := d.Database.Select(payment)
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return getPayment(tx, s, payment)
+	})
 	if err != nil {
-		if err == pg.ErrNoRows {
-			return nil, ErrNotFound
-		}
 		return nil, err
 	}
 	return payment, nil
 }
 
-//Create inserts a model.Payment
-func (d *Repository) Create(payment *model.Payment) error {
-	err := d.Database.Insert(payment)
-	if err != nil {
-		return err
+func getPayment(tx *pg.Tx, s Scope, payment *model.Payment) error {
+	err := scopeQuery(tx.Model(payment).WherePK(), s).Select()
+	if err == pg.ErrNoRows {
+		return ErrNotFound
 	}
-	return nil
+	return err
+}
+
+//Create inserts a model.Payment with its audit entry and its event
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a payment with the same id exists
+func (d *Repository) Create(ctx context.Context, payment *model.Payment) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		if !s.Allows(payment.OrganisationID) {
+			return ErrOutOfScope
+		}
+		err := tx.Insert(payment)
+		if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == uniqueViolation {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		return recordChange(tx, newAuditEntry(ctx, nil, payment))
+	})
 }
 
-//Update modify an existing model.Payment
-//ErrNoRows if not found
-func (d *Repository) Update(m *model.Payment) error {
-	err := d.Database.Update(m)
+//uniqueViolation is the postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+//Update modify an existing model.Payment, not deleted, of an organisation of the scope of ctx only if its version matches the stored one,
+//and records the change in the audit and the outbox. The stored payment is locked until the transaction ends, m.Version is set to the new value.
+//ErrNotFound if not found, ErrOutOfScope if the new organisation is not in the scope,
+//*VersionConflictError if the version does not match
+func (d *Repository) Update(ctx context.Context, m *model.Payment) error {
+	expected := m.Version
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		if !s.Allows(m.OrganisationID) {
+			return ErrOutOfScope
+		}
+		before, err := lockPayment(tx, s, m.ID)
+		if err != nil {
+			return err
+		}
+		if before.Version != expected {
+			return &VersionConflictError{Current: before.Version}
+		}
+		m.Version = expected + 1
+		if _, err := scopeQuery(tx.Model(m).WherePK(), s).Update(); err != nil {
+			return err
+		}
+		return recordChange(tx, newAuditEntry(ctx, before, m))
+	})
 	if err != nil {
+		m.Version = expected
+	}
+	return err
+}
+
+//Delete marks an existing model.Payment of an organisation of the scope of ctx as deleted by the actor of ctx, and records it in the audit and the outbox.
+//The deleted payment keeps its row, with its version incremented, until Purge removes it.
+//The version is checked under the lock of the payment, so a change committed after the caller read it is not lost.
+//ErrNotFound if not found or already deleted, *VersionConflictError if the version is not AnyVersion and does not match
+func (d *Repository) Delete(ctx context.Context, id string, version int) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		before, err := lockPayment(tx, s, id)
+		if err != nil {
+			return err
+		}
+		if version != AnyVersion && before.Version != version {
+			return &VersionConflictError{Current: before.Version}
+		}
+		now := time.Now().UTC()
+		after := *before
+		after.Version++
+		after.DeletedAt, after.DeletedBy = &now, ActorFrom(ctx).Subject
+		if _, err := scopeQuery(tx.Model(&after).WherePK(), s).Update(); err != nil {
+			return err
+		}
+		return recordChange(tx, newAuditEntry(ctx, before, &after))
+	})
+}
+
+//Restore clears the deletion of a deleted model.Payment of an organisation of the scope of ctx, records it in the audit and the outbox and returns it
+//ErrNotFound if not found, ErrNotDeleted if it is not deleted
+func (d *Repository) Restore(ctx context.Context, id string) (*model.Payment, error) {
+	var after model.Payment
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		before := &model.Payment{ID: id}
+		err := scopeQuery(tx.Model(before).WherePK().For("UPDATE"), s).Select()
 		if err == pg.ErrNoRows {
 			return ErrNotFound
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		if !before.Deleted() {
+			return ErrNotDeleted
+		}
+		after = *before
+		after.Version++
+		after.DeletedAt, after.DeletedBy = nil, ""
+		if _, err := scopeQuery(tx.Model(&after).WherePK(), s).Update(); err != nil {
+			return err
+		}
+		return recordChange(tx, newAuditEntry(ctx, before, &after))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &after, nil
+}
+
+//purgeBatchSize is the number of payments removed by each transaction of a purge,
+//so a purge does not lock every deleted payment of every organisation at once
+const purgeBatchSize = 100
+
+//Purge removes the payments of the scope of ctx deleted before the time, a batch per transaction,
+//and records them in the audit and the outbox. Returns the number of payments removed.
+func (d *Repository) Purge(ctx context.Context, deletedBefore time.Time) (int, error) {
+	total := 0
+	for {
+		n, err := d.purgeBatch(ctx, deletedBefore)
+		total += n
+		if err != nil || n < purgeBatchSize {
+			return total, err
+		}
 	}
-	return nil
 }
 
-//Delete deletes an existing model.Payment
-//ErrNoRows if not found
-func (d *Repository) Delete(id string) error {
+//purgeBatch removes up to purgeBatchSize payments deleted before the time in a transaction.
+//The payments locked by another transaction, e.g. a restore, are skipped.
+func (d *Repository) purgeBatch(ctx context.Context, deletedBefore time.Time) (int, error) {
+	var purged []model.Payment
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		q := tx.Model(&purged).Where("deleted_at < ?", deletedBefore).Order("deleted_at ASC").Limit(purgeBatchSize).For("UPDATE SKIP LOCKED")
+		if err := scopeQuery(q, s).Select(); err != nil {
+			return err
+		}
+		for i := range purged {
+			if _, err := tx.Model(&purged[i]).WherePK().Delete(); err != nil {
+				return err
+			}
+			if err := recordChange(tx, newAuditEntry(ctx, &purged[i], nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(purged), nil
+}
+
+//lockPayment returns the payment and locks it until the end of the transaction
+//ErrNotFound if not found or deleted
+func lockPayment(tx *pg.Tx, s Scope, id string) (*model.Payment, error) {
 	payment := &model.Payment{ID: id}
-	err := d.Database.Delete(payment)
+	err := scopeQuery(tx.Model(payment).WherePK().Where("deleted_at IS NULL").For("UPDATE"), s).Select()
+	if err == pg.ErrNoRows {
+		return nil, ErrNotFound
+	}
 	if err != nil {
-		if err == pg.ErrNoRows {
-			return ErrNotFound
-		}
-		return err
+		return nil, err
 	}
-	return nil
+	return payment, nil
 }
 
-//List returns a list of model.Payment for a offsset and limit orderly by ID Desc
-//ErrNoRows if not found
-func (d *Repository) List(offset, limit int) ([]model.Payment, error) {
+//List returns the page of the payments of the organisations of the scope of ctx selected by the filter of the query, in the order of its sort.
+//The page starts after the cursor when it is set, using the order as the seek condition,
+//so the pages do not move when payments are inserted. Otherwise it starts at the offset.
+//ErrInvalidSort if the sort field is not in the whitelist, ErrNotFound if there are no payments in the page
+func (d *Repository) List(ctx context.Context, q ListQuery) ([]model.Payment, error) {
+	if !q.Sort.Valid() {
+		return nil, ErrInvalidSort
+	}
 	var ts []model.Payment
-	err := d.Database.Model(&ts).
-		Offset(offset).Limit(limit).Order("id DESC").
-		Select()
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return listQuery(scopeQuery(tx.Model(&ts), s), q).Select()
+	})
 	if err != nil {
 		return nil, err
 	}
-
 	if len(ts) == 0 {
 		return nil, ErrNotFound
 	}
+	if q.Cursor != nil && q.Cursor.Backward {
+		reverse(ts)
+	}
 	return ts, nil
 }
+
+//listQuery adds the filter, the order and the page of q to the query
+func listQuery(query *orm.Query, q ListQuery) *orm.Query {
+	query = filterQuery(query, q.Filter).Limit(q.Limit)
+
+	column := sortColumns[q.Sort.field()]
+	desc := q.Sort.Desc
+	backward := q.Cursor != nil && q.Cursor.Backward
+	if backward {
+		desc = !desc
+	}
+	switch {
+	case q.Cursor == nil:
+		query = query.Offset(q.Offset)
+	case q.Cursor.ID != "" && q.Sort.field() == SortAmount:
+		condition, params := nullableSeek(column, desc, q.Cursor)
+		query = query.Where(condition, params...)
+	case q.Cursor.ID != "":
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		query = query.Where("("+column+", id) "+op+" (?, ?)", q.Cursor.Key, q.Cursor.ID)
+	}
+	direction := " ASC NULLS LAST"
+	if desc {
+		direction = " DESC NULLS FIRST"
+	}
+	return query.OrderExpr(column + direction).OrderExpr("id" + direction)
+}
+
+//nullableSeek returns the seek condition after the cursor on a column that can be null, like the amount of the payments without one.
+//A row comparison with a null is null, so the nulls are compared apart. They are sorted last, like in Sort.compareKey.
+func nullableSeek(column string, desc bool, c *Cursor) (string, []interface{}) {
+	switch {
+	case c.Key == "" && desc:
+		return "(" + column + " IS NOT NULL OR id < ?)", []interface{}{c.ID}
+	case c.Key == "":
+		return "(" + column + " IS NULL AND id > ?)", []interface{}{c.ID}
+	case desc:
+		return "(" + column + " < ? OR (" + column + " = ? AND id < ?))", []interface{}{c.Key, c.Key, c.ID}
+	}
+	return "(" + column + " > ? OR " + column + " IS NULL OR (" + column + " = ? AND id > ?))", []interface{}{c.Key, c.Key, c.ID}
+}
+
+//filterQuery adds the conditions of the filter to the query, the values are bound as parameters
+func filterQuery(q *orm.Query, f Filter) *orm.Query {
+	equals := []struct {
+		column string
+		value  string
+	}{
+		{"organisation_id", f.OrganisationID},
+		{"attributes->>'currency'", f.Currency},
+		{"attributes->>'payment_scheme'", f.Scheme},
+		{"attributes->>'payment_type'", f.PaymentType},
+		{"attributes->'debtor_party'->>'account_number'", f.DebtorAccount},
+		{"attributes->'beneficiary_party'->>'account_number'", f.BeneficiaryAccount},
+	}
+	for _, e := range equals {
+		if e.value != "" {
+			q = q.Where(e.column+" = ?", e.value)
+		}
+	}
+	if f.ProcessingDateFrom != "" {
+		q = q.Where("attributes->>'processing_date' >= ?", f.ProcessingDateFrom)
+	}
+	if f.ProcessingDateTo != "" {
+		q = q.Where("attributes->>'processing_date' <= ?", f.ProcessingDateTo)
+	}
+	if f.AmountMin.IsSet() {
+		q = q.Where(sortColumns[SortAmount]+" >= ?", f.AmountMin.String())
+	}
+	if f.AmountMax.IsSet() {
+		q = q.Where(sortColumns[SortAmount]+" <= ?", f.AmountMax.String())
+	}
+	if f.ReferencePrefix != "" {
+		q = q.Where("attributes->>'reference' LIKE ?", escapeLike(f.ReferencePrefix)+"%")
+	}
+	if !f.IncludeDeleted {
+		q = q.Where("deleted_at IS NULL")
+	}
+	return q
+}
+
+//reverse reverses the order of the payments
+func reverse(ts []model.Payment) {
+	for i, j := 0, len(ts)-1; i < j; i, j = i+1, j-1 {
+		ts[i], ts[j] = ts[j], ts[i]
+	}
+}
@@ -1,11 +1,15 @@
 package repository
 
 import (
-	"github.com/go-pg/pg/orm"
+	"context"
+	"errors"
+	"github.com/go-pg/pg"
 	"github.com/pborman/uuid"
 	"github.com/plusspeed/payments-api/internal/model"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
+	"time"
 )
 
 const (
@@ -16,6 +20,7 @@ const (
 )
 
 func TestDatabase_Create_UPDATE_GET_DELETE(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
 	dbTest := New(pgAddress, "", pgUsername, pgPassword)
 	defer dbTest.Database.Close()
 	clearDB(*dbTest)
@@ -28,10 +33,10 @@ func TestDatabase_Create_UPDATE_GET_DELETE(t *testing.T) {
 		ID:             paymentID,
 		OrganisationID: org1,
 	}
-	err := dbTest.Create(p1)
+	err := dbTest.Create(ctx, p1)
 	assert.Nil(t, err)
 
-	p2, err := dbTest.Get(paymentID)
+	p2, err := dbTest.Get(ctx, paymentID)
 	assert.Nil(t, err)
 
 	assert.Equal(t, p1, p2, "should be equal %+v %+v", p1, p2)
@@ -39,53 +44,103 @@ func TestDatabase_Create_UPDATE_GET_DELETE(t *testing.T) {
 	p3 := &model.Payment{
 		ID:             paymentID,
 		OrganisationID: org2,
+		CreatedBy:      "alice",
+		Approvals:      []model.Approval{{Decision: model.DecisionRejected, By: "bob", At: time.Date(2018, 1, 18, 10, 0, 0, 0, time.UTC), Reason: "wrong amount"}},
 	}
 
-	err = dbTest.Update(p3)
+	err = dbTest.Update(ctx, p3)
 	assert.Nil(t, err)
 
-	p4, err := dbTest.Get(paymentID)
+	p4, err := dbTest.Get(ctx, paymentID)
 	assert.Nil(t, err)
 
 	assert.Equal(t, p3, p4, "should be equal %+v %+v", p3, p4)
 
-	err = dbTest.Delete(paymentID)
+	assert.Equal(t, &VersionConflictError{Current: p4.Version}, dbTest.Delete(ctx, paymentID, p4.Version-1))
+	err = dbTest.Delete(ctx, paymentID, p4.Version)
 	assert.Nil(t, err)
 
-	p5, err := dbTest.Get(paymentID)
-	assert.Nil(t, p5)
-	assert.NotNil(t, err)
+	p5, err := dbTest.Get(ctx, paymentID)
+	assert.Nil(t, err)
+	assert.True(t, p5.Deleted(), "the deleted payment should be kept")
+	assert.Equal(t, ErrNotFound, dbTest.Delete(ctx, paymentID, AnyVersion))
+
+	p6, err := dbTest.Restore(ctx, paymentID)
+	assert.Nil(t, err)
+	assert.False(t, p6.Deleted())
+	assert.Equal(t, p5.Version+1, p6.Version)
+	_, err = dbTest.Restore(ctx, paymentID)
+	assert.Equal(t, ErrNotDeleted, err)
+
+	assert.Nil(t, dbTest.Delete(ctx, paymentID, AnyVersion))
+	n, err := dbTest.Purge(ctx, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	n, err = dbTest.Purge(ctx, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, err = dbTest.Get(ctx, paymentID)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestDatabase_UpdateVersionConflict(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	var paymentID = uuid.NewRandom().String()
+
+	err := dbTest.Create(ctx, &model.Payment{ID: paymentID})
+	assert.Nil(t, err)
+
+	p1 := &model.Payment{ID: paymentID, OrganisationID: "1"}
+	err = dbTest.Update(ctx, p1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, p1.Version, "the version should be incremented")
+
+	// a second writer still holding version 0 must not clobber the first update
+	p2 := &model.Payment{ID: paymentID, OrganisationID: "2"}
+	err = dbTest.Update(ctx, p2)
+	assert.Equal(t, &VersionConflictError{Current: 1}, err)
+	assert.Equal(t, 0, p2.Version, "the version should not change on conflict")
+
+	p3, err := dbTest.Get(ctx, paymentID)
+	assert.Nil(t, err)
+	assert.Equal(t, p1, p3, "should be equal %+v %+v", p1, p3)
 }
 
 func TestDatabase_NotFound(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
 	dbTest := New(pgAddress, "", pgUsername, pgPassword)
 	defer dbTest.Database.Close()
 	clearDB(*dbTest)
 
 	var paymentID = uuid.NewRandom().String()
 
-	_, err := dbTest.Get(paymentID)
+	_, err := dbTest.Get(ctx, paymentID)
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrNotFound, err, "should be equal %+v %+v", ErrNotFound, err)
 
-	err = dbTest.Update(&model.Payment{
+	err = dbTest.Update(ctx, &model.Payment{
 		ID: paymentID,
 	})
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrNotFound, err, "should be equal %+v %+v", ErrNotFound, err)
 
-	err = dbTest.Delete(paymentID)
+	err = dbTest.Delete(ctx, paymentID, AnyVersion)
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrNotFound, err, "should be equal %+v %+v", ErrNotFound, err)
 }
 
 func TestDatabase_List(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
 	dbTest := New(pgAddress, "", pgUsername, pgPassword)
 	defer dbTest.Database.Close()
 	clearDB(*dbTest)
 
 	// when there are no results, return ErrNotFound
-	_, err := dbTest.List(0, 1)
+	_, err := dbTest.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 1})
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrNotFound, err, "should be equal %+v %+v", ErrNotFound, err)
 
@@ -97,44 +152,584 @@ func TestDatabase_List(t *testing.T) {
 	p1 := &model.Payment{
 		ID: paymentID1,
 	}
-	err = dbTest.Create(p1)
+	err = dbTest.Create(ctx, p1)
 	assert.Nil(t, err)
 
 	p2 := &model.Payment{
 		ID: paymentID2,
 	}
-	err = dbTest.Create(p2)
+	err = dbTest.Create(ctx, p2)
 	assert.Nil(t, err)
 
 	p3 := &model.Payment{
 		ID: paymentID3,
 	}
-	err = dbTest.Create(p3)
+	err = dbTest.Create(ctx, p3)
 	assert.Nil(t, err)
 
 	//Test List
-	ts1, err := dbTest.List(0, 5)
+	ts1, err := dbTest.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 5})
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(ts1), "the length should be 3 instead of", len(ts1))
 
-	ts2, err := dbTest.List(0, 3)
+	ts2, err := dbTest.List(ctx, ListQuery{Sort: DefaultSort, Offset: 0, Limit: 3})
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(ts2), "the length should be 3 instead of", len(ts2))
 
-	_, err = dbTest.List(3, 5)
+	_, err = dbTest.List(ctx, ListQuery{Sort: DefaultSort, Offset: 3, Limit: 5})
 	assert.NotNil(t, err)
 	assert.Equal(t, ErrNotFound, err, "should be equal %+v %+v", ErrNotFound, err)
 
 }
 
-func clearDB(dbTest Repository) {
-	err := dbTest.Database.DropTable(&model.Payment{}, &orm.DropTableOptions{
-		IfExists: true,
-		Cascade:  true,
+func TestDatabase_Scope(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	var paymentID = uuid.NewRandom().String()
+	assert.Equal(t, ErrOutOfScope, dbTest.Create(other, &model.Payment{ID: paymentID, OrganisationID: "1"}))
+	assert.Nil(t, dbTest.Create(ctx, &model.Payment{ID: paymentID, OrganisationID: "1"}))
+	assert.Equal(t, ErrAlreadyExists, dbTest.Create(ctx, &model.Payment{ID: paymentID, OrganisationID: "1"}))
+
+	//the payments of the other organisations are not found
+	_, err := dbTest.Get(other, paymentID)
+	assert.Equal(t, ErrNotFound, err)
+	_, err = dbTest.List(other, ListQuery{Sort: DefaultSort, Limit: 10})
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, dbTest.Update(other, &model.Payment{ID: paymentID, OrganisationID: "2"}))
+	assert.Equal(t, ErrNotFound, dbTest.Delete(other, paymentID, AnyVersion))
+	assert.Equal(t, ErrOutOfScope, dbTest.Update(ctx, &model.Payment{ID: paymentID, OrganisationID: "2"}))
+
+	_, err = dbTest.Get(context.Background(), paymentID)
+	assert.Equal(t, ErrNoScope, err, "the access should be denied without a scope")
+
+	p, err := dbTest.Get(ctx, paymentID)
+	assert.Nil(t, err)
+	assert.Equal(t, "1", p.OrganisationID)
+	assert.Nil(t, dbTest.Delete(ctx, paymentID, AnyVersion))
+}
+
+func TestDatabase_RowLevelSecurity(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	var superuser bool
+	_, err := dbTest.Database.QueryOne(pg.Scan(&superuser), "SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user")
+	assert.Nil(t, err)
+	if superuser {
+		t.Skip("the row level security policies do not apply to the superusers")
+	}
+
+	assert.Nil(t, dbTest.Create(WithSystemScope(context.Background()), &model.Payment{ID: uuid.NewRandom().String(), OrganisationID: "2"}))
+
+	//a query of the transaction without the conditions of the scope only returns the payments of the scope
+	var count int
+	err = dbTest.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		_, err := tx.QueryOne(pg.Scan(&count), "SELECT count(*) FROM payments")
+		return err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestDatabase_Keys(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	k := &model.APIKey{
+		ID:             uuid.NewRandom().String(),
+		OrganisationID: "1",
+		Name:           "ci",
+		Scopes:         []string{"read", "write"},
+		Salt:           []byte("salt"),
+		Hash:           []byte("hash"),
+		CreatedAt:      time.Now().UTC().Truncate(time.Millisecond),
+	}
+	assert.Equal(t, ErrOutOfScope, dbTest.CreateKey(other, k))
+	assert.Nil(t, dbTest.CreateKey(ctx, k))
+	assert.Equal(t, ErrAlreadyExists, dbTest.CreateKey(ctx, k))
+
+	stored, err := dbTest.GetKey(ctx, k.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, k.Scopes, stored.Scopes)
+	assert.Equal(t, k.Hash, stored.Hash)
+	_, err = dbTest.GetKey(other, k.ID)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	ks, err := dbTest.ListKeys(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ks))
+	ks, err = dbTest.ListKeys(other)
+	assert.Nil(t, err)
+	assert.Empty(t, ks)
+
+	now := time.Now().UTC()
+	stored.RevokedAt = &now
+	assert.Equal(t, ErrKeyNotFound, dbTest.UpdateKey(other, stored))
+	assert.Nil(t, dbTest.UpdateKey(ctx, stored))
+	stored, err = dbTest.GetKey(ctx, k.ID)
+	assert.Nil(t, err)
+	assert.True(t, stored.Revoked())
+}
+
+func TestDatabase_PublishEvents(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	paymentID := uuid.NewRandom().String()
+	p := &model.Payment{ID: paymentID, OrganisationID: "1"}
+	assert.Nil(t, dbTest.Create(ctx, p))
+	p.Attributes.Reference = "changed"
+	assert.Nil(t, dbTest.Update(ctx, p))
+	assert.Nil(t, dbTest.Delete(ctx, paymentID, AnyVersion))
+
+	//the events after a failed delivery wait for it
+	system := WithSystemScope(context.Background())
+	var published []Event
+	n, err := dbTest.PublishEvents(system, 10, func(e Event) error {
+		if len(published) == 1 {
+			return errors.New("unavailable")
+		}
+		published = append(published, e)
+		return nil
+	})
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, 1, n)
+
+	n, err = dbTest.PublishEvents(system, 10, func(e Event) error {
+		published = append(published, e)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	types := []EventType{EventCreated, EventUpdated, EventDeleted}
+	if assert.Equal(t, len(types), len(published)) {
+		for i, e := range published {
+			assert.Equal(t, types[i], e.Type)
+			assert.Equal(t, paymentID, e.PaymentID)
+		}
+		assert.True(t, published[0].ID < published[2].ID)
+		assert.Equal(t, "changed", published[1].Payment.Attributes.Reference)
+	}
+
+	n, err = dbTest.PublishEvents(system, 10, func(e Event) error { return nil })
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "the events are published once")
+}
+
+func TestDatabase_PublishEventsClaimed(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	system := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	p := &model.Payment{ID: uuid.NewRandom().String(), OrganisationID: "1"}
+	assert.Nil(t, dbTest.Create(ctx, p))
+
+	//the events are published outside of a transaction: the payments can change meanwhile,
+	//and another publisher does not publish the events after the claimed ones
+	n, err := dbTest.PublishEvents(system, 10, func(e Event) error {
+		timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		assert.Nil(t, dbTest.Update(timeout, p))
+		other, err := dbTest.PublishEvents(system, 10, func(e Event) error { return nil })
+		assert.Nil(t, err)
+		assert.Equal(t, 0, other)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	var published []Event
+	n, err = dbTest.PublishEvents(system, 10, func(e Event) error {
+		published = append(published, e)
+		return nil
+	})
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, n) {
+		assert.Equal(t, EventUpdated, published[0].Type)
+	}
+}
+
+func TestDatabase_Changes(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	p := &model.Payment{ID: uuid.NewRandom().String(), OrganisationID: "1"}
+	assert.Nil(t, dbTest.Create(ctx, p))
+	assert.Nil(t, dbTest.Create(other, &model.Payment{ID: uuid.NewRandom().String(), OrganisationID: "2"}))
+	assert.Nil(t, dbTest.Update(ctx, p))
+
+	//the events are in the feed once the publisher gave them a position
+	changes, err := dbTest.Changes(ctx, 0, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+	runPublisher(t, dbTest)
+
+	changes, err = dbTest.Changes(ctx, 0, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(changes)) {
+		assert.Equal(t, EventCreated, changes[0].Type)
+		assert.Equal(t, EventUpdated, changes[1].Type)
+		assert.True(t, changes[0].Position < changes[1].Position)
+	}
+	after, err := dbTest.Changes(ctx, changes[0].Position, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(after))
+	changes, err = dbTest.Changes(other, 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(changes))
+}
+
+func TestDatabase_ChangesOverlapping(t *testing.T) {
+	system := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	//two transactions insert their events, the first one commits last
+	begin := func(paymentID string) *pg.Tx {
+		tx, err := dbTest.Database.Begin()
+		assert.Nil(t, err)
+		_, err = tx.Exec("SELECT set_config('app.system', 'on', true)")
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Insert(&Event{Type: EventCreated, PaymentID: paymentID, OrganisationID: "1", OccurredAt: time.Now().UTC()}))
+		return tx
+	}
+	first := begin("first")
+	second := begin("second")
+	assert.Nil(t, second.Commit())
+	runPublisher(t, dbTest)
+
+	changes, err := dbTest.Changes(system, 0, 10)
+	assert.Nil(t, err)
+	if !assert.Equal(t, 1, len(changes)) {
+		first.Rollback()
+		return
+	}
+	assert.Equal(t, "second", changes[0].PaymentID)
+	cursor := changes[0].Position
+
+	//the event of the first transaction has a smaller id but comes after the cursor
+	assert.Nil(t, first.Commit())
+	runPublisher(t, dbTest)
+	changes, err = dbTest.Changes(system, cursor, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(changes)) {
+		assert.Equal(t, "first", changes[0].PaymentID)
+		assert.True(t, changes[0].ID < changes[0].Position)
+	}
+}
+
+//runPublisher runs the publisher, which gives their positions in the change feed to the events committed
+func runPublisher(t *testing.T, dbTest *Repository) {
+	_, err := dbTest.PublishEvents(WithSystemScope(context.Background()), 100, func(Event) error { return nil })
+	assert.Nil(t, err)
+}
+
+func TestDatabase_Webhooks(t *testing.T) {
+	ctx := WithScope(context.Background(), Scope{Organisations: []string{"1"}})
+	other := WithScope(context.Background(), Scope{Organisations: []string{"2"}})
+	system := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	sub := &model.Subscription{ID: uuid.NewRandom().String(), OrganisationID: "1", URL: "http://example.com", EventTypes: []string{"payment.created"}, Secret: "secret", CreatedAt: now}
+	assert.Equal(t, ErrOutOfScope, dbTest.CreateSubscription(other, sub))
+	assert.Nil(t, dbTest.CreateSubscription(ctx, sub))
+	assert.Equal(t, ErrAlreadyExists, dbTest.CreateSubscription(ctx, sub))
+	stored, err := dbTest.GetSubscription(ctx, sub.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, sub.EventTypes, stored.EventTypes)
+	_, err = dbTest.GetSubscription(other, sub.ID)
+	assert.Equal(t, ErrSubscriptionNotFound, err)
+
+	delivery := func(eventID int64, due time.Time) model.Delivery {
+		return model.Delivery{ID: uuid.NewRandom().String(), SubscriptionID: sub.ID, OrganisationID: "1", EventID: eventID, EventType: "payment.created",
+			Status: model.DeliveryPending, Payload: []byte(`{}`), NextAttemptAt: due, CreatedAt: now}
+	}
+	ds := []model.Delivery{delivery(2, now), delivery(1, now), delivery(3, now.Add(time.Hour))}
+	assert.Nil(t, dbTest.CreateDeliveries(ctx, ds))
+	assert.Nil(t, dbTest.CreateDeliveries(ctx, []model.Delivery{delivery(1, now)}), "a delivery of the same event should be skipped")
+
+	claimed, err := dbTest.ClaimDeliveries(system, now, time.Minute, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(claimed)) {
+		assert.Equal(t, int64(1), claimed[0].EventID)
+	}
+	claimed, err = dbTest.ClaimDeliveries(system, now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, claimed)
+
+	d := &ds[1]
+	d.Status, d.Attempts, d.LastError = model.DeliveryDead, 8, "unavailable"
+	assert.Equal(t, ErrDeliveryNotFound, dbTest.UpdateDelivery(other, d))
+	assert.Nil(t, dbTest.UpdateDelivery(ctx, d))
+	dead, err := dbTest.ListDeliveries(ctx, model.DeliveryDead)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(dead)) {
+		assert.Equal(t, d.ID, dead[0].ID)
+		assert.Equal(t, 8, dead[0].Attempts)
+	}
+
+	assert.Nil(t, dbTest.DeleteSubscription(ctx, sub.ID))
+	_, err = dbTest.GetDelivery(ctx, d.ID)
+	assert.Equal(t, ErrDeliveryNotFound, err)
+}
+
+func TestDatabase_History(t *testing.T) {
+	ctx := WithActor(WithScope(context.Background(), Scope{Organisations: []string{"1"}}), Actor{Subject: "alice", RequestID: "r1"})
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	paymentID := uuid.NewRandom().String()
+	_, err := dbTest.History(ctx, paymentID)
+	assert.Equal(t, ErrNotFound, err)
+
+	p := &model.Payment{ID: paymentID, OrganisationID: "1"}
+	assert.Nil(t, dbTest.Create(ctx, p))
+	assert.Nil(t, p.Transition(model.StatusDraft, time.Now().UTC()))
+	assert.Nil(t, dbTest.Update(ctx, p))
+	p.Attributes.Reference = "changed"
+	assert.Nil(t, dbTest.Update(ctx, p))
+	assert.Nil(t, dbTest.Delete(ctx, paymentID, AnyVersion))
+
+	entries, err := dbTest.History(ctx, paymentID)
+	assert.Nil(t, err)
+	if assert.Equal(t, 4, len(entries)) {
+		assert.Equal(t, AuditCreate, entries[0].Action)
+		assert.Nil(t, entries[0].Before)
+		assert.Equal(t, AuditStatus, entries[1].Action)
+		assert.Equal(t, AuditUpdate, entries[2].Action)
+		assert.Equal(t, "changed", entries[2].After.Attributes.Reference)
+		assert.Equal(t, "", entries[2].Before.Attributes.Reference)
+		assert.Equal(t, AuditDelete, entries[3].Action)
+		assert.True(t, entries[3].After.Deleted())
+		assert.Equal(t, "alice", entries[3].After.DeletedBy)
+		assert.Equal(t, "alice", entries[3].Actor)
+		assert.Equal(t, "r1", entries[3].RequestID)
+		assert.True(t, entries[0].ID < entries[3].ID)
+	}
+
+	//the entries can not be changed
+	err = dbTest.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		_, err := tx.Exec("DELETE FROM payment_audit")
+		return err
 	})
+	assert.NotNil(t, err)
+
+	_, err = dbTest.History(WithScope(context.Background(), Scope{Organisations: []string{"2"}}), paymentID)
+	assert.Equal(t, ErrNotFound, err, "the history of another organisation should not be found")
+}
+
+func TestDatabase_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	_, err := dbTest.GetIdempotent(ctx, "org|alice|k1")
+	assert.Equal(t, ErrIdempotencyNotFound, err)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	r := &IdempotentResponse{
+		Key:         "org|alice|k1",
+		RequestHash: []byte("hash"),
+		Status:      201,
+		Header:      http.Header{"Etag": {`"1-abc"`}},
+		Body:        []byte(`{"data":{}}`),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	assert.Nil(t, dbTest.SaveIdempotent(ctx, r))
+	stored, err := dbTest.GetIdempotent(ctx, r.Key)
+	assert.Nil(t, err)
+	assert.Equal(t, r.Header, stored.Header)
+	assert.Equal(t, r.Body, stored.Body)
+	assert.Equal(t, 201, stored.Status)
+
+	//an expired response is not returned and is replaced
+	r.ExpiresAt = now.Add(-time.Second)
+	assert.Nil(t, dbTest.SaveIdempotent(ctx, r))
+	_, err = dbTest.GetIdempotent(ctx, r.Key)
+	assert.Equal(t, ErrIdempotencyNotFound, err)
+
+	//a key is claimed by one request at a time, until its response is stored or it is released
+	claim := &IdempotentResponse{Key: r.Key, RequestHash: r.RequestHash, CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+	stored, err = dbTest.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+	stored, err = dbTest.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.True(t, stored.Pending())
+	assert.Nil(t, dbTest.ReleaseIdempotencyKey(ctx, r.Key))
+	stored, err = dbTest.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	r.ExpiresAt = now.Add(time.Hour)
+	assert.Nil(t, dbTest.SaveIdempotent(ctx, r))
+	assert.Nil(t, dbTest.ReleaseIdempotencyKey(ctx, r.Key))
+	stored, err = dbTest.ClaimIdempotencyKey(ctx, claim)
+	assert.Nil(t, err)
+	assert.Equal(t, 201, stored.Status)
+}
+
+func TestDatabase_CancelledContext(t *testing.T) {
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := dbTest.Create(ctx, &model.Payment{ID: uuid.NewRandom().String()})
+	assert.NotNil(t, err, "the query should not run with a cancelled context")
+
+	_, err = dbTest.List(WithSystemScope(context.Background()), ListQuery{Sort: DefaultSort, Offset: 0, Limit: 1})
+	assert.Equal(t, ErrNotFound, err)
+}
 
-	err = dbTest.Database.CreateTable(&model.Payment{}, &orm.CreateTableOptions{
-		IfNotExists: true,
+func TestDatabase_ListCursor(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	_, err := dbTest.List(ctx, ListQuery{Sort: DefaultSort, Cursor: &Cursor{}, Limit: 2})
+	assert.Equal(t, ErrNotFound, err)
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		err = dbTest.Create(ctx, &model.Payment{ID: id})
+		assert.Nil(t, err)
+	}
+
+	ts, err := dbTest.List(ctx, ListQuery{Sort: DefaultSort, Cursor: &Cursor{}, Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, "4", ts[0].ID)
+	assert.Equal(t, "3", ts[1].ID)
+
+	ts, err = dbTest.List(ctx, ListQuery{Sort: DefaultSort, Cursor: &Cursor{ID: "3", Key: "3"}, Limit: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ts))
+	assert.Equal(t, "2", ts[0].ID)
+
+	ts, err = dbTest.List(ctx, ListQuery{Sort: DefaultSort, Cursor: &Cursor{ID: "2", Key: "2", Backward: true}, Limit: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ts))
+	assert.Equal(t, "3", ts[0].ID, "the page before the cursor should be the closest payments")
+}
+
+func TestDatabase_ListFilter(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	for _, p := range []struct{ id, currency, amount, reference string }{
+		{"1", "GBP", "10.00", "rent jan"},
+		{"2", "GBP", "9.50", "rent_feb"},
+		{"3", "USD", "100.00", "piano"},
+	} {
+		payment := &model.Payment{ID: p.id}
+		payment.Attributes.Currency = p.currency
+		payment.Attributes.Amount = model.MustParseDecimal(p.amount)
+		payment.Attributes.Reference = p.reference
+		err := dbTest.Create(ctx, payment)
+		assert.Nil(t, err)
+	}
+
+	ts, err := dbTest.List(ctx, ListQuery{Filter: Filter{Currency: "GBP"}, Sort: Sort{Field: SortAmount}, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ts))
+	assert.Equal(t, "2", ts[0].ID, "9.50 should be sorted before 10.00")
+
+	ts, err = dbTest.List(ctx, ListQuery{Filter: Filter{AmountMin: model.MustParseDecimal("10")}, Sort: DefaultSort, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ts))
+
+	ts, err = dbTest.List(ctx, ListQuery{Filter: Filter{ReferencePrefix: "rent_"}, Sort: DefaultSort, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ts), "the _ of the prefix should not be a wildcard")
+
+	ts, err = dbTest.List(ctx, ListQuery{Sort: Sort{Field: SortAmount, Desc: true}, Cursor: &Cursor{ID: "1", Key: "10.00"}, Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(ts))
+	assert.Equal(t, "2", ts[0].ID)
+
+	_, err = dbTest.List(ctx, ListQuery{Sort: Sort{Field: "organisation_id"}, Limit: 10})
+	assert.Equal(t, ErrInvalidSort, err)
+}
+
+func TestDatabase_ListCursorNullAmount(t *testing.T) {
+	ctx := WithSystemScope(context.Background())
+	dbTest := New(pgAddress, "", pgUsername, pgPassword)
+	defer dbTest.Database.Close()
+	clearDB(*dbTest)
+
+	//the legacy payments 2 and 3 have no amount
+	for _, p := range []struct{ id, amount string }{{"1", "10.00"}, {"2", ""}, {"3", ""}, {"4", "5.00"}} {
+		payment := &model.Payment{ID: p.id}
+		if p.amount != "" {
+			payment.Attributes.Amount = model.MustParseDecimal(p.amount)
+		}
+		assert.Nil(t, dbTest.Create(ctx, payment))
+	}
+
+	//the pages of one payment go through the payments without an amount, sorted last
+	for sort, expected := range map[Sort][]string{
+		{Field: SortAmount}:             {"4", "1", "2", "3"},
+		{Field: SortAmount, Desc: true}: {"3", "2", "1", "4"},
+	} {
+		var ids []string
+		ts, err := dbTest.List(ctx, ListQuery{Sort: sort, Cursor: &Cursor{}, Limit: 1})
+		for err == nil {
+			ids = append(ids, ts[0].ID)
+			ts, err = dbTest.List(ctx, ListQuery{Sort: sort, Cursor: &Cursor{ID: ts[0].ID, Key: sort.Key(&ts[0])}, Limit: 1})
+		}
+		assert.Equal(t, ErrNotFound, err)
+		assert.Equal(t, expected, ids, "sort %+v", sort)
+	}
+
+	ts, err := dbTest.List(ctx, ListQuery{Sort: Sort{Field: SortAmount}, Cursor: &Cursor{ID: "3", Backward: true}, Limit: 2})
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, len(ts)) {
+		assert.Equal(t, "1", ts[0].ID)
+		assert.Equal(t, "2", ts[1].ID)
+	}
+}
+
+func clearDB(dbTest Repository) {
+	ctx := WithSystemScope(context.Background())
+	if _, err := dbTest.MigrateUp(ctx); err != nil {
+		panic(err.Error())
+	}
+	//the row level security policy hides the payments when the test user is not a superuser
+	err := dbTest.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		for _, table := range []string{"payments", "api_keys", "idempotency_keys", "payment_events", "webhook_deliveries", "webhook_subscriptions"} {
+			if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+				return err
+			}
+		}
+		//the audit is append-only, the trigger does not stop a truncate
+		_, err := tx.Exec("TRUNCATE payment_audit")
+		return err
 	})
 	if err != nil {
 		panic(err.Error())
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+//ErrNoScope is returned when the context has no Scope, the repositories deny the access by default
+var ErrNoScope = errors.New("the context has no organisation scope")
+
+//ErrOutOfScope is returned by Create and Update when the organisation of the payment is not in the Scope
+var ErrOutOfScope = errors.New("the organisation of the payment is not in the scope")
+
+//Scope is the set of organisations whose payments can be accessed.
+//The payments of the other organisations are not found.
+type Scope struct {
+	Organisations []string
+	//System gives access to the payments of every organisation, it is meant for internal jobs
+	System bool
+}
+
+type scopeKey struct{}
+
+//WithScope returns a copy of ctx that scopes the queries of the repositories
+func WithScope(ctx context.Context, s Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+//WithSystemScope returns a copy of ctx that gives access to the payments of every organisation
+func WithSystemScope(ctx context.Context) context.Context {
+	return WithScope(ctx, Scope{System: true})
+}
+
+//ScopeFrom returns the scope of the context
+//ErrNoScope if the context has none or it has no organisations
+func ScopeFrom(ctx context.Context) (Scope, error) {
+	s, ok := ctx.Value(scopeKey{}).(Scope)
+	if !ok || (!s.System && len(s.Organisations) == 0) {
+		return Scope{}, ErrNoScope
+	}
+	return s, nil
+}
+
+//Allows returns true if the payments of the organisation can be accessed
+func (s Scope) Allows(organisationID string) bool {
+	if s.System {
+		return true
+	}
+	for _, o := range s.Organisations {
+		if o == organisationID {
+			return true
+		}
+	}
+	return false
+}
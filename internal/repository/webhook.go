@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/go-pg/pg"
+	"github.com/plusspeed/payments-api/internal/model"
+	"sort"
+	"time"
+)
+
+//ErrSubscriptionNotFound is returned when no webhook subscription is returned
+var ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+
+//ErrDeliveryNotFound is returned when no webhook delivery is returned
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+//WebhookStore contains the DB operations for the webhook subscriptions and their deliveries.
+//Like the payments, they are scoped to the organisations of the Scope of the context.
+//Repository stores them in postgres and Memory in memory.
+type WebhookStore interface {
+	CreateSubscription(ctx context.Context, s *model.Subscription) error
+	GetSubscription(ctx context.Context, id string) (*model.Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]model.Subscription, error)
+	//DeleteSubscription deletes the subscription and its deliveries
+	DeleteSubscription(ctx context.Context, id string) error
+	//CreateDeliveries stores the deliveries, skipping the ones of a subscription and an event already stored
+	CreateDeliveries(ctx context.Context, ds []model.Delivery) error
+	//ClaimDeliveries returns up to limit pending deliveries due at now, in the order of their events,
+	//and postpones their next attempt to now plus lease so they are not claimed again while they are posted
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Delivery, error)
+	GetDelivery(ctx context.Context, id string) (*model.Delivery, error)
+	//ListDeliveries returns the deliveries with the status ordered by creation
+	ListDeliveries(ctx context.Context, status model.DeliveryStatus) ([]model.Delivery, error)
+	//UpdateDelivery stores the status, the attempts and the error of a delivery
+	UpdateDelivery(ctx context.Context, d *model.Delivery) error
+}
+
+//CreateSubscription inserts a model.Subscription
+//ErrOutOfScope if its organisation is not in the scope of ctx, ErrAlreadyExists if a subscription with the same id exists
+func (d *Repository) CreateSubscription(ctx context.Context, sub *model.Subscription) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		if !s.Allows(sub.OrganisationID) {
+			return ErrOutOfScope
+		}
+		err := tx.Insert(sub)
+		if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == uniqueViolation {
+			return ErrAlreadyExists
+		}
+		return err
+	})
+}
+
+//GetSubscription returns a model.Subscription of an organisation of the scope of ctx
+//ErrSubscriptionNotFound if not found
+func (d *Repository) GetSubscription(ctx context.Context, id string) (*model.Subscription, error) {
+	sub := &model.Subscription{ID: id}
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(sub).WherePK(), s).Select()
+	})
+	if err == pg.ErrNoRows {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+//ListSubscriptions returns the subscriptions of the organisations of the scope of ctx ordered by creation
+func (d *Repository) ListSubscriptions(ctx context.Context) ([]model.Subscription, error) {
+	var subs []model.Subscription
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(&subs), s).Order("created_at ASC", "id ASC").Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+//DeleteSubscription deletes a subscription of an organisation of the scope of ctx, its deliveries are deleted by the foreign key
+//ErrSubscriptionNotFound if not found
+func (d *Repository) DeleteSubscription(ctx context.Context, id string) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		res, err := scopeQuery(tx.Model(&model.Subscription{ID: id}).WherePK(), s).Delete()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return ErrSubscriptionNotFound
+		}
+		return nil
+	})
+}
+
+//CreateDeliveries inserts the deliveries, the ones of a subscription and an event already stored are skipped
+//ErrOutOfScope if the organisation of a delivery is not in the scope of ctx
+func (d *Repository) CreateDeliveries(ctx context.Context, ds []model.Delivery) error {
+	if len(ds) == 0 {
+		return nil
+	}
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		for _, delivery := range ds {
+			if !s.Allows(delivery.OrganisationID) {
+				return ErrOutOfScope
+			}
+		}
+		_, err := tx.Model(&ds).OnConflict("(subscription_id, event_id) DO NOTHING").Insert()
+		return err
+	})
+}
+
+//ClaimDeliveries locks the due deliveries with SKIP LOCKED, so the instances of the app claim different deliveries
+func (d *Repository) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Delivery, error) {
+	var ds []model.Delivery
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		due := scopeQuery(tx.Model((*model.Delivery)(nil)).Column("id").
+			Where("status = ?", model.DeliveryPending).Where("next_attempt_at <= ?", now), s).
+			Order("event_id ASC", "id ASC").Limit(limit).For("UPDATE SKIP LOCKED")
+		_, err := tx.Model(&ds).Set("next_attempt_at = ?", now.Add(lease)).Where("id IN (?)", due).Returning("*").Update()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortDeliveries(ds)
+	return ds, nil
+}
+
+//GetDelivery returns a model.Delivery of an organisation of the scope of ctx
+//ErrDeliveryNotFound if not found
+func (d *Repository) GetDelivery(ctx context.Context, id string) (*model.Delivery, error) {
+	delivery := &model.Delivery{ID: id}
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(delivery).WherePK(), s).Select()
+	})
+	if err == pg.ErrNoRows {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+//ListDeliveries returns the deliveries with the status of the organisations of the scope of ctx ordered by creation
+func (d *Repository) ListDeliveries(ctx context.Context, status model.DeliveryStatus) ([]model.Delivery, error) {
+	var ds []model.Delivery
+	err := d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		return scopeQuery(tx.Model(&ds).Where("status = ?", status), s).Order("created_at ASC", "id ASC").Select()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+//UpdateDelivery stores the status, the attempts, the next attempt, the error and the delivery time of a delivery of an organisation of the scope of ctx.
+//The other fields of a delivery do not change.
+//ErrDeliveryNotFound if not found
+func (d *Repository) UpdateDelivery(ctx context.Context, delivery *model.Delivery) error {
+	return d.inScope(ctx, func(tx *pg.Tx, s Scope) error {
+		res, err := scopeQuery(tx.Model(delivery).Column("status", "attempts", "next_attempt_at", "last_error", "delivered_at").WherePK(), s).Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return ErrDeliveryNotFound
+		}
+		return nil
+	})
+}
+
+//sortDeliveries orders the deliveries by event, then by id
+func sortDeliveries(ds []model.Delivery) {
+	sort.Slice(ds, func(i, j int) bool {
+		if ds[i].EventID != ds[j].EventID {
+			return ds[i].EventID < ds[j].EventID
+		}
+		return ds[i].ID < ds[j].ID
+	})
+}
@@ -0,0 +1,200 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/outbox"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//Defaults of the Deliverer
+const (
+	DefaultMaxAttempts = 8
+	DefaultRetryDelay  = 10 * time.Second
+	DefaultMaxDelay    = time.Hour
+	DefaultConcurrency = 10
+	//lease is how long a claimed delivery is not claimed again, longer than the timeout of its post
+	lease = time.Minute
+)
+
+//Deliverer posts the pending deliveries to their subscriptions, signed with their secrets.
+//A failed delivery is tried again after an exponential backoff with jitter, until MaxAttempts, then it is dead.
+type Deliverer struct {
+	Store repository.WebhookStore
+	//Client posts the deliveries, a client of NewClient with a timeout of 10 seconds when nil
+	Client *http.Client
+	//Interval is the time between two reads of the deliveries due
+	Interval time.Duration
+	//Concurrency is the number of deliveries posted at the same time, DefaultConcurrency when 0
+	Concurrency int
+	//MaxAttempts is the number of attempts of a delivery before it is dead, DefaultMaxAttempts when 0
+	MaxAttempts int
+	//RetryDelay is the delay after the first failed attempt, doubled after each of the next ones up to MaxDelay
+	RetryDelay time.Duration
+	MaxDelay   time.Duration
+}
+
+//defaultClient posts the deliveries of a Deliverer without a Client
+var defaultClient = NewClient(10 * time.Second)
+
+//Run posts the deliveries due every Interval until ctx is done.
+//A failed delivery is logged and tried again after its backoff.
+func (d *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		n, err := d.DeliverDue(ctx)
+		if n > 0 {
+			logrus.WithField("deliveries", n).Debug("attempted the webhook deliveries")
+		}
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Warn("error delivering the webhooks")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//DeliverDue posts the deliveries due, up to Concurrency at the same time, until there are none left.
+//A delivery is claimed when a worker is free to post it, so its lease only covers its own post
+//and a slow receiver holds one worker instead of the deliveries of every organisation.
+//Returns the number of deliveries attempted, and the first error after which no more deliveries are claimed.
+func (d *Deliverer) DeliverDue(ctx context.Context) (int, error) {
+	workers := d.Concurrency
+	if workers <= 0 {
+		workers = DefaultConcurrency
+	}
+	ctx = repository.WithSystemScope(ctx)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		total    int
+		firstErr error
+	)
+	free := make(chan struct{}, workers)
+	for {
+		//waits for a free worker before claiming the next delivery
+		free <- struct{}{}
+		mu.Lock()
+		err := firstErr
+		mu.Unlock()
+		var ds []model.Delivery
+		if err == nil {
+			ds, err = d.Store.ClaimDeliveries(ctx, time.Now().UTC(), lease, 1)
+		}
+		if err != nil || len(ds) == 0 {
+			wg.Wait()
+			if firstErr == nil {
+				firstErr = err
+			}
+			return total, firstErr
+		}
+		wg.Add(1)
+		go func(delivery *model.Delivery) {
+			defer wg.Done()
+			err := d.attempt(ctx, delivery)
+			mu.Lock()
+			if err == nil {
+				total++
+			} else if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			<-free
+		}(&ds[0])
+	}
+}
+
+//attempt posts the delivery and stores its outcome
+func (d *Deliverer) attempt(ctx context.Context, delivery *model.Delivery) error {
+	sub, err := d.Store.GetSubscription(ctx, delivery.SubscriptionID)
+	if err == repository.ErrSubscriptionNotFound {
+		//the subscription was deleted with its deliveries after they were claimed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	now := time.Now().UTC()
+	delivery.Attempts++
+	if err := d.post(ctx, sub, delivery, now); err != nil {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= maxAttempts {
+			delivery.Status = model.DeliveryDead
+			logrus.WithError(err).WithField("delivery", delivery.ID).WithField("subscription", sub.ID).Warn("the webhook delivery is dead")
+		} else {
+			delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+		}
+	} else {
+		delivery.Status, delivery.DeliveredAt, delivery.LastError = model.DeliveryDelivered, &now, ""
+	}
+	err = d.Store.UpdateDelivery(ctx, delivery)
+	if err == repository.ErrDeliveryNotFound {
+		return nil
+	}
+	return err
+}
+
+//post sends the payload of the delivery to the url of the subscription, signed at now.
+//The delivery is done when the response has a 2xx status.
+func (d *Deliverer) post(ctx context.Context, sub *model.Subscription, delivery *model.Delivery, now time.Time) error {
+	req, err := http.NewRequest("POST", sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(outbox.EventIDHeader, strconv.FormatInt(delivery.EventID, 10))
+	req.Header.Set(outbox.EventTypeHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, timestamp, delivery.Payload))
+	client := d.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook returned the status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+//backoff returns the delay before the attempt after the failed one: RetryDelay doubled for each previous failure, up to MaxDelay,
+//of which a random half is taken away so the retries of many deliveries are spread
+func (d *Deliverer) backoff(attempts int) time.Duration {
+	delay := d.RetryDelay
+	for i := 1; i < attempts && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.MaxDelay {
+		delay = d.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+//ErrPrivateDestination is returned when the url of a webhook is, or resolves to, an address of the network of the app
+var ErrPrivateDestination = errors.New("the webhook url must not resolve to a loopback, private, link-local, unspecified or other special-purpose address")
+
+//deniedNetworks are the special-purpose ranges of the IANA registries (RFC 6890) the deliveries are not posted to
+var deniedNetworks = parseNetworks(
+	"0.0.0.0/8",       //this network
+	"10.0.0.0/8",      //private
+	"100.64.0.0/10",   //shared address space of the carrier-grade NATs
+	"127.0.0.0/8",     //loopback
+	"169.254.0.0/16",  //link-local
+	"172.16.0.0/12",   //private
+	"192.0.0.0/24",    //IETF protocol assignments
+	"192.0.2.0/24",    //documentation
+	"192.88.99.0/24",  //6to4 relay anycast
+	"192.168.0.0/16",  //private
+	"198.18.0.0/15",   //benchmarking
+	"198.51.100.0/24", //documentation
+	"203.0.113.0/24",  //documentation
+	"224.0.0.0/4",     //multicast
+	"240.0.0.0/4",     //reserved and broadcast
+	"::/128",          //unspecified
+	"::1/128",         //loopback
+	"64:ff9b:1::/48",  //local-use NAT64
+	"100::/64",        //discard-only
+	"2001::/23",       //IETF protocol assignments, with Teredo
+	"2001:db8::/32",   //documentation
+	"2002::/16",       //6to4, that embeds any IPv4 address
+	"fc00::/7",        //unique local
+	"fe80::/10",       //link-local
+	"fec0::/10",       //site-local
+	"ff00::/8",        //multicast
+)
+
+//nat64 is the well-known prefix of NAT64 (RFC 6052), its addresses reach the IPv4 address of their last 4 bytes
+var nat64 = parseNetworks("64:ff9b::/96")[0]
+
+//parseNetworks returns the networks of the CIDRs, it panics if one is not valid
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+//PublicIP returns true if the deliveries can be posted to the ip: it is not in one of the deniedNetworks.
+//The IPv4 addresses mapped to IPv6, and the ones embedded in the NAT64 addresses, are checked as IPv4 addresses.
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) != net.IPv6len {
+		return false
+	} else if nat64.Contains(ip) {
+		return PublicIP(ip[12:])
+	}
+	for _, network := range deniedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//CheckDestination returns ErrPrivateDestination if the host of a webhook url is, or resolves to, an address that is not public.
+//A name that does not resolve is not rejected, the address of each delivery is checked again when it is posted.
+func CheckDestination(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrPrivateDestination
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrPrivateDestination
+		}
+	}
+	return nil
+}
+
+//NewClient returns the client that posts the deliveries with the timeout.
+//It checks the address of each connection, after the name of the host is resolved, so a name that resolves
+//to another address than when its subscription was saved can not reach the network of the app.
+//It does not use a proxy and does not follow the redirects, a redirect is a failed delivery.
+func NewClient(timeout time.Duration) *http.Client {
+	return newClient(timeout, PublicIP)
+}
+
+//newClient returns a client that only connects to the addresses allowed
+func newClient(timeout time.Duration, allowed func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowed(ip) {
+				return ErrPrivateDestination
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pborman/uuid"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"time"
+)
+
+//Enqueuer is the outbox.Sink of the webhooks, it stores a delivery of an event for each subscription of its organisation that accepts its type.
+//The deliveries are posted by a Deliverer.
+type Enqueuer struct {
+	Store repository.WebhookStore
+}
+
+//Publish stores the deliveries of the event. An event published again does not make new deliveries.
+func (q *Enqueuer) Publish(ctx context.Context, e repository.Event) error {
+	ctx = repository.WithScope(ctx, repository.Scope{Organisations: []string{e.OrganisationID}})
+	subs, err := q.Store.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var ds []model.Delivery
+	for _, sub := range subs {
+		if !sub.Accepts(string(e.Type)) {
+			continue
+		}
+		ds = append(ds, model.Delivery{
+			ID:             uuid.NewRandom().String(),
+			SubscriptionID: sub.ID,
+			OrganisationID: e.OrganisationID,
+			EventID:        e.ID,
+			EventType:      string(e.Type),
+			Status:         model.DeliveryPending,
+			Payload:        payload,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+	return q.Store.CreateDeliveries(ctx, ds)
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+//Headers of the signature of a delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+//signaturePrefix is the scheme of the signatures in SignatureHeader
+const signaturePrefix = "sha256="
+
+//secretPrefix makes the secrets of the webhooks recognisable, like the tokens of the api keys
+const secretPrefix = "whsec_"
+
+//ErrInvalidSignature is returned by Verify when the signature is not the one of the body, or the timestamp is too old
+var ErrInvalidSignature = errors.New("the webhook signature is not valid")
+
+//NewSecret returns a random secret to sign the deliveries of a subscription
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//Sign returns the value of SignatureHeader of a delivery of the body at the unix timestamp of TimestampHeader:
+//sha256= and the hex of the HMAC-SHA256 with the secret of the timestamp, a dot and the body.
+//Signing the timestamp lets the receivers reject the deliveries replayed later.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Verify checks the signature and the timestamp headers of a delivery received at now, for the receivers of the webhooks.
+//ErrInvalidSignature if the signature does not match or the timestamp is further than tolerance from now
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > tolerance || d < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package webhook
+
+import (
+	"context"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":1}`)
+	signature := Sign("secret", now.Unix(), body)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, signature)
+
+	assert.Nil(t, Verify("secret", "1700000000", signature, body, time.Minute, now))
+	assert.Equal(t, ErrInvalidSignature, Verify("other", "1700000000", signature, body, time.Minute, now))
+	assert.Equal(t, ErrInvalidSignature, Verify("secret", "1700000000", signature, []byte(`{"id":2}`), time.Minute, now))
+	assert.Equal(t, ErrInvalidSignature, Verify("secret", "1700000001", signature, body, time.Minute, now), "the timestamp should be signed")
+	assert.Equal(t, ErrInvalidSignature, Verify("secret", "1700000000", signature, body, time.Minute, now.Add(time.Hour)), "an old delivery should be rejected")
+}
+
+//testClient posts to the test servers, on the loopback address
+var testClient = newClient(5*time.Second, func(net.IP) bool { return true })
+
+//receiver records the deliveries it receives and fails the first fail ones
+type receiver struct {
+	secret string
+	fail   int
+
+	mu       sync.Mutex
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	rc.received, rc.bodies = append(rc.received, r), append(rc.bodies, body)
+	if rc.fail > 0 {
+		rc.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if Verify(rc.secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, time.Minute, time.Now()) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//subscribe creates a subscription of the organisation 1 to the url, then publishes the events of a create and a delete of a payment
+func subscribe(t *testing.T, url string, eventTypes ...string) *repository.Memory {
+	ctx := repository.WithScope(context.Background(), repository.Scope{Organisations: []string{"1"}})
+	m := repository.NewMemory()
+	assert.Nil(t, m.CreateSubscription(ctx, &model.Subscription{ID: "s1", OrganisationID: "1", URL: url, EventTypes: eventTypes, Secret: "secret"}))
+	assert.Nil(t, m.Create(ctx, &model.Payment{ID: "1", OrganisationID: "1", Status: model.StatusDraft}))
+	assert.Nil(t, m.Delete(ctx, "1", repository.AnyVersion))
+	//the payments of another organisation are not delivered to the subscription
+	other := repository.WithScope(context.Background(), repository.Scope{Organisations: []string{"2"}})
+	assert.Nil(t, m.Create(other, &model.Payment{ID: "2", OrganisationID: "2", Status: model.StatusDraft}))
+
+	var events []repository.Event
+	_, err := m.PublishEvents(repository.WithSystemScope(context.Background()), 10, func(e repository.Event) error {
+		events = append(events, e)
+		return nil
+	})
+	assert.Nil(t, err)
+	q := &Enqueuer{Store: m}
+	for _, e := range events {
+		//the events published twice are delivered once
+		assert.Nil(t, q.Publish(context.Background(), e))
+		assert.Nil(t, q.Publish(context.Background(), e))
+	}
+	return m
+}
+
+func TestDeliverer(t *testing.T) {
+	rc := &receiver{secret: "secret"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	m := subscribe(t, srv.URL, string(repository.EventDeleted))
+
+	d := &Deliverer{Store: m, Client: testClient}
+	n, err := d.DeliverDue(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if assert.Equal(t, 1, len(rc.received)) {
+		r := rc.received[0]
+		assert.Equal(t, string(repository.EventDeleted), r.Header.Get("X-Event-Type"))
+		assert.NotEmpty(t, r.Header.Get(DeliveryHeader))
+		assert.Contains(t, string(rc.bodies[0]), `"payment_id":"1"`)
+	}
+
+	ctx := repository.WithSystemScope(context.Background())
+	delivered, err := m.ListDeliveries(ctx, model.DeliveryDelivered)
+	assert.Nil(t, err)
+	if assert.Equal(t, 1, len(delivered)) {
+		assert.Equal(t, 1, delivered[0].Attempts)
+		assert.NotNil(t, delivered[0].DeliveredAt)
+	}
+
+	n, err = d.DeliverDue(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n, "a delivered delivery should not be posted again")
+}
+
+func TestDeliverer_Concurrency(t *testing.T) {
+	release, deleted := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Event-Type") == string(repository.EventCreated) {
+			<-release
+		} else {
+			close(deleted)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	defer close(release)
+	m := subscribe(t, srv.URL, string(repository.EventCreated), string(repository.EventDeleted))
+
+	//a slow post does not hold the deliveries after it
+	done := make(chan int)
+	go func() {
+		n, err := (&Deliverer{Store: m, Client: testClient, Concurrency: 2}).DeliverDue(context.Background())
+		assert.Nil(t, err)
+		done <- n
+	}()
+	select {
+	case <-deleted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the second delivery should be posted while the first one waits")
+	}
+	release <- struct{}{}
+	assert.Equal(t, 2, <-done)
+}
+
+func TestDeliverer_Retries(t *testing.T) {
+	rc := &receiver{secret: "secret", fail: 3}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	m := subscribe(t, srv.URL, string(repository.EventCreated))
+	ctx := repository.WithSystemScope(context.Background())
+
+	//the delivery is tried again after its backoff
+	d := &Deliverer{Store: m, Client: testClient, MaxAttempts: 2, RetryDelay: time.Hour, MaxDelay: time.Hour}
+	before := time.Now()
+	_, err := d.DeliverDue(context.Background())
+	assert.Nil(t, err)
+	pending, _ := m.ListDeliveries(ctx, model.DeliveryPending)
+	if assert.Equal(t, 1, len(pending)) {
+		assert.Equal(t, 1, pending[0].Attempts)
+		assert.Contains(t, pending[0].LastError, "503")
+		assert.True(t, pending[0].NextAttemptAt.After(before.Add(30*time.Minute)), "the retry should wait for its backoff")
+	}
+	n, _ := d.DeliverDue(context.Background())
+	assert.Equal(t, 0, n)
+
+	//once every attempt failed the delivery is dead
+	d.RetryDelay = 0
+	delivery, _ := m.GetDelivery(ctx, pending[0].ID)
+	delivery.NextAttemptAt = time.Now().UTC()
+	assert.Nil(t, m.UpdateDelivery(ctx, delivery))
+	_, err = d.DeliverDue(context.Background())
+	assert.Nil(t, err)
+	dead, _ := m.ListDeliveries(ctx, model.DeliveryDead)
+	if assert.Equal(t, 1, len(dead)) {
+		assert.Equal(t, 2, dead[0].Attempts)
+	}
+
+	//a dead delivery replayed is delivered
+	dead[0].Status, dead[0].Attempts, dead[0].NextAttemptAt = model.DeliveryPending, 0, time.Now().UTC()
+	assert.Nil(t, m.UpdateDelivery(ctx, &dead[0]))
+	rc.mu.Lock()
+	rc.fail = 0
+	rc.mu.Unlock()
+	n, err = d.DeliverDue(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	delivered, _ := m.ListDeliveries(ctx, model.DeliveryDelivered)
+	assert.Equal(t, 1, len(delivered))
+}
+
+func TestDeliverer_Destination(t *testing.T) {
+	rc := &receiver{secret: "secret"}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	ctx := repository.WithSystemScope(context.Background())
+
+	//the default client does not connect to the loopback address, whatever the name resolves to
+	m := subscribe(t, srv.URL, string(repository.EventCreated))
+	_, err := (&Deliverer{Store: m}).DeliverDue(context.Background())
+	assert.Nil(t, err)
+	pending, _ := m.ListDeliveries(ctx, model.DeliveryPending)
+	if assert.Equal(t, 1, len(pending)) {
+		assert.Contains(t, pending[0].LastError, ErrPrivateDestination.Error())
+	}
+
+	//a redirect is not followed
+	redirect := httptest.NewServer(http.RedirectHandler(srv.URL, http.StatusFound))
+	defer redirect.Close()
+	m = subscribe(t, redirect.URL, string(repository.EventCreated))
+	_, err = (&Deliverer{Store: m, Client: testClient}).DeliverDue(context.Background())
+	assert.Nil(t, err)
+	pending, _ = m.ListDeliveries(ctx, model.DeliveryPending)
+	if assert.Equal(t, 1, len(pending)) {
+		assert.Contains(t, pending[0].LastError, "302")
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	assert.Empty(t, rc.received)
+}
+
+func TestCheckDestination(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "::", "localhost",
+		"100.64.0.1", "100.127.255.254", "0.1.2.3", "198.18.0.1", "198.19.255.255", "192.0.0.8", "203.0.113.5", "240.0.0.1", "255.255.255.255",
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1", "64:ff9b::7f00:1", "64:ff9b::a9fe:a9fe", "64:ff9b:1::1", "2001:db8::1", "2002:7f00:1::1", "ff02::1"} {
+		assert.Equal(t, ErrPrivateDestination, CheckDestination(context.Background(), host), host)
+	}
+	assert.False(t, PublicIP(nil))
+	for _, host := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946", "::ffff:93.184.216.34", "64:ff9b::5db8:d822", "100.128.0.1", "198.20.0.1"} {
+		assert.Nil(t, CheckDestination(context.Background(), host), host)
+	}
+}
+
+func TestDeliverer_Backoff(t *testing.T) {
+	d := &Deliverer{RetryDelay: 10 * time.Second, MaxDelay: time.Minute}
+	for attempts, max := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 10: time.Minute} {
+		for i := 0; i < 20; i++ {
+			delay := d.backoff(attempts)
+			assert.True(t, delay >= max/2 && delay <= max, "attempt %d: %s not in [%s, %s]", attempts, delay, max/2, max)
+		}
+	}
+}
@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"github.com/jawher/mow.cli"
 	"github.com/plusspeed/payments-api/internal/api"
+	"github.com/plusspeed/payments-api/internal/auth"
+	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/outbox"
 	"github.com/plusspeed/payments-api/internal/repository"
+	"github.com/plusspeed/payments-api/internal/webhook"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 )
 
@@ -18,6 +23,19 @@ const (
 	appDesc = "Allows create, read, update, get and list operations. Uses postgresql and got a health check."
 )
 
+//values of the storage option
+const (
+	storagePostgres = "postgres"
+	storageMemory   = "memory"
+)
+
+//values of the auth option
+const (
+	authAPIKey = "apikey"
+	authHeader = "header"
+	authJWT    = "jwt"
+)
+
 func main() {
 	app := cli.App(appName, appDesc)
 
@@ -53,6 +71,128 @@ func main() {
 		Value:  10,
 	})
 
+	requestTimeSec := app.Int(cli.IntOpt{
+		Name:   "request-timeout",
+		Desc:   "number of seconds a request waits for its db queries until they are cancelled. 0 means no deadline.",
+		EnvVar: "REQUEST_TIMEOUT",
+		Value:  5,
+	})
+	routeTimeouts := app.String(cli.StringOpt{
+		Name:   "route-timeouts",
+		Desc:   "deadlines of the routes that override request-timeout - eg. listPayments=10s,getPayment=500ms",
+		EnvVar: "ROUTE_TIMEOUTS",
+		Value:  "",
+	})
+
+	requireIfMatch := app.Bool(cli.BoolOpt{
+		Name:   "require-if-match",
+		Desc:   "rejects PUT and DELETE requests on a payment without a If-Match header.",
+		EnvVar: "REQUIRE_IF_MATCH",
+		Value:  false,
+	})
+
+	idempotencyTTLSec := app.Int(cli.IntOpt{
+		Name:   "idempotency-ttl",
+		Desc:   "number of seconds the response of a POST request with an Idempotency-Key header is replayed to its retries.",
+		EnvVar: "IDEMPOTENCY_TTL",
+		Value:  86400,
+	})
+
+	eventsSink := app.String(cli.StringOpt{
+		Name:   "events-sink",
+		Desc:   "where the events of the changes of the payments are published - eg. an http(s) URL they are posted to, stdout or file:<path> for local testing. If empty the events are only delivered to the webhooks.",
+		EnvVar: "EVENTS_SINK",
+		Value:  "",
+	})
+	eventsIntervalSec := app.Int(cli.IntOpt{
+		Name:   "events-interval",
+		Desc:   "number of seconds between two reads of the outbox by the publisher of the events, and of the webhook deliveries due.",
+		EnvVar: "EVENTS_INTERVAL",
+		Value:  1,
+	})
+	webhookMaxAttempts := app.Int(cli.IntOpt{
+		Name:   "webhook-max-attempts",
+		Desc:   "number of attempts of a webhook delivery before it is moved to the dead letters.",
+		EnvVar: "WEBHOOK_MAX_ATTEMPTS",
+		Value:  webhook.DefaultMaxAttempts,
+	})
+	webhookRetryDelaySec := app.Int(cli.IntOpt{
+		Name:   "webhook-retry-delay",
+		Desc:   "number of seconds before the second attempt of a webhook delivery, doubled after each failed attempt with a random jitter.",
+		EnvVar: "WEBHOOK_RETRY_DELAY",
+		Value:  int(webhook.DefaultRetryDelay / time.Second),
+	})
+	webhookMaxDelaySec := app.Int(cli.IntOpt{
+		Name:   "webhook-max-delay",
+		Desc:   "maximum number of seconds between two attempts of a webhook delivery.",
+		EnvVar: "WEBHOOK_MAX_DELAY",
+		Value:  int(webhook.DefaultMaxDelay / time.Second),
+	})
+	webhookConcurrency := app.Int(cli.IntOpt{
+		Name:   "webhook-concurrency",
+		Desc:   "number of webhook deliveries posted at the same time by an instance of the app.",
+		EnvVar: "WEBHOOK_CONCURRENCY",
+		Value:  webhook.DefaultConcurrency,
+	})
+
+	modulusWeights := app.String(cli.StringOpt{
+		Name:   "modulus-weights",
+		Desc:   "path of the VocaLink modulus weight table (valacdos.txt) used to check UK account numbers. If empty only their format is checked.",
+		EnvVar: "MODULUS_WEIGHTS",
+		Value:  "",
+	})
+
+	authMode := app.String(cli.StringOpt{
+		Name:   "auth",
+		Desc:   "how the requests on the payments are authenticated, one or more separated by commas tried in order - eg. apikey checks the X-API-Key header against the keys stored, jwt checks the bearer token of the Authorization header against the jwks, header trusts the organisations of the X-Organisation-ID header, set by a gateway that authenticates the callers.",
+		EnvVar: "AUTH",
+		Value:  authAPIKey,
+	})
+	jwksSource := app.String(cli.StringOpt{
+		Name:   "jwks",
+		Desc:   "path or http(s) URL of the JSON Web Key Set with the keys that sign the bearer tokens, required by the jwt auth.",
+		EnvVar: "JWKS",
+		Value:  "",
+	})
+	jwksTTLSec := app.Int(cli.IntOpt{
+		Name:   "jwks-ttl",
+		Desc:   "number of seconds the jwks is cached. It is loaded again earlier when a token is signed by a key that is not in it.",
+		EnvVar: "JWKS_TTL",
+		Value:  300,
+	})
+	jwtIssuer := app.String(cli.StringOpt{
+		Name:   "jwt-issuer",
+		Desc:   "the iss claim of the bearer tokens, required by the jwt auth.",
+		EnvVar: "JWT_ISSUER",
+		Value:  "",
+	})
+	jwtAudience := app.String(cli.StringOpt{
+		Name:   "jwt-audience",
+		Desc:   "the aud claim of the bearer tokens, required by the jwt auth.",
+		EnvVar: "JWT_AUDIENCE",
+		Value:  "",
+	})
+	jwtOrganisationsClaim := app.String(cli.StringOpt{
+		Name:   "jwt-organisations-claim",
+		Desc:   "the claim of the bearer tokens with the organisation ids, a string or a list of strings.",
+		EnvVar: "JWT_ORGANISATIONS_CLAIM",
+		Value:  auth.DefaultOrganisationsClaim,
+	})
+	jwtScopesClaim := app.String(cli.StringOpt{
+		Name:   "jwt-scopes-claim",
+		Desc:   "the claim of the bearer tokens with the scopes, separated by spaces or a list of strings.",
+		EnvVar: "JWT_SCOPES_CLAIM",
+		Value:  auth.DefaultScopesClaim,
+	})
+
+	//Storage
+	storage := app.String(cli.StringOpt{
+		Name:   "storage",
+		Desc:   "where the payments are stored - eg. postgres, or memory for local development. The payments in memory are lost when the app stops.",
+		EnvVar: "STORAGE",
+		Value:  storagePostgres,
+	})
+
 	//Postgres
 	pgAddress := app.String(cli.StringOpt{
 		Name:   "db-address",
@@ -100,19 +240,122 @@ func main() {
 		}
 		log.SetLevel(lvl)
 	}
+	//connect returns the repository of the postgres options
+	connect := func() *repository.Repository {
+		return repository.New(*pgAddress, *dbName, *pgUsername, *pgPassword)
+	}
+	app.Command("migrate", "applies, reverts and lists the versioned migrations of the postgres schema", migrateCommand(connect))
+	app.Command("apikey", "creates and revokes the api keys stored in postgres, e.g. the first admin key of an organisation", apiKeyCommand(connect))
+	app.Command("purge", "permanently removes the payments stored in postgres that were deleted before the retention period", purgeCommand(connect))
+
 	app.Action = func() {
 
+		if *modulusWeights != "" {
+			loadModulusWeights(*modulusWeights)
+		}
+
+		timeouts, err := api.ParseRouteTimeouts(*routeTimeouts)
+		if err != nil {
+			log.WithError(err).Panic("error parsing route timeouts")
+		}
+
 		//Created a new Repository.
-		repo := repository.New(*pgAddress, *dbName, *pgUsername, *pgPassword)
-		defer func() {
-			err := repo.Database.Close()
+		var repo repository.PaymentTransaction
+		var keys repository.KeyStore
+		var idempotency repository.IdempotencyStore
+		var audit repository.AuditLog
+		var events repository.Outbox
+		var changes repository.ChangeFeed
+		var webhooks repository.WebhookStore
+		switch *storage {
+		case storagePostgres:
+			db := connect()
+			defer func() {
+				err := db.Database.Close()
+				if err != nil {
+					log.WithError(err).Panic("error closing db")
+				}
+			}()
+			if err := db.CheckSchema(context.Background()); err != nil {
+				log.WithError(err).Panic("the db schema is not up to date, run payment-api migrate up")
+			}
+			repo, keys, idempotency, audit, events, changes, webhooks = db, db, db, db, db, db, db
+		case storageMemory:
+			log.Warn("the payments are stored in memory and will be lost when the app stops")
+			memory := repository.NewMemory()
+			repo, keys, idempotency, audit, events, changes, webhooks = memory, memory, memory, memory, memory, memory, memory
+		default:
+			log.Panicf("unknown storage %q, must be %s or %s", *storage, storagePostgres, storageMemory)
+		}
+
+		var authenticators auth.Authenticators
+		for _, mode := range strings.Split(*authMode, ",") {
+			switch strings.TrimSpace(mode) {
+			case authAPIKey:
+				authenticators = append(authenticators, auth.APIKeyAuthenticator{Keys: keys})
+			case authJWT:
+				if *jwksSource == "" || *jwtIssuer == "" || *jwtAudience == "" {
+					log.Panic("the jwt auth requires --jwks, --jwt-issuer and --jwt-audience")
+				}
+				authenticators = append(authenticators, auth.JWTAuthenticator{
+					Keys:               auth.NewJWKS(*jwksSource, time.Duration(*jwksTTLSec)*time.Second),
+					Issuer:             *jwtIssuer,
+					Audience:           *jwtAudience,
+					OrganisationsClaim: *jwtOrganisationsClaim,
+					ScopesClaim:        *jwtScopesClaim,
+				})
+			case authHeader:
+				authenticators = append(authenticators, auth.HeaderAuthenticator{})
+			default:
+				log.Panicf("unknown auth %q, must be %s, %s or %s", mode, authAPIKey, authJWT, authHeader)
+			}
+		}
+
+		if *eventsIntervalSec <= 0 {
+			log.Panic("events-interval must be positive")
+		}
+		if *webhookMaxAttempts <= 0 || *webhookConcurrency <= 0 || *webhookRetryDelaySec < 0 || *webhookMaxDelaySec < *webhookRetryDelaySec {
+			log.Panic("webhook-max-attempts and webhook-concurrency must be positive and webhook-max-delay at least webhook-retry-delay")
+		}
+		interval := time.Duration(*eventsIntervalSec) * time.Second
+		//the events are always enqueued to the webhooks of their organisations, and published to the sink when it is set
+		sinks := outbox.Sinks{&webhook.Enqueuer{Store: webhooks}}
+		if *eventsSink != "" {
+			sink, err := outbox.NewSink(*eventsSink)
 			if err != nil {
-				log.WithError(err).Panic("error closing db")
+				log.WithError(err).Panic("error opening the events sink")
 			}
-		}()
+			log.Infof("publishing the events to %s", *eventsSink)
+			sinks = append(sinks, sink)
+		}
+		publisher := &outbox.Publisher{Outbox: events, Sink: sinks, Interval: interval}
+		deliverer := &webhook.Deliverer{
+			Store:       webhooks,
+			Interval:    interval,
+			MaxAttempts: *webhookMaxAttempts,
+			RetryDelay:  time.Duration(*webhookRetryDelaySec) * time.Second,
+			MaxDelay:    time.Duration(*webhookMaxDelaySec) * time.Second,
+			Concurrency: *webhookConcurrency,
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go publisher.Run(ctx)
+		go deliverer.Run(ctx)
 
 		//Create a mux router
-		router := api.NewRouter(*pathPrefix, repo)
+		router := api.NewRouter(*pathPrefix, repo, api.Options{
+			RequireIfMatch: *requireIfMatch,
+			DefaultTimeout: time.Duration(*requestTimeSec) * time.Second,
+			RouteTimeouts:  timeouts,
+			Authenticator:  authenticators,
+			Keys:           keys,
+			Audit:          audit,
+			Idempotency:    idempotency,
+			IdempotencyTTL: time.Duration(*idempotencyTTLSec) * time.Second,
+			Changes:        changes,
+			WriteTimeout:   time.Duration(*writeTimeSec) * time.Second,
+			Webhooks:       webhooks,
+		})
 
 		//Creates a http server with handler as the router
 		addr := fmt.Sprintf("127.0.0.1:%d", *port)
@@ -147,6 +390,20 @@ func main() {
 	}
 }
 
+func loadModulusWeights(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.WithError(err).Panic("error opening modulus weight table")
+	}
+	defer f.Close()
+
+	table, err := model.ParseModulusTable(f)
+	if err != nil {
+		log.WithError(err).Panic("error reading modulus weight table")
+	}
+	model.SetModulusTable(table)
+}
+
 func waitForShutdown(gracefulTimeSec *int, srv *http.Server) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
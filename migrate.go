@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jawher/mow.cli"
+	"github.com/plusspeed/payments-api/internal/repository"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+//migrateCommand adds the up, down and status subcommands of migrate
+func migrateCommand(connect func() *repository.Repository) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		cmd.Command("up", "applies the migrations that were not applied", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				repo := connect()
+				defer repo.Database.Close()
+
+				applied, err := repo.MigrateUp(context.Background())
+				for _, m := range applied {
+					log.Infof("applied migration %d_%s", m.Version, m.Name)
+				}
+				if err != nil {
+					log.WithError(err).Panic("error applying the migrations")
+				}
+				if len(applied) == 0 {
+					log.Info("the schema is up to date")
+				}
+			}
+		})
+
+		cmd.Command("down", "reverts the last migrations applied", func(cmd *cli.Cmd) {
+			steps := cmd.Int(cli.IntOpt{
+				Name:  "steps",
+				Desc:  "number of migrations to revert",
+				Value: 1,
+			})
+			cmd.Action = func() {
+				repo := connect()
+				defer repo.Database.Close()
+
+				reverted, err := repo.MigrateDown(context.Background(), *steps)
+				for _, m := range reverted {
+					log.Infof("reverted migration %d_%s", m.Version, m.Name)
+				}
+				if err != nil {
+					log.WithError(err).Panic("error reverting the migrations")
+				}
+			}
+		})
+
+		cmd.Command("status", "lists the migrations and when they were applied", func(cmd *cli.Cmd) {
+			cmd.Action = func() {
+				repo := connect()
+				defer repo.Database.Close()
+
+				states, err := repo.MigrationStatus(context.Background())
+				if err != nil {
+					log.WithError(err).Panic("error reading the migrations")
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+				for _, s := range states {
+					appliedAt := "pending"
+					if s.Applied {
+						appliedAt = s.AppliedAt.Format(time.RFC3339)
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+				}
+				w.Flush()
+			}
+		})
+	}
+}
@@ -2,14 +2,17 @@ package main_test
 
 import (
 	"bytes"
-	"github.com/go-pg/pg/orm"
+	"context"
+	"encoding/json"
+	"github.com/go-pg/pg"
 	"github.com/gorilla/mux"
 	"github.com/pborman/uuid"
 	"github.com/plusspeed/payments-api/internal/api"
-	"github.com/plusspeed/payments-api/internal/model"
+	"github.com/plusspeed/payments-api/internal/auth"
 	"github.com/plusspeed/payments-api/internal/repository"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
@@ -32,6 +35,10 @@ const (
 var dbTest *repository.Repository
 var router *mux.Router
 
+//organisation is the organisation of the payments created, the requests are made by a caller of both organisations
+var organisation = "743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb"
+var otherOrganisation = uuid.NewRandom().String()
+
 var _ = Describe("Payment", func() {
 
 	BeforeEach(func() {
@@ -46,6 +53,15 @@ var _ = Describe("Payment", func() {
 		})
 	})
 
+	Describe("when I get the currencies", func() {
+		It("should return the ISO 4217 currencies", func() {
+			req, _ := http.NewRequest("GET", "/v1/currencies", nil)
+			response := executeRequest(*router, req)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Body.String()).To(ContainSubstring(`{"code":"GBP","number":"826","minor_units":2,"name":"Pound Sterling"}`))
+		})
+	})
+
 	Describe("when I create payments", func() {
 		var paymentID = uuid.NewRandom().String()
 
@@ -59,6 +75,44 @@ var _ = Describe("Payment", func() {
 			req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createBadRequest(paymentID)))
 			response := executeRequest(*router, req)
 			Expect(http.StatusBadRequest).To(Equal(response.Code))
+			Expect(response.Body.String()).To(ContainSubstring(`{"field":"attributes.beneficiary_party.account_name","rule":"required"`))
+		})
+
+		It("should return the offset if the body is not valid json", func() {
+			req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBufferString(`{"id": }`))
+			response := executeRequest(*router, req)
+			Expect(http.StatusBadRequest).To(Equal(response.Code))
+			Expect(response.Body.String()).To(ContainSubstring(`"rule":"json_syntax"`))
+			Expect(response.Body.String()).To(ContainSubstring(`"offset":8`))
+		})
+
+		It("should return Status Bad Request if an amount is not valid", func() {
+			for _, amount := range []string{`"abc"`, `"-100.21"`, `"100.211"`} {
+				body := strings.Replace(string(createRequest(paymentID)), `"amount": "100.21"`, `"amount": `+amount, 1)
+				req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBufferString(body))
+				response := executeRequest(*router, req)
+				Expect(http.StatusBadRequest).To(Equal(response.Code))
+			}
+		})
+
+		It("should return Status Bad Request if a currency is not ISO 4217", func() {
+			body := strings.Replace(string(createRequest(paymentID)), `"currency": "USD"`, `"currency": "ABC"`, 1)
+			req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBufferString(body))
+			response := executeRequest(*router, req)
+			Expect(http.StatusBadRequest).To(Equal(response.Code))
+		})
+
+		It("should return Status Bad Request if an account is not valid", func() {
+			for old, new := range map[string]string{
+				`"account_number": "GB29NWBK60161331926819"`: `"account_number": "GB29XABC10161234567801"`,
+				`"account_number": "31926819"`:               `"account_number": "3192681"`,
+				`"bank_id": "403000"`:                        `"bank_id": "40300A"`,
+			} {
+				body := strings.Replace(string(createRequest(paymentID)), old, new, 1)
+				req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBufferString(body))
+				response := executeRequest(*router, req)
+				Expect(http.StatusBadRequest).To(Equal(response.Code))
+			}
 		})
 
 		It("should return Status Created if request is valid", func() {
@@ -78,7 +132,7 @@ var _ = Describe("Payment", func() {
 				"\"id\": \""+paymentID+"\","+
 				"\"version\": "+newVersion+","+
 				"\"organisation_id\": \"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb\","+
-				"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Attributes for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}"))
+				"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Attributes for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}"))
 			response := executeRequest(*router, req)
 			Expect(http.StatusConflict).To(Equal(response.Code))
 		})
@@ -104,16 +158,32 @@ var _ = Describe("Payment", func() {
 					"\"id\": \""+paymentID+"\","+
 					"\"version\": "+newVersion+","+
 					"\"organisation_id\": \"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb\","+
-					"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Attributes for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}"))
+					"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Attributes for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}"))
 				response := executeRequest(*router, req)
 				Expect(http.StatusConflict).To(Equal(response.Code))
 			})
+
+			It("should return 304 if the ETag matches If-None-Match", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				req, _ := http.NewRequest("GET", "/v1/payment/"+paymentID, nil)
+				response := executeRequest(*router, req)
+				Expect(http.StatusOK).To(Equal(response.Code))
+				etag := response.Header().Get("ETag")
+				Expect(etag).NotTo(BeEmpty())
+
+				reqCached, _ := http.NewRequest("GET", "/v1/payment/"+paymentID, nil)
+				reqCached.Header.Set("If-None-Match", etag)
+				response = executeRequest(*router, reqCached)
+				Expect(http.StatusNotModified).To(Equal(response.Code))
+			})
 		})
 	})
 
 	Describe("when I update payment", func() {
 		var paymentID = uuid.NewRandom().String()
-		var organisationId = uuid.NewRandom().String()
+		var organisationId = otherOrganisation
 
 		It("should return 404 if does not exist", func() {
 			req, _ := http.NewRequest("GET", "/v1/payment/"+paymentID, bytes.NewBufferString(""))
@@ -138,6 +208,45 @@ var _ = Describe("Payment", func() {
 				Expect(response.Body.String()).To(ContainSubstring("\"id\":\"" + paymentID + "\""))
 				Expect(response.Body.String()).To(ContainSubstring("\"organisation_id\":\"" + organisationId + "\""))
 			})
+			It("should return 409 if the version is not the current one", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				reqUpdate, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequestUpdated(paymentID, organisationId)))
+				response := executeRequest(*router, reqUpdate)
+				Expect(http.StatusNoContent).To(Equal(response.Code))
+
+				reqStale, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequestUpdated(paymentID, organisationId)))
+				response = executeRequest(*router, reqStale)
+				Expect(http.StatusConflict).To(Equal(response.Code))
+				Expect(response.Body.String()).To(ContainSubstring("\"current_version\":1"))
+				Expect(response.Header().Get("Content-Type")).To(Equal("application/problem+json"))
+				Expect(response.Body.String()).To(ContainSubstring("\"code\":\"version_conflict\""))
+			})
+			It("should return the Response envelope if the client accepts application/json", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				reqUpdate, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequestUpdated(paymentID, organisationId)))
+				executeRequest(*router, reqUpdate)
+
+				reqStale, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequestUpdated(paymentID, organisationId)))
+				reqStale.Header.Set("Accept", "application/json")
+				response := executeRequest(*router, reqStale)
+				Expect(http.StatusConflict).To(Equal(response.Code))
+				Expect(response.Header().Get("Content-Type")).To(Equal("application/json"))
+				Expect(response.Body.String()).To(ContainSubstring("\"data\":{\"current_version\":1}"))
+				Expect(response.Body.String()).To(ContainSubstring("\"error_code\":\"version_conflict\""))
+			})
+			It("should return 412 if If-Match is not the current ETag", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				reqUpdate, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequestUpdated(paymentID, organisationId)))
+				reqUpdate.Header.Set("If-Match", "\"0-0000000000000000\"")
+				response := executeRequest(*router, reqUpdate)
+				Expect(http.StatusPreconditionFailed).To(Equal(response.Code))
+			})
 			It("should return Status Bad Request if request is invalid", func() {
 				req, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createBadRequest(paymentID)))
 				response := executeRequest(*router, req)
@@ -146,6 +255,66 @@ var _ = Describe("Payment", func() {
 		})
 	})
 
+	Describe("when I change the status of a payment", func() {
+		var paymentID = uuid.NewRandom().String()
+
+		It("should return 404 if does not exist", func() {
+			req, _ := http.NewRequest("POST", "/v1/payment/"+paymentID+"/status", bytes.NewBufferString(`{"status": "submitted"}`))
+			response := executeRequest(*router, req)
+			Expect(http.StatusNotFound).To(Equal(response.Code))
+		})
+
+		Context("after creating payment", func() {
+
+			It("should be created as a draft", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				req, _ := http.NewRequest("GET", "/v1/payment/"+paymentID, nil)
+				response := executeRequest(*router, req)
+				Expect(response.Body.String()).To(ContainSubstring("\"status\":\"draft\""))
+			})
+
+			It("should move through the allowed statuses and refuse the others", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				req, _ := http.NewRequest("POST", "/v1/payment/"+paymentID+"/status", bytes.NewBufferString(`{"status": "settled"}`))
+				response := executeRequest(*router, req)
+				Expect(http.StatusConflict).To(Equal(response.Code))
+
+				req, _ = http.NewRequest("POST", "/v1/payment/"+paymentID+"/status", bytes.NewBufferString(`{"status": "submitted"}`))
+				response = executeRequest(*router, req)
+				Expect(http.StatusConflict).To(Equal(response.Code))
+				Expect(response.Body.String()).To(ContainSubstring("\"code\":\"approval_required\""))
+
+				response = executeRequest(*router, approveRequest(paymentID))
+				Expect(http.StatusOK).To(Equal(response.Code))
+				Expect(response.Body.String()).To(ContainSubstring("\"status\":\"submitted\""))
+				Expect(response.Body.String()).To(ContainSubstring("\"by\":\"approver\""))
+
+				req, _ = http.NewRequest("POST", "/v1/payment/"+paymentID+"/status", bytes.NewBufferString(`{"status": "settled"}`))
+				response = executeRequest(*router, req)
+				Expect(http.StatusOK).To(Equal(response.Code))
+
+				req, _ = http.NewRequest("POST", "/v1/payment/"+paymentID+"/status", bytes.NewBufferString(`{"status": "unknown"}`))
+				response = executeRequest(*router, req)
+				Expect(http.StatusBadRequest).To(Equal(response.Code))
+			})
+
+			It("should not allow to edit a submitted payment", func() {
+				reqCreate, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentID)))
+				executeRequest(*router, reqCreate)
+
+				executeRequest(*router, approveRequest(paymentID))
+
+				reqUpdate, _ := http.NewRequest("PUT", "/v1/payment/"+paymentID, bytes.NewBuffer(createRequest(paymentID)))
+				response := executeRequest(*router, reqUpdate)
+				Expect(http.StatusConflict).To(Equal(response.Code))
+			})
+		})
+	})
+
 	Describe("when I delete payment", func() {
 		var paymentId = uuid.NewRandom().String()
 
@@ -210,12 +379,37 @@ var _ = Describe("Payment", func() {
 			Expect(response.Body.String()).To(ContainSubstring("\"id\":\"" + paymentId2 + "\""))
 			Expect(http.StatusOK).To(Equal(response.Code))
 		})
+
+		It("should return the next page with the cursor of the links", func() {
+			var paymentId1 = uuid.NewRandom().String()
+			var paymentId2 = uuid.NewRandom().String()
+
+			reqPayment1, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentId1)))
+			executeRequest(*router, reqPayment1)
+			reqPayment2, _ := http.NewRequest("POST", "/v1/payment", bytes.NewBuffer(createRequest(paymentId2)))
+			executeRequest(*router, reqPayment2)
+
+			req, _ := http.NewRequest("GET", "/v1/payments?limit=1", nil)
+			response := executeRequest(*router, req)
+			Expect(http.StatusOK).To(Equal(response.Code))
+			Expect(response.Body.String()).To(ContainSubstring("\"next\":"))
+
+			var first struct {
+				Links api.Links `json:"links"`
+			}
+			Expect(json.Unmarshal(response.Body.Bytes(), &first)).To(Succeed())
+			req, _ = http.NewRequest("GET", first.Links.Next[strings.Index(first.Links.Next, "/"):], nil)
+			response = executeRequest(*router, req)
+			Expect(http.StatusOK).To(Equal(response.Code))
+			Expect(response.Body.String()).To(ContainSubstring("\"prev\":"))
+			Expect(response.Body.String()).NotTo(ContainSubstring("\"next\":"))
+		})
 	})
 })
 
 var _ = BeforeSuite(func() {
 	dbTest = repository.New(pgAddress, "test", pgUsername, pgPassword)
-	router = api.NewRouter(basePath, dbTest)
+	router = api.NewRouter(basePath, dbTest, api.Options{Authenticator: auth.HeaderAuthenticator{}})
 })
 
 var _ = AfterSuite(func() {
@@ -223,10 +417,16 @@ var _ = AfterSuite(func() {
 })
 
 func clearDB(dbTest repository.Repository) {
-	_, _ = dbTest.Database.Exec("DROP TABLE PAYMENT")
-
-	err := dbTest.Database.CreateTable(&model.Payment{}, &orm.CreateTableOptions{
-		IfNotExists: true,
+	if _, err := dbTest.MigrateUp(context.Background()); err != nil {
+		panic(err.Error())
+	}
+	//the row level security policy hides the payments when the test user is not a superuser
+	err := dbTest.Database.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec("SELECT set_config('app.system', 'on', true)"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM payments")
+		return err
 	})
 	if err != nil {
 		panic(err.Error())
@@ -234,18 +434,30 @@ func clearDB(dbTest repository.Repository) {
 }
 
 func executeRequest(router mux.Router, req *http.Request) *httptest.ResponseRecorder {
+	req.Header.Set(auth.OrganisationHeader, organisation+","+otherOrganisation)
+	if req.Header.Get(auth.SubjectHeader) == "" {
+		req.Header.Set(auth.SubjectHeader, "maker")
+	}
 	rr := httptest.NewRecorder()
 	router.ServeHTTP(rr, req)
 
 	return rr
 }
 
+//approveRequest returns the approval of the payment by an approver that is not its maker
+func approveRequest(paymentID string) *http.Request {
+	req, _ := http.NewRequest("POST", "/v1/payment/"+paymentID+"/approve", nil)
+	req.Header.Set(auth.SubjectHeader, "approver")
+	req.Header.Set(auth.RolesHeader, auth.RoleApprover)
+	return req
+}
+
 func createRequest(paymentId string) []byte {
 	return []byte("{\"type\": \"Payment\"," +
 		"\"id\": \"" + paymentId + "\"," +
 		"\"version\": 0," +
 		"\"organisation_id\": \"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb\"," +
-		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
+		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
 }
 
 func createRequestUpdated(paymentId, organisationId string) []byte {
@@ -253,7 +465,7 @@ func createRequestUpdated(paymentId, organisationId string) []byte {
 		"\"id\": \"" + paymentId + "\"," +
 		"\"version\": 0," +
 		"\"organisation_id\": \"" + organisationId + "\"," +
-		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
+		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_name\": \"W Owens\",\"account_number\": \"31926819\",\"account_number_code\": \"BBAN\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
 }
 
 func createBadRequest(paymentId string) []byte {
@@ -261,5 +473,5 @@ func createBadRequest(paymentId string) []byte {
 		"\"id\": \"" + paymentId + "\"," +
 		"\"version\": 0," +
 		"\"organisation_id\": \"743d5b63-8e6f-432e-a8fa-c5d8d2ee5fcb\"," +
-		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_number\": \"31926819\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29XABC10161234567801\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
+		"\"attributes\": {\"amount\": \"100.21\",\"beneficiary_party\": {\"account_number\": \"31926819\",\"account_type\": 0,\"address\": \"1 The Beneficiary Localtown SE2\",\"bank_id\": \"403000\",\"bank_id_code\": \"GBDSC\",\"name\": \"Wilfred Jeremiah Owens\"},\"charges_information\": {\"bearer_code\": \"SHAR\",\"sender_charges\": [{\"amount\": \"5.00\",\"currency\": \"GBP\"},{\"amount\": \"10.00\",\"currency\": \"USD\"}],\"receiver_charges_amount\": \"1.00\",\"receiver_charges_currency\": \"USD\"},\"currency\": \"GBP\",\"debtor_party\": {\"account_name\": \"EJ Brown Black\",\"account_number\": \"GB29NWBK60161331926819\",\"account_number_code\": \"IBAN\",\"address\": \"10 Debtor Crescent Sourcetown NE1\",\"bank_id\": \"203301\",\"bank_id_code\": \"GBDSC\",\"name\": \"Emelia Jane Brown\"},\"end_to_end_reference\": \"Wil piano Jan\",\"fx\": {\"contract_reference\": \"FX123\",\"exchange_rate\": \"2.00000\",\"original_amount\": \"200.42\",\"original_currency\": \"USD\"},\"numeric_reference\": \"1002001\",\"payment_id\": \"123456789012345678\",\"payment_purpose\": \"Paying for goods/services\",\"payment_scheme\": \"FPS\",\"payment_type\": \"Credit\",\"processing_date\": \"2017-01-18\",\"reference\": \"Payment for Em's piano lessons\",\"scheme_payment_sub_type\": \"InternetBanking\",\"scheme_payment_type\": \"ImmediatePayment\",\"sponsor_party\": {\"account_number\": \"56781234\",\"bank_id\": \"123123\",\"bank_id_code\": \"GBDSC\"}}}")
 }
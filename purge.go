@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"github.com/jawher/mow.cli"
+	"github.com/plusspeed/payments-api/internal/repository"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+//purgeActor is the actor of the audit entries of the payments purged
+const purgeActor = "purge"
+
+//purgeCommand removes the payments deleted for longer than the retention, with their tombstones.
+//It runs in the system scope, on the payments of every organisation, e.g. from a daily cron job.
+func purgeCommand(connect func() *repository.Repository) func(*cli.Cmd) {
+	return func(cmd *cli.Cmd) {
+		olderThanSec := cmd.Int(cli.IntOpt{
+			Name:   "older-than",
+			Desc:   "number of seconds a deleted payment is kept and can be restored before it is purged.",
+			EnvVar: "PURGE_OLDER_THAN",
+			Value:  30 * 24 * 60 * 60,
+		})
+		cmd.Action = func() {
+			if *olderThanSec < 0 {
+				log.Panic("older-than can not be negative")
+			}
+			repo := connect()
+			defer repo.Database.Close()
+
+			ctx := repository.WithActor(repository.WithSystemScope(context.Background()), repository.Actor{Subject: purgeActor})
+			cutoff := time.Now().UTC().Add(-time.Duration(*olderThanSec) * time.Second)
+			purged, err := repo.Purge(ctx, cutoff)
+			if err != nil {
+				log.WithError(err).Panic("error purging the deleted payments")
+			}
+			log.Infof("purged %d payments deleted before %s", purged, cutoff.Format(time.RFC3339))
+		}
+	}
+}